@@ -1,27 +1,46 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"time"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 
 	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/audit"
 	"github.com/wjzhangq/claude-gateway/internal/auth"
 	"github.com/wjzhangq/claude-gateway/internal/db"
 	"github.com/wjzhangq/claude-gateway/internal/handler"
 	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/metrics"
 	"github.com/wjzhangq/claude-gateway/internal/middleware"
 	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/pricing"
 	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/quota"
+	"github.com/wjzhangq/claude-gateway/internal/ratelimit"
 	"github.com/wjzhangq/claude-gateway/internal/stats"
+	"github.com/wjzhangq/claude-gateway/internal/tracing"
 )
 
 func main() {
+	migrateOnly := flag.Bool("migrate-only", false, "apply pending database migrations and exit")
+	dryRun := flag.Bool("dry-run", false, "with --migrate-only, list pending migrations without applying them")
+	flag.Parse()
+	if *dryRun && !*migrateOnly {
+		log.Fatal("--dry-run requires --migrate-only")
+	}
+
 	cfgPath := "config/config.yaml"
 	if v := os.Getenv("CONFIG_PATH"); v != "" {
 		cfgPath = v
@@ -38,11 +57,23 @@ func main() {
 		logger.Fatalf("create data dir: %v", err)
 	}
 
-	database, err := db.Init(cfg.Database.Path)
+	if cfg.Auth.APIKeySecret == cfg.Auth.SessionSecret {
+		logger.Warn("auth.api_key_secret is unset or equal to auth.session_secret: changing session_secret will invalidate every API key; " +
+			"set api_key_secret to the current session_secret to rotate them independently")
+	}
+	keyStore := auth.NewKeyStore(cfg.Auth.APIKeySecret)
+	database, err := db.Open(cfg.Database.Path)
 	if err != nil {
-		logger.Fatalf("failed to init database: %v", err)
+		logger.Fatalf("failed to open database: %v", err)
+	}
+	database.SetKeyHasher(keyStore.Hash, auth.KeyHint)
+	if err := migrate(database, *dryRun); err != nil {
+		logger.Fatalf("failed to migrate database: %v", err)
+	}
+	if *migrateOnly {
+		database.Close()
+		return
 	}
-	defer database.Close()
 
 	if cfg.Auth.AdminItcode != "" {
 		if err := database.EnsureAdmin(cfg.Auth.AdminItcode); err != nil {
@@ -50,38 +81,144 @@ func main() {
 		}
 	}
 
-	keyStore := auth.NewKeyStore()
 	if err := loadKeyStore(database, keyStore); err != nil {
 		logger.Fatalf("load key store: %v", err)
 	}
 
 	codeStore := auth.NewCodeStore(cfg.Auth.CodeExpiry)
 
+	var stopTracing func(context.Context) error
+	if cfg.Tracing.Enabled {
+		if stopTracing, err = tracing.Init(cfg.Tracing); err != nil {
+			logger.Fatalf("tracing: %v", err)
+		}
+		logger.Infof("tracing enabled, exporting to %s (sample ratio %g)", cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio)
+	}
+
 	if cfg.Server.Mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	r := gin.New()
 	r.Use(gin.Recovery())
+	r.Use(middleware.RequestID())
 	r.Use(middleware.RequestLogger())
 
 	store := cookie.NewStore([]byte(cfg.Auth.SessionSecret))
 	r.Use(sessions.Sessions("gateway_session", store))
 	r.Use(sessionLoader())
 
-	collector := stats.NewCollector(database, 1024)
+	collector := stats.NewCollector(database, cfg.UsageLog)
+
+	quotaTracker, err := quota.NewTracker(cfg.Quota.ResetPeriod)
+	if err != nil {
+		logger.Fatalf("init quota tracker: %v", err)
+	}
+	used, err := database.SumTokensByUserSince(quotaTracker.PeriodStart())
+	if err != nil {
+		logger.Fatalf("load quota usage: %v", err)
+	}
+	quotaTracker.Seed(used)
+	collector.Subscribe(func(r stats.Record) {
+		quotaTracker.Add(r.UserID, int64(r.TotalTokens))
+	})
+
+	limiter := ratelimit.NewLimiter(rateLimits(cfg.RateLimit.User), rateLimits(cfg.RateLimit.Key))
+	overrides, err := database.ListRateLimits()
+	if err != nil {
+		logger.Fatalf("load rate limits: %v", err)
+	}
+	limiter.SetOverrides(overrides)
+	collector.Subscribe(func(r stats.Record) {
+		// Cache reads do not count towards input token limits.
+		limiter.Record(int64(r.InputTokens+r.CacheCreationTokens), int64(r.OutputTokens),
+			ratelimit.Subject{Scope: ratelimit.ScopeUser, ID: r.UserID},
+			ratelimit.Subject{Scope: ratelimit.ScopeKey, ID: r.APIKeyID})
+	})
 
 	aggregator := stats.NewAggregator(database, cfg.UsageSync)
 	aggregator.Start()
 
-	lb := proxy.NewLoadBalancer(cfg.Backends)
-	proxyH := proxy.NewHandler(lb, collector, cfg.ModelReplacements)
+	declaredPrices, err := configPrices(database, cfg.Pricing)
+	if err != nil {
+		logger.Fatalf("load price table: %v", err)
+	}
+	if err := storeConfig(database, declaredPrices, configBackends(cfg.Backends)); err != nil {
+		logger.Fatalf("store config: %v", err)
+	}
+	prices := pricing.NewTable()
+	allPrices, err := database.ListModelPrices()
+	if err != nil {
+		logger.Fatalf("load price table: %v", err)
+	}
+	if err := prices.Load(allPrices); err != nil {
+		logger.Fatalf("load price table: %v", err)
+	}
+	backends, err := storedBackends(database)
+	if err != nil {
+		logger.Fatalf("load backends: %v", err)
+	}
+	lb := proxy.NewLoadBalancer(backends, cfg.HealthCheck)
+	if err := lb.SetStrategy(cfg.LoadBalancer.Strategy); err != nil {
+		logger.Fatalf("load balancer: %v", err)
+	}
+	rewriter, err := proxy.NewRewriter(cfg.ModelRewrites, cfg.ModelReplacements)
+	if err != nil {
+		logger.Fatalf("model rewrites: %v", err)
+	}
+	proxyH := proxy.NewHandler(lb, collector, rewriter, cfg.DefaultModels, cfg.Retry, prices)
 	lb.ValidateBackends()
 
+	var auditStore *audit.Store
+	var auditRec *audit.Recorder
+	if cfg.Audit.Enabled {
+		if auditStore, err = audit.OpenStore(cfg.Audit.Path); err != nil {
+			logger.Fatalf("audit: %v", err)
+		}
+		if auditRec, err = audit.NewRecorder(auditStore, cfg.Audit); err != nil {
+			logger.Fatalf("audit: %v", err)
+		}
+		proxyH.SetAuditRecorder(auditRec)
+		logger.Infof("audit logging enabled, captures stored in %s", cfg.Audit.Path)
+	}
+
+	var metricsSrv *http.Server
+	if cfg.Metrics.Enabled {
+		gm := metrics.NewGateway(database, lb, keyStore, collector)
+		database.SetQueryObserver(gm.ObserveQuery)
+		collector.Subscribe(gm.ObserveUsage)
+		r.Use(gm.Middleware())
+		if cfg.Metrics.Listen != "" {
+			mr := gin.New()
+			mr.Use(gin.Recovery())
+			mr.GET("/metrics", gm.Handler(cfg.Metrics.Token))
+			metricsSrv = &http.Server{Addr: cfg.Metrics.Listen, Handler: mr}
+			go func() {
+				logger.Infof("metrics listening on %s", cfg.Metrics.Listen)
+				if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Errorf("metrics server: %v", err)
+				}
+			}()
+		} else {
+			r.GET("/metrics", gm.Handler(cfg.Metrics.Token))
+		}
+	}
+
 	authH := handler.NewAuthHandler(database, codeStore, &cfg.Auth)
 	keyH := handler.NewAPIKeyHandler(database, keyStore)
-	userH := handler.NewUserHandler(database)
-	statsH := handler.NewStatsHandler(database)
-	appH := handler.NewApplicationHandler(database)
+	userH := handler.NewUserHandler(database, keyStore, quotaTracker)
+	statsH := handler.NewStatsHandler(database, quotaTracker, collector, lb)
+	appH := handler.NewApplicationHandler(database, keyStore)
+	pricingH := handler.NewPricingHandler(database, prices)
+	rateLimitH := handler.NewRateLimitHandler(database, limiter)
+	backendH := handler.NewBackendHandler(database, lb)
+	auditH := handler.NewAuditHandler(auditStore)
+
+	reloader := newReloader(cfgPath, cfg, database, lb, proxyH, authH, limiter, prices)
+	go reloader.watchSignals()
+	if cfg.Server.ConfigCheckInterval > 0 {
+		go reloader.watchFile(cfg.Server.ConfigCheckInterval)
+	}
+	configH := handler.NewConfigHandler(reloader)
 
 	apiAuth := r.Group("/api/auth")
 	apiAuth.Use(middleware.RateLimit(10, time.Minute))
@@ -91,8 +228,18 @@ func main() {
 		apiAuth.POST("/logout", authH.Logout)
 	}
 
+	drainer := middleware.NewDrainer()
+	r.GET("/healthz", middleware.Healthz)
+	r.GET("/readyz", middleware.Readyz(drainer))
+
 	v1 := r.Group("/v1")
+	if cfg.Tracing.Enabled {
+		v1.Use(tracing.Middleware())
+	}
+	v1.Use(middleware.DrainMiddleware(drainer))
 	v1.Use(middleware.AuthMiddleware(keyStore))
+	v1.Use(middleware.QuotaMiddleware(quotaTracker))
+	v1.Use(middleware.APIRateLimit(limiter))
 	{
 		v1.Any("/*path", proxyH.Passthrough)
 	}
@@ -120,11 +267,31 @@ func main() {
 		adminAPI.GET("/users/:id", userH.GetUser)
 		adminAPI.POST("/users", userH.CreateUser)
 		adminAPI.PUT("/users/:id", userH.UpdateUser)
+		adminAPI.GET("/users/:id/models", userH.ListUserModels)
+		adminAPI.POST("/users/:id/models", userH.GrantUserModel)
+		adminAPI.DELETE("/users/:id/models", userH.RevokeUserModel)
 		adminAPI.GET("/usage", statsH.GetUsage)
 		adminAPI.GET("/usage/daily", statsH.GetDailyStats)
+		adminAPI.GET("/usage/collector", statsH.GetCollectorStats)
 		adminAPI.GET("/backends/stats", statsH.GetBackendStats)
+		adminAPI.GET("/backends", backendH.ListBackends)
+		adminAPI.POST("/backends", backendH.CreateBackend)
+		adminAPI.PUT("/backends/:id", backendH.UpdateBackend)
+		adminAPI.DELETE("/backends/:id", backendH.DeleteBackend)
 		adminAPI.GET("/applications", appH.ListAll)
 		adminAPI.PUT("/applications/:id/review", appH.Review)
+		adminAPI.GET("/pricing", pricingH.ListPrices)
+		adminAPI.POST("/pricing", pricingH.CreatePrice)
+		adminAPI.PUT("/pricing/:id", pricingH.UpdatePrice)
+		adminAPI.DELETE("/pricing/:id", pricingH.DeletePrice)
+		adminAPI.POST("/pricing/recompute", pricingH.Recompute)
+		adminAPI.GET("/rate-limits", rateLimitH.ListRateLimits)
+		adminAPI.PUT("/rate-limits", rateLimitH.SetRateLimit)
+		adminAPI.DELETE("/rate-limits/:id", rateLimitH.DeleteRateLimit)
+		adminAPI.GET("/audit", auditH.ListCaptures)
+		adminAPI.GET("/audit/:id", auditH.GetCapture)
+		adminAPI.GET("/config", configH.GetConfig)
+		adminAPI.POST("/config/reload", configH.ReloadConfig)
 	}
 
 	// Serve frontend static files
@@ -135,9 +302,106 @@ func main() {
 	})
 
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
-	logger.Infof("Claude Gateway listening on %s", addr)
-	if err := r.Run(addr); err != nil {
+	srv := &http.Server{Addr: addr, Handler: r}
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Infof("Claude Gateway listening on %s", addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
 		logger.Fatalf("server error: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process immediately
+
+	shutdown(srv, drainer, collector, aggregator, database, cfg.Server.ShutdownTimeout)
+	if auditRec != nil {
+		closeAudit(auditRec, auditStore)
+	}
+	if stopTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := stopTracing(ctx); err != nil {
+			logger.Errorf("flush traces: %v", err)
+		}
+		cancel()
+	}
+	if metricsSrv != nil {
+		metricsSrv.Close()
+	}
+}
+
+// shutdown drains the server: readiness fails and new /v1 requests get 503
+// while in-flight requests and streams finish, up to timeout. Then the HTTP
+// server stops, queued usage records are written, daily stats are aggregated
+// one last time and the database is closed.
+func shutdown(srv *http.Server, drainer *middleware.Drainer, collector *stats.Collector, aggregator *stats.Aggregator, database *db.DB, timeout time.Duration) {
+	logger.Infof("shutting down, draining %d in-flight requests (timeout %s)", drainer.InFlight(), timeout)
+	drainer.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if !drainer.Wait(ctx) {
+		logger.Warnf("drain timeout reached, closing %d in-flight requests", drainer.InFlight())
+	}
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		logger.Errorf("http server shutdown: %v", err)
+	}
+	srv.Close()
+	// Handlers cut off by Close still record their partial usage; give them a moment.
+	graceCtx, cancelGrace := context.WithTimeout(context.Background(), 2*time.Second)
+	drainer.Wait(graceCtx)
+	cancelGrace()
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFlush()
+	if err := collector.Close(flushCtx); err != nil {
+		logger.Errorf("%v", err)
+	}
+	aggregator.Stop()
+	if err := database.Close(); err != nil {
+		logger.Errorf("close database: %v", err)
+	}
+	logger.Info("shutdown complete")
+}
+
+// migrate applies pending database migrations, or with dryRun only lists
+// them. It fails if the database was migrated by a newer gateway.
+func migrate(database *db.DB, dryRun bool) error {
+	if dryRun {
+		pending, err := database.PendingMigrations()
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			logger.Infof("pending database migration %s", m)
+		}
+		logger.Infof("%d pending database migrations, target schema version %d", len(pending), db.LatestVersion())
+		return nil
+	}
+	applied, err := database.Migrate()
+	for _, m := range applied {
+		logger.Infof("applied database migration %s", m)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Infof("database schema version %d", db.LatestVersion())
+	return nil
+}
+
+// closeAudit writes the captures still queued and closes the capture database.
+func closeAudit(rec *audit.Recorder, store *audit.Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := rec.Close(ctx); err != nil {
+		logger.Errorf("%v", err)
+	}
+	if err := store.Close(); err != nil {
+		logger.Errorf("close audit database: %v", err)
 	}
 }
 
@@ -150,6 +414,10 @@ func loadKeyStore(database *db.DB, ks *auth.KeyStore) error {
 	if err != nil {
 		return err
 	}
+	models, err := database.ListAllUserModels()
+	if err != nil {
+		return err
+	}
 	userMap := make(map[int64]*model.User, len(users))
 	for _, u := range users {
 		userMap[u.ID] = u
@@ -158,10 +426,117 @@ func loadKeyStore(database *db.DB, ks *auth.KeyStore) error {
 	for i, k := range keys {
 		apiKeys[i] = *k
 	}
-	ks.Load(apiKeys, userMap)
+	ks.Load(apiKeys, userMap, models)
 	return nil
 }
 
+// configPrices returns the price entries the config file stores in the
+// database: the built-in defaults if the database has none yet, followed by
+// the configured prices.
+func configPrices(database *db.DB, configured []config.ModelPrice) ([]*model.ModelPrice, error) {
+	existing, err := database.ListModelPrices()
+	if err != nil {
+		return nil, err
+	}
+	var result []*model.ModelPrice
+	if len(existing) == 0 {
+		result = pricing.Defaults()
+	}
+	for _, p := range configured {
+		cacheWrite1h := p.CacheWrite1h
+		if cacheWrite1h == 0 {
+			cacheWrite1h = pricing.CacheWrite1hPrice(p.Input, p.CacheWrite)
+		}
+		price := &model.ModelPrice{
+			Model:             p.Model,
+			InputPrice:        p.Input,
+			OutputPrice:       p.Output,
+			CacheWritePrice:   p.CacheWrite,
+			CacheWrite1hPrice: cacheWrite1h,
+			CacheReadPrice:    p.CacheRead,
+			EffectiveFrom:     p.EffectiveFrom,
+		}
+		if err := pricing.Validate(price); err != nil {
+			return nil, fmt.Errorf("price for %q: %w", p.Model, err)
+		}
+		result = append(result, price)
+	}
+	return result, nil
+}
+
+// configBackends converts the backends of the config file for storing.
+func configBackends(configured []config.BackendAPI) []*model.Backend {
+	result := make([]*model.Backend, len(configured))
+	for i, c := range configured {
+		result[i] = &model.Backend{
+			Name:        c.Name,
+			URL:         c.URL,
+			APIKey:      c.APIKey,
+			Weight:      c.Weight,
+			Priority:    c.Priority,
+			MaxInFlight: c.MaxInFlight,
+			Enabled:     c.Enabled,
+			Protocol:    c.Protocol,
+			Models:      c.Models,
+		}
+	}
+	return result
+}
+
+// storeConfig stores prices and backends from the config file in the
+// database in one transaction, so either both are stored or neither is. Nil
+// leaves that table as it is.
+func storeConfig(database *db.DB, prices []*model.ModelPrice, backends []*model.Backend) error {
+	return database.Update(func(tx *db.Tx) error {
+		for _, p := range prices {
+			if err := tx.UpsertModelPrice(p); err != nil {
+				return err
+			}
+		}
+		if backends == nil {
+			return nil
+		}
+		return tx.UpsertBackends(backends)
+	})
+}
+
+// storedBackends returns every backend stored in the database, config file
+// and admin console alike.
+func storedBackends(database *db.DB) ([]config.BackendAPI, error) {
+	stored, err := database.ListBackends()
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		logger.Warn("no backends configured; add one in the admin console")
+	}
+
+	result := make([]config.BackendAPI, len(stored))
+	for i, b := range stored {
+		result[i] = config.BackendAPI{
+			Name:        b.Name,
+			URL:         b.URL,
+			APIKey:      b.APIKey,
+			Weight:      b.Weight,
+			Priority:    b.Priority,
+			MaxInFlight: b.MaxInFlight,
+			Enabled:     b.Enabled,
+			Protocol:    b.Protocol,
+			Models:      b.Models,
+		}
+	}
+	return result, nil
+}
+
+func rateLimits(c config.RateLimits) ratelimit.Limits {
+	return ratelimit.Limits{
+		RequestsPerMinute:     c.RequestsPerMinute,
+		InputTokensPerMinute:  c.InputTokensPerMinute,
+		OutputTokensPerMinute: c.OutputTokensPerMinute,
+		MaxConcurrent:         c.MaxConcurrent,
+	}
+}
+
 func sessionLoader() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sess := sessions.Default(c)
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/handler"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/pricing"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/ratelimit"
+)
+
+// liveSettings are the settings a reload applies to the running gateway.
+// Other changes take effect after a restart.
+var liveSettings = []string{
+	"log",
+	"auth.invite_code",
+	"model_rewrites",
+	"model_replacements",
+	"rate_limit",
+	"pricing",
+	"load_balancer",
+	"backends",
+}
+
+func isLive(c config.Change) bool {
+	return changed([]config.Change{c}, liveSettings...)
+}
+
+// reloader re-reads the config file on SIGHUP, when the file or a secret file
+// it names changes, or on request from the admin console, and swaps in the
+// settings that can change while requests are being served. Environment
+// variables are those the process started with, so changing them needs a
+// restart.
+type reloader struct {
+	path     string
+	database *db.DB
+	lb       *proxy.LoadBalancer
+	proxyH   *proxy.Handler
+	authH    *handler.AuthHandler
+	limiter  *ratelimit.Limiter
+	prices   *pricing.Table
+
+	mu    sync.Mutex
+	cfg   *config.Config // settings in force; restart-only changes are left out
+	files []string       // secret files named by the last valid config file
+	sum   [sha256.Size]byte
+}
+
+func newReloader(path string, cfg *config.Config, database *db.DB, lb *proxy.LoadBalancer, proxyH *proxy.Handler,
+	authH *handler.AuthHandler, limiter *ratelimit.Limiter, prices *pricing.Table) *reloader {
+	r := &reloader{path: path, cfg: cfg, files: cfg.Files(), database: database, lb: lb, proxyH: proxyH, authH: authH, limiter: limiter, prices: prices}
+	if data, err := os.ReadFile(path); err == nil {
+		r.sum = fingerprint(data, r.files)
+	}
+	return r
+}
+
+// Current returns the config in force.
+func (r *reloader) Current() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// Reload reads the config file and applies the live settings that changed.
+// Changes to other settings are returned as pending. If the file is invalid
+// nothing is applied and the running config is kept.
+func (r *reloader) Reload() (applied, pending []config.Change, err error) {
+	return r.reload("admin console")
+}
+
+// reload is Reload, logging the outcome. trigger says what caused the reload.
+func (r *reloader) reload(trigger string) (applied, pending []config.Change, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := os.ReadFile(r.path)
+	if err == nil {
+		applied, pending, err = r.apply(data)
+		r.sum = fingerprint(data, r.files)
+	} else {
+		err = fmt.Errorf("read config file: %w", err)
+	}
+	logChanges(trigger, applied, pending, err)
+	return applied, pending, err
+}
+
+func (r *reloader) apply(data []byte) (applied, pending []config.Change, err error) {
+	next, err := config.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.files = next.Files()
+	changes, err := config.Diff(r.cfg, next)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range changes {
+		if isLive(c) {
+			applied = append(applied, c)
+		} else {
+			pending = append(pending, c)
+		}
+	}
+	if len(applied) == 0 {
+		return nil, pending, nil
+	}
+
+	// Prepare and validate the new settings.
+	var rewriter *proxy.Rewriter
+	if changed(applied, "model_rewrites", "model_replacements") {
+		if rewriter, err = proxy.NewRewriter(next.ModelRewrites, next.ModelReplacements); err != nil {
+			return nil, nil, fmt.Errorf("model rewrites: %w", err)
+		}
+	}
+	if changed(applied, "load_balancer") {
+		if err := proxy.ValidateStrategy(next.LoadBalancer.Strategy); err != nil {
+			return nil, nil, fmt.Errorf("load balancer: %w", err)
+		}
+	}
+	var prices []*model.ModelPrice
+	var backends []*model.Backend
+	if changed(applied, "pricing") {
+		if prices, err = configPrices(r.database, next.Pricing); err != nil {
+			return nil, nil, fmt.Errorf("load prices: %w", err)
+		}
+	}
+	if changed(applied, "backends") {
+		backends = configBackends(next.Backends)
+	}
+
+	// Store prices and backends in the database, then read back what the
+	// gateway should use. If this fails nothing live has changed; whatever
+	// was committed is stored again by the next reload, as it is diffed
+	// against the settings still in force.
+	if err := storeConfig(r.database, prices, backends); err != nil {
+		return nil, nil, fmt.Errorf("store config: %w", err)
+	}
+	var allPrices []*model.ModelPrice
+	if changed(applied, "pricing") {
+		if allPrices, err = r.database.ListModelPrices(); err != nil {
+			return nil, nil, fmt.Errorf("load prices: %w", err)
+		}
+	}
+	var stored []config.BackendAPI
+	if changed(applied, "backends") {
+		if stored, err = storedBackends(r.database); err != nil {
+			return nil, nil, fmt.Errorf("load backends: %w", err)
+		}
+	}
+
+	// Swap in the new settings. Loading prices is the only step that can
+	// still fail, and it leaves the table untouched when it does.
+	if changed(applied, "pricing") {
+		if err := r.prices.Load(allPrices); err != nil {
+			return nil, nil, fmt.Errorf("load prices: %w", err)
+		}
+	}
+	if changed(applied, "backends") {
+		r.lb.Replace(stored)
+		warnRemovedBackends(r.cfg.Backends, next.Backends)
+	}
+	if changed(applied, "load_balancer") {
+		_ = r.lb.SetStrategy(next.LoadBalancer.Strategy) // validated above
+	}
+	if changed(applied, "log") {
+		logger.Init(next.Log.Level, next.Log.Format)
+	}
+	if changed(applied, "auth.invite_code") {
+		r.authH.SetInviteCode(next.Auth.InviteCode)
+	}
+	if rewriter != nil {
+		r.proxyH.SetRewriter(rewriter)
+	}
+	if changed(applied, "rate_limit") {
+		r.limiter.SetDefaults(rateLimits(next.RateLimit.User), rateLimits(next.RateLimit.Key))
+	}
+
+	active := *r.cfg
+	active.Log = next.Log
+	active.Auth.InviteCode = next.Auth.InviteCode
+	active.ModelRewrites = next.ModelRewrites
+	active.ModelReplacements = next.ModelReplacements
+	active.RateLimit = next.RateLimit
+	active.Pricing = next.Pricing
+	active.LoadBalancer = next.LoadBalancer
+	active.Backends = next.Backends
+	r.cfg = &active
+	return applied, pending, nil
+}
+
+// changed reports whether any of changes is below one of settings.
+func changed(changes []config.Change, settings ...string) bool {
+	for _, c := range changes {
+		for _, s := range settings {
+			if c.Path == s || strings.HasPrefix(c.Path, s+".") || strings.HasPrefix(c.Path, s+"[") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func logChanges(trigger string, applied, pending []config.Change, err error) {
+	if err != nil {
+		logger.Errorf("config reload (%s) rejected, keeping the running config: %v", trigger, err)
+		return
+	}
+	for _, c := range applied {
+		logger.Infof("config reload (%s): %s", trigger, c)
+	}
+	for _, c := range pending {
+		logger.Warnf("config reload (%s): %s takes effect after a restart", trigger, c)
+	}
+	if len(applied) == 0 && len(pending) == 0 {
+		logger.Infof("config reload (%s): nothing changed", trigger)
+	}
+}
+
+// watchSignals reloads the config on every SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		r.reload("SIGHUP")
+	}
+}
+
+// watchFile reloads the config whenever the contents of the file or of the
+// secret files it names change. Contents are compared rather than
+// modification times, so a file replaced through a symlink swap, as
+// Kubernetes does for mounted ConfigMaps and Secrets, is noticed too.
+func (r *reloader) watchFile(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		data, err := os.ReadFile(r.path)
+		if err != nil {
+			continue
+		}
+		r.mu.Lock()
+		if fingerprint(data, r.files) == r.sum {
+			r.mu.Unlock()
+			continue
+		}
+		applied, pending, err := r.apply(data)
+		r.sum = fingerprint(data, r.files)
+		r.mu.Unlock()
+		logChanges("file changed", applied, pending, err)
+	}
+}
+
+// fingerprint hashes the config file's contents and those of the secret
+// files it names. A file that cannot be read hashes as its error, so it
+// appearing or disappearing counts as a change.
+func fingerprint(data []byte, files []string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(data)
+	for _, f := range files {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			content = []byte(err.Error())
+		}
+		fmt.Fprintf(h, "\x00%s\x00%d\x00", f, len(content))
+		h.Write(content)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// warnRemovedBackends logs the backends that were dropped from the config
+// file. They stay in the database, so they keep serving until deleted in the
+// admin console.
+func warnRemovedBackends(old, next []config.BackendAPI) {
+	kept := make(map[string]bool, len(next))
+	for _, b := range next {
+		kept[b.Name] = true
+	}
+	for _, b := range old {
+		if !kept[b.Name] {
+			logger.Warnf("config reload: backend %q was removed from the config file but is kept; delete it in the admin console", b.Name)
+		}
+	}
+}
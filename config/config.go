@@ -3,6 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -10,18 +14,41 @@ import (
 
 // Config is the root configuration structure.
 type Config struct {
-	Server            ServerConfig      `yaml:"server"`
-	Database          DatabaseConfig    `yaml:"database"`
-	Log               LogConfig         `yaml:"log"`
-	Auth              AuthConfig        `yaml:"auth"`
-	Backends          []BackendAPI      `yaml:"backends"`
-	UsageSync         time.Duration     `yaml:"usage_sync_time"`
-	ModelReplacements map[string]string `yaml:"model_replacements"`
+	Server            ServerConfig       `yaml:"server"`
+	Database          DatabaseConfig     `yaml:"database"`
+	Log               LogConfig          `yaml:"log"`
+	Auth              AuthConfig         `yaml:"auth"`
+	Backends          []BackendAPI       `yaml:"backends"`
+	UsageSync         time.Duration      `yaml:"usage_sync_time"`
+	ModelReplacements map[string]string  `yaml:"model_replacements"` // legacy substring rules, tried after ModelRewrites
+	ModelRewrites     []ModelRewrite     `yaml:"model_rewrites"`
+	Quota             QuotaConfig        `yaml:"quota"`
+	DefaultModels     []string           `yaml:"default_models"` // models every user may call; exact names or globs
+	Retry             RetryConfig        `yaml:"retry"`
+	Pricing           []ModelPrice       `yaml:"pricing"` // upserted into the price table at startup
+	RateLimit         RateLimitConfig    `yaml:"rate_limit"`
+	UsageLog          UsageLogConfig     `yaml:"usage_log"`
+	Metrics           MetricsConfig      `yaml:"metrics"`
+	HealthCheck       HealthCheckConfig  `yaml:"health_check"`
+	LoadBalancer      LoadBalancerConfig `yaml:"load_balancer"`
+	Audit             AuditConfig        `yaml:"audit"`
+	Tracing           TracingConfig      `yaml:"tracing"`
+
+	files []string // read for *_file settings
+}
+
+// Files returns the files that settings were read from through *_file keys.
+func (c *Config) Files() []string {
+	return c.files
 }
 
 type ServerConfig struct {
-	Port int    `yaml:"port"`
-	Mode string `yaml:"mode"` // debug | release
+	Port            int           `yaml:"port"`
+	Mode            string        `yaml:"mode"`             // debug | release
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // how long in-flight requests may run after SIGTERM
+	// ConfigCheckInterval is how often the config file is checked for changes
+	// to reload; 0 disables the check, SIGHUP still reloads.
+	ConfigCheckInterval time.Duration `yaml:"config_check_interval"`
 }
 
 type DatabaseConfig struct {
@@ -35,6 +62,7 @@ type LogConfig struct {
 
 type AuthConfig struct {
 	SessionSecret  string        `yaml:"session_secret"`
+	APIKeySecret   string        `yaml:"api_key_secret"`  // HMAC secret for stored API keys; defaults to session_secret
 	SessionMaxAge  int           `yaml:"session_max_age"` // seconds
 	CodeExpiry     time.Duration `yaml:"code_expiry"`     // verification code TTL
 	AdminItcode    string        `yaml:"admin_itcode"`
@@ -42,13 +70,134 @@ type AuthConfig struct {
 	InviteCode     string        `yaml:"invite_code"`
 }
 
+type QuotaConfig struct {
+	ResetPeriod string `yaml:"reset_period"` // monthly | weekly | never
+}
+
+// RetryConfig controls failover to other backends before any response bytes reach the client.
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"` // total attempts, including the first
+	Timeout     time.Duration `yaml:"timeout"`      // no new attempt is started after this much time
+}
+
+// ModelPrice is a price entry in USD per million tokens.
+type ModelPrice struct {
+	Model         string  `yaml:"model"` // exact model name or glob
+	Input         float64 `yaml:"input"`
+	Output        float64 `yaml:"output"`
+	CacheWrite    float64 `yaml:"cache_write"`    // 5-minute cache
+	CacheWrite1h  float64 `yaml:"cache_write_1h"` // 1-hour cache; 0 means twice the input price when cache_write is set
+	CacheRead     float64 `yaml:"cache_read"`
+	EffectiveFrom string  `yaml:"effective_from"` // YYYY-MM-DD; empty means always
+}
+
+// RateLimitConfig holds the /v1 rate limits of users and API keys that have no
+// override in the rate_limits table.
+type RateLimitConfig struct {
+	User RateLimits `yaml:"user"`
+	Key  RateLimits `yaml:"key"`
+}
+
+// RateLimits caps request and token rates. Zero means unlimited.
+type RateLimits struct {
+	RequestsPerMinute     int64 `yaml:"requests_per_minute"`
+	InputTokensPerMinute  int64 `yaml:"input_tokens_per_minute"`
+	OutputTokensPerMinute int64 `yaml:"output_tokens_per_minute"`
+	MaxConcurrent         int64 `yaml:"max_concurrent"` // in-flight requests
+}
+
+// UsageLogConfig controls how usage records are written to the database.
+type UsageLogConfig struct {
+	BufferSize    int           `yaml:"buffer_size"`    // records queued in memory before spilling to disk
+	BatchSize     int           `yaml:"batch_size"`     // records per transaction
+	FlushInterval time.Duration `yaml:"flush_interval"` // a partial batch is written after this long
+	SpillPath     string        `yaml:"spill_path"`     // overflow file, replayed into the database
+}
+
+// MetricsConfig controls the Prometheus /metrics endpoint. It is served on its
+// own listener when Listen is set, otherwise on the main port behind Token.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"` // e.g. 127.0.0.1:9090
+	Token   string `yaml:"token"`  // bearer token required to scrape
+}
+
+// HealthCheckConfig controls each backend's circuit breaker and active probes.
+type HealthCheckConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"` // consecutive failures that open the circuit
+	SuccessThreshold int           `yaml:"success_threshold"` // consecutive half-open successes that close it
+	OpenTimeout      time.Duration `yaml:"open_timeout"`      // how long an open circuit rejects traffic before a trial request
+	ProbeInterval    time.Duration `yaml:"probe_interval"`    // 0 disables active probes
+	ProbeTimeout     time.Duration `yaml:"probe_timeout"`
+	ProbeModel       string        `yaml:"probe_model"` // probe with a one-token message to this model instead of GET /v1/models
+	// ModelRefreshInterval is how often each backend's GET /v1/models list is
+	// refreshed for model-aware routing; 0 disables discovery.
+	ModelRefreshInterval time.Duration `yaml:"model_refresh_interval"`
+}
+
+// Model rewrite match types.
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchGlob   = "glob"
+	MatchRegex  = "regex"
+)
+
+// ModelRewrite maps a requested model to the model sent upstream. Rules are
+// tried in order and the first match wins; rules scoped to API keys are tried
+// first, then rules scoped to users, then unscoped rules.
+type ModelRewrite struct {
+	Match   string   `yaml:"match"` // exact (default) | prefix | glob | regex
+	Pattern string   `yaml:"pattern"`
+	Target  string   `yaml:"target"`   // replaces the whole model; regex targets may use $1 or ${name}
+	Users   []string `yaml:"users"`    // itcodes the rule applies to; empty applies to everyone
+	APIKeys []int64  `yaml:"api_keys"` // API key IDs the rule applies to
+}
+
+// LoadBalancerConfig selects how requests are spread across backends.
+type LoadBalancerConfig struct {
+	// Strategy is one of weighted_random (default), least_in_flight, peak_ewma,
+	// p2c (power of two choices) or priority.
+	Strategy string `yaml:"strategy"`
+}
+
+// AuditConfig controls capture of request and response bodies for compliance
+// review. Captures are stored in their own SQLite database. Only requests from
+// the listed users and API keys are captured unless All is set.
+type AuditConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	All          bool          `yaml:"all"`      // capture every request
+	Users        []string      `yaml:"users"`    // itcodes whose requests are captured
+	APIKeys      []int64       `yaml:"api_keys"` // API key IDs whose requests are captured
+	Path         string        `yaml:"path"`
+	MaxBodyBytes int           `yaml:"max_body_bytes"` // per body after redaction; longer bodies are truncated
+	Retention    time.Duration `yaml:"retention"`      // captures older than this are deleted; 0 keeps them
+	Redact       []string      `yaml:"redact"`         // regexes whose matches are replaced with [REDACTED]
+	RedactPII    bool          `yaml:"redact_pii"`     // also redact emails, phone and ID numbers, card numbers and API keys
+	BufferSize   int           `yaml:"buffer_size"`    // captures queued for writing; further captures are dropped
+}
+
+// TracingConfig controls OpenTelemetry tracing of /v1 requests. Spans are
+// exported over OTLP/HTTP.
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Endpoint    string            `yaml:"endpoint"`     // collector URL, e.g. http://otel-collector:4318; /v1/traces is used when no path is given
+	Headers     map[string]string `yaml:"headers"`      // sent with every export, e.g. for authentication
+	SampleRatio float64           `yaml:"sample_ratio"` // share of new traces recorded; incoming sampled traces are always continued
+	ServiceName string            `yaml:"service_name"`
+}
+
 // BackendAPI represents a single upstream Claude API endpoint.
 type BackendAPI struct {
-	Name    string `yaml:"name"`
-	URL     string `yaml:"url"`
-	APIKey  string `yaml:"api_key"`
-	Weight  int    `yaml:"weight"`
-	Enabled bool   `yaml:"enabled"`
+	Name        string   `yaml:"name"`
+	URL         string   `yaml:"url"`
+	APIKey      string   `yaml:"api_key"`
+	Weight      int      `yaml:"weight"`
+	Enabled     bool     `yaml:"enabled"`
+	Protocol    string   `yaml:"protocol"`      // anthropic (default) | openai
+	Models      []string `yaml:"models"`        // model allowlist (names or globs); empty serves every model
+	Priority    int      `yaml:"priority"`      // tier for the priority strategy; 1 is tried first
+	MaxInFlight int      `yaml:"max_in_flight"` // concurrent requests above which the backend is saturated; 0 = no limit
 }
 
 // Load reads and parses the YAML config file at path.
@@ -57,13 +206,39 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read config file: %w", err)
 	}
+	return Parse(data)
+}
 
-	cfg := defaultConfig()
-	if err := yaml.Unmarshal(data, cfg); err != nil {
+// Parse parses and validates YAML config data, applying defaults for settings
+// it leaves out. ${NAME} references in values are replaced by environment
+// variables, key_file entries are replaced by key set to the named file's
+// contents, and EnvPrefix variables override the result. Validation errors
+// name where the offending value came from.
+func Parse(data []byte) (*Config, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("parse config file: %w", err)
 	}
+	src := make(sources)
+	cfg := defaultConfig()
+	if doc.Kind != 0 {
+		if err := resolveNode(&doc, "", src, &cfg.files); err != nil {
+			return nil, fmt.Errorf("parse config file: %w", err)
+		}
+		if err := doc.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parse config file: %w", err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), "", "", src); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
 	if err := validate(cfg); err != nil {
+		// Validation errors start with the path of the setting at fault.
+		path, _, _ := strings.Cut(err.Error(), " ")
+		if from := src.of(strings.TrimSuffix(path, ":")); from != "" {
+			err = fmt.Errorf("%w (from %s)", err, from)
+		}
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
@@ -73,8 +248,10 @@ func Load(path string) (*Config, error) {
 func defaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port: 8080,
-			Mode: "release",
+			Port:                8080,
+			Mode:                "release",
+			ShutdownTimeout:     30 * time.Second,
+			ConfigCheckInterval: 10 * time.Second,
 		},
 		Database: DatabaseConfig{
 			Path: "data/gateway.db",
@@ -88,9 +265,43 @@ func defaultConfig() *Config {
 			CodeExpiry:    5 * time.Minute,
 		},
 		UsageSync: 5 * time.Minute,
+		Quota: QuotaConfig{
+			ResetPeriod: "monthly",
+		},
+		DefaultModels: []string{"*"},
+		UsageLog: UsageLogConfig{
+			BufferSize:    4096,
+			BatchSize:     200,
+			FlushInterval: time.Second,
+			SpillPath:     "data/usage-spill.jsonl",
+		},
+		Retry: RetryConfig{
+			MaxAttempts: 3,
+			Timeout:     30 * time.Second,
+		},
+		HealthCheck: HealthCheckConfig{
+			FailureThreshold:     5,
+			SuccessThreshold:     1,
+			OpenTimeout:          30 * time.Second,
+			ProbeInterval:        30 * time.Second,
+			ProbeTimeout:         15 * time.Second,
+			ModelRefreshInterval: 10 * time.Minute,
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+			ServiceName: "claude-gateway",
+		},
+		Audit: AuditConfig{
+			Path:         "data/audit.db",
+			MaxBodyBytes: 1 << 20,
+			Retention:    30 * 24 * time.Hour,
+			BufferSize:   256,
+		},
 	}
 }
 
+// validate checks cfg and fills in derived defaults. Every error starts with
+// the path of the setting at fault, as in Change.Path.
 func validate(cfg *Config) error {
 	if cfg.Server.Port <= 0 || cfg.Server.Port > 65535 {
 		return fmt.Errorf("server.port must be between 1 and 65535")
@@ -98,10 +309,100 @@ func validate(cfg *Config) error {
 	if cfg.Auth.SessionSecret == "" {
 		return fmt.Errorf("auth.session_secret is required")
 	}
-	if len(cfg.Backends) == 0 {
-		return fmt.Errorf("at least one backend is required")
+	if cfg.Auth.APIKeySecret == "" {
+		// Kept for existing deployments, whose keys were hashed with the
+		// session secret; the gateway warns about the coupling at startup.
+		cfg.Auth.APIKeySecret = cfg.Auth.SessionSecret
+	}
+	switch cfg.Quota.ResetPeriod {
+	case "monthly", "weekly", "never":
+	default:
+		return fmt.Errorf("quota.reset_period must be monthly, weekly or never")
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Listen == "" && cfg.Metrics.Token == "" {
+		return fmt.Errorf("metrics.token or metrics.listen is required when metrics are enabled")
+	}
+	if cfg.HealthCheck.FailureThreshold < 1 || cfg.HealthCheck.SuccessThreshold < 1 {
+		return fmt.Errorf("health_check.failure_threshold and success_threshold must be at least 1")
+	}
+	switch cfg.LoadBalancer.Strategy {
+	case "":
+		cfg.LoadBalancer.Strategy = "weighted_random"
+	case "weighted_random", "least_in_flight", "peak_ewma", "p2c", "priority":
+	default:
+		return fmt.Errorf("load_balancer.strategy must be weighted_random, least_in_flight, peak_ewma, p2c or priority")
 	}
+	if cfg.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("retry.max_attempts must be at least 1")
+	}
+	if cfg.Retry.MaxAttempts > 1 && cfg.Retry.Timeout <= 0 {
+		// The deadline would pass before the first retry, disabling failover.
+		return fmt.Errorf("retry.timeout must be positive when retry.max_attempts is more than 1")
+	}
+	for i, r := range cfg.ModelRewrites {
+		if r.Pattern == "" || r.Target == "" {
+			return fmt.Errorf("model_rewrites[%d]: pattern and target are required", i)
+		}
+		switch r.Match {
+		case "":
+			cfg.ModelRewrites[i].Match = MatchExact
+		case MatchExact, MatchPrefix:
+		case MatchGlob:
+			if _, err := path.Match(r.Pattern, ""); err != nil {
+				return fmt.Errorf("model_rewrites[%d].pattern is not a valid glob: %v", i, err)
+			}
+		case MatchRegex:
+			if _, err := regexp.Compile(r.Pattern); err != nil {
+				return fmt.Errorf("model_rewrites[%d].pattern is not a valid regex: %v", i, err)
+			}
+		default:
+			return fmt.Errorf("model_rewrites[%d].match must be exact, prefix, glob or regex", i)
+		}
+	}
+	if cfg.Audit.MaxBodyBytes < 0 || cfg.Audit.Retention < 0 {
+		return fmt.Errorf("audit.max_body_bytes and audit.retention must not be negative")
+	}
+	for i, expr := range cfg.Audit.Redact {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("audit.redact[%d] is not a valid regex: %v", i, err)
+		}
+	}
+	if cfg.Tracing.Enabled && cfg.Tracing.Endpoint == "" {
+		return fmt.Errorf("tracing.endpoint is required when tracing is enabled")
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	for scope, l := range map[string]RateLimits{"user": cfg.RateLimit.User, "key": cfg.RateLimit.Key} {
+		if l.RequestsPerMinute < 0 || l.InputTokensPerMinute < 0 || l.OutputTokensPerMinute < 0 || l.MaxConcurrent < 0 {
+			return fmt.Errorf("rate_limit.%s limits must not be negative", scope)
+		}
+	}
+	for i, p := range cfg.Pricing {
+		if p.Model == "" {
+			return fmt.Errorf("pricing[%d].model is required", i)
+		}
+		if _, err := path.Match(p.Model, ""); err != nil {
+			return fmt.Errorf("pricing[%d].model is not a valid pattern: %v", i, err)
+		}
+		if p.EffectiveFrom != "" {
+			if _, err := time.Parse("2006-01-02", p.EffectiveFrom); err != nil {
+				return fmt.Errorf("pricing[%d].effective_from must be YYYY-MM-DD", i)
+			}
+		}
+	}
+	// Backends are stored in the database at startup; more may be added in
+	// the admin console, so the list may be empty.
+	names := make(map[string]bool, len(cfg.Backends))
 	for i, b := range cfg.Backends {
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("backend-%d", i+1)
+			cfg.Backends[i].Name = b.Name
+		}
+		if names[b.Name] {
+			return fmt.Errorf("backends[%d].name %q is not unique", i, b.Name)
+		}
+		names[b.Name] = true
 		if b.URL == "" {
 			return fmt.Errorf("backends[%d].url is required", i)
 		}
@@ -111,6 +412,19 @@ func validate(cfg *Config) error {
 		if b.Weight <= 0 {
 			cfg.Backends[i].Weight = 1
 		}
+		if b.Priority <= 0 {
+			cfg.Backends[i].Priority = 1
+		}
+		if b.MaxInFlight < 0 {
+			return fmt.Errorf("backends[%d].max_in_flight must not be negative", i)
+		}
+		switch b.Protocol {
+		case "":
+			cfg.Backends[i].Protocol = "anthropic"
+		case "anthropic", "openai":
+		default:
+			return fmt.Errorf("backends[%d].protocol must be anthropic or openai", i)
+		}
 	}
 	return nil
 }
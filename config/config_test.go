@@ -0,0 +1,169 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+)
+
+const base = `
+auth:
+  session_secret: s3cret
+  invite_code: alpha
+log:
+  level: info
+metrics:
+  enabled: true
+  token: scrape-token
+backends:
+  - name: primary
+    url: https://api.anthropic.com
+    api_key: sk-ant-primary
+`
+
+func parse(t *testing.T, data string) *config.Config {
+	t.Helper()
+	cfg, err := config.Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestDiff_ListsChangesWithSecretsMasked(t *testing.T) {
+	old := parse(t, base)
+	next := parse(t, strings.NewReplacer(
+		"level: info", "level: debug",
+		"sk-ant-primary", "sk-ant-rotated",
+		"invite_code: alpha", "invite_code: beta",
+	).Replace(base))
+
+	changes, err := config.Diff(old, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]config.Change)
+	for _, c := range changes {
+		got[c.Path] = c
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %v", changes)
+	}
+	if c := got["log.level"]; c.Old != "info" || c.New != "debug" {
+		t.Fatalf("unexpected log.level change: %+v", c)
+	}
+	if c := got["backends[0].api_key"]; c.Old != config.Masked || c.New != config.Masked || c.Section() != "backends" {
+		t.Fatalf("expected a masked api_key change in backends, got %+v", c)
+	}
+	if _, ok := got["auth.invite_code"]; !ok {
+		t.Fatalf("expected auth.invite_code to change, got %v", changes)
+	}
+}
+
+func TestMaskedTree_HidesSecrets(t *testing.T) {
+	tree, err := parse(t, base).MaskedTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := tree["auth"].(map[string]any)
+	if auth["session_secret"] != config.Masked || auth["api_key_secret"] != config.Masked {
+		t.Fatalf("expected session and api key secrets to be masked, got %v", auth)
+	}
+	if tree["metrics"].(map[string]any)["token"] != config.Masked {
+		t.Fatalf("expected metrics token to be masked, got %v", tree["metrics"])
+	}
+	backend := tree["backends"].([]any)[0].(map[string]any)
+	if backend["api_key"] != config.Masked || backend["url"] != "https://api.anthropic.com" {
+		t.Fatalf("expected only the backend key to be masked, got %v", backend)
+	}
+	if auth["invite_code"] != "alpha" || tree["log"].(map[string]any)["level"] != "info" {
+		t.Fatalf("expected other settings to be shown, got %v", tree)
+	}
+}
+
+func TestParse_ResolvesEnvReferencesSecretFilesAndOverrides(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "api_key")
+	if err := os.WriteFile(keyFile, []byte("sk-ant-from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSION_SECRET", "from-env")
+	t.Setenv("KEY_DIR", filepath.Dir(keyFile))
+	t.Setenv("GATEWAY_SERVER_PORT", "9090")
+	t.Setenv("GATEWAY_RETRY_TIMEOUT", "45s")
+	t.Setenv("GATEWAY_BACKENDS_0_WEIGHT", "3")
+
+	cfg := parse(t, `
+server:
+  port: ${PORT:-8081}
+auth:
+  session_secret: ${SESSION_SECRET}
+  invite_code: $${literal}
+backends:
+  - name: primary
+    url: https://api.anthropic.com
+    api_key_file: ${KEY_DIR}/api_key
+`)
+	if cfg.Auth.SessionSecret != "from-env" || cfg.Auth.InviteCode != "${literal}" {
+		t.Fatalf("unexpected interpolation: session_secret=%q invite_code=%q", cfg.Auth.SessionSecret, cfg.Auth.InviteCode)
+	}
+	if cfg.Backends[0].APIKey != "sk-ant-from-file" {
+		t.Fatalf("expected api_key to be read from file, got %q", cfg.Backends[0].APIKey)
+	}
+	if files := cfg.Files(); len(files) != 1 || files[0] != keyFile {
+		t.Fatalf("expected the key file to be listed, got %v", files)
+	}
+	if cfg.Server.Port != 9090 || cfg.Retry.Timeout != 45*time.Second || cfg.Backends[0].Weight != 3 {
+		t.Fatalf("expected env overrides to apply, got port=%d timeout=%s weight=%d", cfg.Server.Port, cfg.Retry.Timeout, cfg.Backends[0].Weight)
+	}
+}
+
+func TestParse_ErrorsNameTheSource(t *testing.T) {
+	for name, tc := range map[string]struct {
+		env  map[string]string
+		yaml string
+		want string
+	}{
+		"env override":   {map[string]string{"GATEWAY_SERVER_PORT": "70000"}, base, "environment variable GATEWAY_SERVER_PORT"},
+		"unparsable env": {map[string]string{"GATEWAY_METRICS_ENABLED": "maybe"}, base, "GATEWAY_METRICS_ENABLED"},
+		"reference":      {map[string]string{"RESET": "daily"}, base + "quota:\n  reset_period: ${RESET}\n", "via ${RESET}"},
+		"unset variable": {nil, base + "quota:\n  reset_period: ${UNSET_RESET_PERIOD}\n", "UNSET_RESET_PERIOD is not set"},
+		"file line":      {nil, base + "retry:\n  max_attempts: 0\n", "from line 15"},
+		"retry timeout":  {nil, base + "retry:\n  timeout: 0s\n", "retry.timeout must be positive"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, err := config.Parse([]byte(tc.yaml))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error mentioning %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestParse_KeepsRewriteCaptureReferences(t *testing.T) {
+	t.Setenv("family", "from-env")
+	cfg := parse(t, base+`
+model_rewrites:
+  - match: regex
+    pattern: '^claude-3-5-(?P<family>[a-z]+)-\d+$'
+    target: claude-${family}-4-5
+`)
+	if got := cfg.ModelRewrites[0].Target; got != "claude-${family}-4-5" {
+		t.Fatalf("expected the capture reference to be kept, got %q", got)
+	}
+
+	rw, err := proxy.NewRewriter(cfg.ModelRewrites, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rw.Rewrite("claude-3-5-sonnet-20241022", nil); got != "claude-sonnet-4-5" {
+		t.Fatalf("expected the named group to be substituted, got %q", got)
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Masked is shown in place of secret values.
+const Masked = "******"
+
+// secretKeys are the YAML keys whose values are never shown. Every value
+// below a secret key, such as each tracing header, is a secret too.
+var secretKeys = map[string]bool{
+	"session_secret": true,
+	"api_key_secret": true,
+	"api_key":        true,
+	"token":          true,
+	"headers":        true,
+}
+
+// Change is a setting that differs between two configs. Secret values are
+// masked.
+type Change struct {
+	Path string `json:"path"` // YAML path, e.g. rate_limit.user.requests_per_minute or backends[0].url
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+// Section returns the top-level key of the changed setting.
+func (c Change) Section() string {
+	end := strings.IndexAny(c.Path, ".[")
+	if end < 0 {
+		return c.Path
+	}
+	return c.Path[:end]
+}
+
+// MaskedTree returns cfg as nested maps keyed by YAML names, with secret
+// values replaced by Masked. It is what the admin console shows.
+func (cfg *Config) MaskedTree() (map[string]any, error) {
+	tree, err := cfg.tree()
+	if err != nil {
+		return nil, err
+	}
+	return mask(tree, false).(map[string]any), nil
+}
+
+// Diff lists the settings that differ between old and new, ordered by path.
+func Diff(old, new *Config) ([]Change, error) {
+	a, err := old.tree()
+	if err != nil {
+		return nil, err
+	}
+	b, err := new.tree()
+	if err != nil {
+		return nil, err
+	}
+	before, after := make(map[string]string), make(map[string]string)
+	flatten("", mask(a, false), before)
+	flatten("", mask(b, false), after)
+	// Masking hides whether a secret changed, so compare secrets unmasked.
+	rawBefore, rawAfter := make(map[string]string), make(map[string]string)
+	flatten("", a, rawBefore)
+	flatten("", b, rawAfter)
+
+	var changes []Change
+	for path, v := range after {
+		if rawBefore[path] != rawAfter[path] {
+			changes = append(changes, Change{Path: path, Old: orUnset(before, path), New: v})
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, Change{Path: path, Old: before[path], New: "(unset)"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// tree converts cfg to generic maps and slices through its YAML encoding, so
+// keys and values read as they do in the config file.
+func (cfg *Config) tree() (map[string]any, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("encode config: %w", err)
+	}
+	var tree map[string]any
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+	return tree, nil
+}
+
+// mask returns a copy of v with the values of secret keys masked. Empty
+// secrets stay empty so it remains visible that none is set.
+func mask(v any, secret bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, child := range v {
+			m[k] = mask(child, secret || secretKeys[k])
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, child := range v {
+			s[i] = mask(child, secret)
+		}
+		return s
+	default:
+		if secret && v != nil && v != "" {
+			return Masked
+		}
+		return v
+	}
+}
+
+// flatten records the leaf values of v in out, keyed by their path.
+func flatten(prefix string, v any, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			flatten(path, child, out)
+		}
+	case []any:
+		for i, child := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), child, out)
+		}
+	default:
+		if v == nil {
+			v = ""
+		}
+		out[prefix] = fmt.Sprint(v)
+	}
+}
+
+func orUnset(m map[string]string, key string) string {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	return "(unset)"
+}
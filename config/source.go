@@ -0,0 +1,227 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the names of environment variables that override config
+// settings: GATEWAY_SERVER_PORT overrides server.port and
+// GATEWAY_BACKENDS_0_API_KEY the api_key of the first backend.
+const EnvPrefix = "GATEWAY_"
+
+// fileSuffix marks a key whose value is read from the named file, e.g.
+// api_key_file for api_key.
+const fileSuffix = "_file"
+
+// envRef matches ${NAME} and ${NAME:-default}; $${ is a literal ${.
+var envRef = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// literalSetting matches the paths of settings whose values are never
+// expanded: rewrite targets use ${name} for regex capture groups.
+var literalSetting = regexp.MustCompile(`^model_rewrites\[\d+\]\.target$`)
+
+// sources records where the value of each setting came from, keyed by path
+// as in Change.Path. Settings left at their default have no entry.
+type sources map[string]string
+
+// of returns the source of the setting at path, or of the first setting
+// below it.
+func (s sources) of(path string) string {
+	if src, ok := s[path]; ok {
+		return src
+	}
+	var below []string
+	for p := range s {
+		if strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			below = append(below, p)
+		}
+	}
+	if len(below) == 0 {
+		return ""
+	}
+	sort.Strings(below)
+	return s[below[0]]
+}
+
+// resolveNode expands environment references in the scalar values below n,
+// replaces each key_file entry with key set to the file's contents, and
+// records the source of every value and the files read.
+func resolveNode(n *yaml.Node, path string, src sources, files *[]string) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			if err := resolveNode(c, path, src, files); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			if err := resolveNode(c, fmt.Sprintf("%s[%d]", path, i), src, files); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		keys := make(map[string]bool, len(n.Content)/2)
+		for i := 0; i < len(n.Content); i += 2 {
+			keys[n.Content[i].Value] = true
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if !strings.HasSuffix(key.Value, fileSuffix) || val.Kind != yaml.ScalarNode {
+				if err := resolveNode(val, join(path, key.Value), src, files); err != nil {
+					return err
+				}
+				continue
+			}
+			name := strings.TrimSuffix(key.Value, fileSuffix)
+			p := join(path, name)
+			if keys[name] {
+				return fmt.Errorf("%s and %s are both set (line %d)", p, join(path, key.Value), key.Line)
+			}
+			file, _, err := expand(val.Value)
+			if err != nil {
+				return fmt.Errorf("%s (line %d): %w", join(path, key.Value), val.Line, err)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%s (line %d): %w", join(path, key.Value), val.Line, err)
+			}
+			key.Value = name
+			val.Value = strings.TrimRight(string(data), "\r\n")
+			val.Tag, val.Style = "!!str", 0
+			src[p] = fmt.Sprintf("file %s named on line %d", file, val.Line)
+			*files = append(*files, file)
+		}
+	case yaml.ScalarNode:
+		if literalSetting.MatchString(path) {
+			src[path] = fmt.Sprintf("line %d", n.Line)
+			return nil
+		}
+		value, vars, err := expand(n.Value)
+		if err != nil {
+			return fmt.Errorf("%s (line %d): %w", path, n.Line, err)
+		}
+		src[path] = fmt.Sprintf("line %d", n.Line)
+		if len(vars) > 0 {
+			src[path] = fmt.Sprintf("line %d via ${%s}", n.Line, strings.Join(vars, "}, ${"))
+		}
+		if value != n.Value {
+			n.Value = value
+			if n.Style == 0 {
+				// Re-resolve the type, so port: ${PORT} decodes into an int.
+				n.Tag = ""
+			}
+		}
+	}
+	return nil
+}
+
+// expand replaces environment references in s. It returns the names of the
+// variables used, and an error naming any that is unset and has no default.
+func expand(s string) (string, []string, error) {
+	var vars []string
+	var err error
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		m := envRef.FindStringSubmatch(ref)
+		vars = append(vars, m[1])
+		if v, ok := os.LookupEnv(m[1]); ok {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable %s is not set", m[1])
+		}
+		return ""
+	})
+	return out, vars, err
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv sets every scalar setting of cfg that has an EnvPrefix variable.
+// Slices of structs, such as backends, are overridden per existing element.
+func applyEnv(v reflect.Value, path, env string, src sources) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if err := applyEnv(v.Field(i), join(path, name), env+"_"+strings.ToUpper(name), src); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := applyEnv(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("%s_%d", env, i), src); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	name := EnvPrefix + strings.TrimPrefix(env, "_")
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	var err error
+	switch {
+	case v.Type() == durationType:
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err == nil {
+			v.SetInt(int64(d))
+		}
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			v.SetBool(b)
+		}
+	case v.CanInt():
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case v.CanFloat():
+		var f float64
+		if f, err = strconv.ParseFloat(s, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: invalid value %q for %s: %v", name, s, path, err)
+	}
+	src[path] = "environment variable " + name
+	return nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
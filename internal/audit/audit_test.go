@@ -0,0 +1,146 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/audit"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+)
+
+const anthropicStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-5","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Mail me at "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"bob@example.com"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"lookup","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"q\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"weather\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":30}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func newRecorder(t *testing.T, cfg config.AuditConfig) (*audit.Recorder, *audit.Store) {
+	t.Helper()
+	store, err := audit.OpenStore(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	rec, err := audit.NewRecorder(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec, store
+}
+
+// serve runs one request for info through a handler that captures it and
+// replies with contentType and body.
+func serve(rec *audit.Recorder, info *auth.KeyInfo, reqBody, contentType, body string) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/messages", func(c *gin.Context) {
+		if rc := rec.Begin(c, info, "claude-sonnet-4-5", []byte(reqBody)); rc != nil {
+			defer rc.Finish("primary", "claude-sonnet-4-5-20250929")
+		}
+		c.Set(middleware.CtxUpstreamRequestID, "req_upstream_1")
+		c.Data(http.StatusOK, contentType, []byte(body))
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(reqBody))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestRecorder_CapturesRedactedStream(t *testing.T) {
+	rec, store := newRecorder(t, config.AuditConfig{Users: []string{"alice"}, RedactPII: true, MaxBodyBytes: 1 << 20})
+	alice := &auth.KeyInfo{KeyID: 7, UserID: 1, Itcode: "alice"}
+	serve(rec, alice, `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"my key is sk-ant-REDACTED"}]}`,
+		"text/event-stream", anthropicStream)
+	serve(rec, &auth.KeyInfo{KeyID: 8, UserID: 2, Itcode: "bob"}, `{}`, "application/json", `{}`)
+	if err := rec.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	captures, total, err := store.List(audit.Filter{RequestID: "req_upstream_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || captures[0].Itcode != "alice" || !captures[0].Stream {
+		t.Fatalf("expected only alice's streamed request to be captured, got %d: %+v", total, captures)
+	}
+	c, err := store.Get(captures[0].ID)
+	if err != nil || c == nil {
+		t.Fatalf("get capture: %v", err)
+	}
+	if strings.Contains(string(c.Request), "sk-ant-") || !strings.Contains(string(c.Request), "[REDACTED]") {
+		t.Fatalf("expected API key to be redacted from the request, got %s", c.Request)
+	}
+
+	var msg struct {
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		Usage map[string]int `json:"usage"`
+	}
+	if err := json.Unmarshal(c.Response, &msg); err != nil {
+		t.Fatalf("expected an assembled message, got %s", c.Response)
+	}
+	if len(msg.Content) != 2 || msg.Content[0].Text != "Mail me at [REDACTED]" || string(msg.Content[1].Input) != `{"q":"weather"}` {
+		t.Fatalf("unexpected assembled content: %s", c.Response)
+	}
+	if msg.StopReason != "tool_use" || msg.Usage["input_tokens"] != 12 || msg.Usage["output_tokens"] != 30 {
+		t.Fatalf("unexpected stop reason or usage: %s", c.Response)
+	}
+}
+
+func TestRecorder_TruncatesLargeBodies(t *testing.T) {
+	rec, store := newRecorder(t, config.AuditConfig{All: true, MaxBodyBytes: 64})
+	serve(rec, nil, strings.Repeat("a", 100), "application/json", strings.Repeat("b", 10000))
+	rec.Close(context.Background())
+
+	captures, _, err := store.List(audit.Filter{})
+	if err != nil || len(captures) != 1 {
+		t.Fatalf("expected one capture, got %d (%v)", len(captures), err)
+	}
+	c, _ := store.Get(captures[0].ID)
+	if !c.Truncated || len(c.Request) != 64 || len(c.Response) != 64 {
+		t.Fatalf("expected bodies cut to 64 bytes, got truncated=%v request=%d response=%d", c.Truncated, len(c.Request), len(c.Response))
+	}
+	if c.RequestBytes != 100 || c.ResponseBytes != 10000 {
+		t.Fatalf("expected original sizes to be kept, got %d and %d", c.RequestBytes, c.ResponseBytes)
+	}
+}
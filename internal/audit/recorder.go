@@ -0,0 +1,283 @@
+// Package audit captures request and response bodies of selected users and API
+// keys for compliance review.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+)
+
+// pruneInterval is how often captures past their retention are deleted.
+const pruneInterval = time.Hour
+
+// redactSlack is how many bytes past the size cap a body may be buffered, so a
+// secret straddling the cap is still recognised and redacted before truncation.
+const redactSlack = 4096
+
+// Recorder decides which requests are captured and writes captures to a Store
+// in the background. A nil *Recorder captures nothing.
+type Recorder struct {
+	store     *Store
+	all       bool
+	users     map[string]bool
+	keys      map[int64]bool
+	maxBytes  int
+	retention time.Duration
+	redactor  *redactor
+
+	mu      sync.RWMutex // guards closed against concurrent enqueue
+	closed  bool
+	ch      chan *Capture
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewRecorder starts a Recorder writing to store.
+func NewRecorder(store *Store, cfg config.AuditConfig) (*Recorder, error) {
+	red, err := newRedactor(cfg.Redact, cfg.RedactPII)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		store:     store,
+		all:       cfg.All,
+		users:     make(map[string]bool, len(cfg.Users)),
+		keys:      make(map[int64]bool, len(cfg.APIKeys)),
+		maxBytes:  cfg.MaxBodyBytes,
+		retention: cfg.Retention,
+		redactor:  red,
+		ch:        make(chan *Capture, max(cfg.BufferSize, 1)),
+		done:      make(chan struct{}),
+	}
+	for _, u := range cfg.Users {
+		r.users[u] = true
+	}
+	for _, k := range cfg.APIKeys {
+		r.keys[k] = true
+	}
+	go r.worker()
+	return r, nil
+}
+
+// Selects reports whether requests made with info are captured.
+func (r *Recorder) Selects(info *auth.KeyInfo) bool {
+	if r == nil {
+		return false
+	}
+	if r.all {
+		return true
+	}
+	return info != nil && (r.users[info.Itcode] || r.keys[info.KeyID])
+}
+
+// Recording is a capture in progress. The response is collected from
+// everything the handler writes to the client.
+type Recording struct {
+	r       *Recorder
+	c       *gin.Context
+	capture *Capture
+	stream  *streamAssembler
+	body    []byte
+	started bool
+}
+
+// Begin starts capturing the request in c if info is selected, and returns nil
+// otherwise. body is the request body as the client sent it. The response
+// writer of c is wrapped, so Begin must run before anything is written.
+func (r *Recorder) Begin(c *gin.Context, info *auth.KeyInfo, requestedModel string, body []byte) *Recording {
+	if !r.Selects(info) {
+		return nil
+	}
+	capture := &Capture{
+		ID:             newID(),
+		RequestID:      c.GetString(middleware.CtxRequestID),
+		Method:         c.Request.Method,
+		Path:           c.Request.URL.Path,
+		RequestedModel: requestedModel,
+		RequestBytes:   len(body),
+		CreatedAt:      time.Now(),
+	}
+	if info != nil {
+		capture.UserID, capture.Itcode, capture.APIKeyID = info.UserID, info.Itcode, info.KeyID
+	}
+	var truncated bool
+	capture.Request, truncated = r.clean(body)
+	capture.Truncated = truncated
+
+	rec := &Recording{r: r, c: c, capture: capture}
+	c.Writer = &captureWriter{ResponseWriter: c.Writer, rec: rec}
+	return rec
+}
+
+// Finish completes the capture with what was written to the client and queues
+// it for writing. model is the model sent upstream after rewriting.
+func (rec *Recording) Finish(backend, model string) {
+	cp := rec.capture
+	cp.Backend, cp.Model = backend, model
+	cp.StatusCode = rec.c.Writer.Status()
+	cp.UpstreamRequestID = rec.c.GetString(middleware.CtxUpstreamRequestID)
+
+	var body []byte
+	var truncated bool
+	if rec.stream != nil {
+		cp.Stream = true
+		body, truncated = rec.stream.Result()
+	} else {
+		body = rec.body
+		truncated = rec.r.maxBytes > 0 && cp.ResponseBytes > rec.r.maxBytes+redactSlack
+	}
+	var capped bool
+	cp.Response, capped = rec.r.clean(body)
+	cp.Truncated = cp.Truncated || truncated || capped
+	rec.r.enqueue(cp)
+}
+
+// observe collects a chunk of the response as it is written.
+func (rec *Recording) observe(p []byte) {
+	if !rec.started {
+		rec.started = true
+		if strings.Contains(rec.c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+			rec.stream = &streamAssembler{limit: rec.r.maxBytes}
+		}
+	}
+	rec.capture.ResponseBytes += len(p)
+	if rec.stream != nil {
+		rec.stream.Write(p)
+		return
+	}
+	if limit := rec.r.maxBytes; limit > 0 && len(rec.body)+len(p) > limit+redactSlack {
+		p = p[:max(limit+redactSlack-len(rec.body), 0)]
+	}
+	rec.body = append(rec.body, p...)
+}
+
+// clean redacts b, then cuts it to the size cap.
+func (r *Recorder) clean(b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	b = r.redactor.redact(b)
+	if r.maxBytes > 0 && len(b) > r.maxBytes {
+		return b[:r.maxBytes], true
+	}
+	return b, false
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// captureWriter tees everything written to the client into a Recording.
+type captureWriter struct {
+	gin.ResponseWriter
+	rec *Recording
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.rec.observe(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.rec.observe([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// enqueue queues a capture for writing without blocking. Captures are dropped
+// when the queue is full; payload capture must never slow down the proxy.
+func (r *Recorder) enqueue(c *Capture) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.dropped.Add(1)
+		return
+	}
+	select {
+	case r.ch <- c:
+	default:
+		if n := r.dropped.Add(1); n == 1 || n%100 == 0 {
+			logger.Warnf("audit queue full, %d captures dropped so far", n)
+		}
+	}
+}
+
+// Dropped returns how many captures were lost because the queue was full.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close stops accepting captures and waits until the queued ones are written,
+// or ctx is done. The store is left open.
+func (r *Recorder) Close(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.ch)
+	}
+	r.mu.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("audit recorder: %d captures not written: %w", len(r.ch), ctx.Err())
+	}
+}
+
+func (r *Recorder) worker() {
+	defer close(r.done)
+	r.prune()
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case c, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			// Write whatever else is already queued in the same transaction.
+			batch := []*Capture{c}
+			for len(batch) < 64 && len(r.ch) > 0 {
+				more, ok := <-r.ch
+				if !ok {
+					break
+				}
+				batch = append(batch, more)
+			}
+			if err := r.store.Insert(batch); err != nil {
+				logger.Errorf("write %d audit captures: %v", len(batch), err)
+			}
+		case <-ticker.C:
+			r.prune()
+		}
+	}
+}
+
+func (r *Recorder) prune() {
+	if r.retention <= 0 {
+		return
+	}
+	n, err := r.store.Prune(time.Now().Add(-r.retention))
+	if err != nil {
+		logger.Errorf("%v", err)
+	} else if n > 0 {
+		logger.Infof("pruned %d audit captures older than %s", n, r.retention)
+	}
+}
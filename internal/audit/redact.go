@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// redacted replaces every redacted match.
+var redacted = []byte("[REDACTED]")
+
+// piiPatterns are applied when redact_pii is set. Card numbers are only
+// redacted when they pass the Luhn check, so long IDs and timestamps survive.
+var piiPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), // email
+	regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_-]{16,}`),              // API keys
+	regexp.MustCompile(`\b\d{17}[\dXx]\b`),                               // mainland China resident ID
+	regexp.MustCompile(`(?:\+?86[- ]?)?\b1[3-9]\d{9}\b`),                 // mainland China mobile
+}
+
+var cardPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+// redactor removes sensitive text from captured bodies.
+type redactor struct {
+	patterns []*regexp.Regexp
+	cards    bool
+}
+
+func newRedactor(exprs []string, pii bool) (*redactor, error) {
+	r := &redactor{cards: pii}
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", expr, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	if pii {
+		r.patterns = append(r.patterns, piiPatterns...)
+	}
+	return r, nil
+}
+
+// redact returns b with every match replaced. b is not modified.
+func (r *redactor) redact(b []byte) []byte {
+	for _, re := range r.patterns {
+		b = re.ReplaceAllLiteral(b, redacted)
+	}
+	if r.cards {
+		b = cardPattern.ReplaceAllFunc(b, func(m []byte) []byte {
+			if luhn(m) {
+				return redacted
+			}
+			return m
+		})
+	}
+	return b
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s []byte) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return sum%10 == 0
+}
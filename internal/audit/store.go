@@ -0,0 +1,284 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Capture is a recorded request and response. Bodies are stored redacted and
+// gzip-compressed; RequestBytes and ResponseBytes are their sizes before
+// redaction and truncation.
+type Capture struct {
+	ID                string    `json:"id"`
+	RequestID         string    `json:"request_id"`                    // the gateway's X-Request-Id
+	UpstreamRequestID string    `json:"upstream_request_id,omitempty"` // request-id returned by the backend
+	UserID            int64     `json:"user_id"`
+	Itcode            string    `json:"itcode"`
+	APIKeyID          int64     `json:"api_key_id"`
+	Method            string    `json:"method"`
+	Path              string    `json:"path"`
+	Model             string    `json:"model"`
+	RequestedModel    string    `json:"requested_model"`
+	Backend           string    `json:"backend"`
+	StatusCode        int       `json:"status_code"`
+	Stream            bool      `json:"stream"` // Response was assembled from an event stream
+	RequestBytes      int       `json:"request_bytes"`
+	ResponseBytes     int       `json:"response_bytes"`
+	Truncated         bool      `json:"truncated"`
+	CreatedAt         time.Time `json:"created_at"`
+	Request           []byte    `json:"-"`
+	Response          []byte    `json:"-"`
+}
+
+// Store persists captures in a SQLite database of their own, so payloads do
+// not bloat the gateway database and can be kept on separate storage.
+type Store struct {
+	db *sql.DB
+}
+
+// OpenStore opens (or creates) the capture database at path.
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path+"?_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("open audit db: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate audit db: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Store) migrate() error {
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+	// request_id was added after the table was first released.
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('captures') WHERE name = 'request_id'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := s.db.Exec(`ALTER TABLE captures ADD COLUMN request_id TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_captures_request_id ON captures(request_id)`)
+	return err
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS captures (
+    id                  TEXT    PRIMARY KEY,
+    request_id          TEXT    NOT NULL DEFAULT '',
+    upstream_request_id TEXT    NOT NULL DEFAULT '',
+    user_id             INTEGER NOT NULL DEFAULT 0,
+    itcode              TEXT    NOT NULL DEFAULT '',
+    api_key_id          INTEGER NOT NULL DEFAULT 0,
+    method              TEXT    NOT NULL DEFAULT '',
+    path                TEXT    NOT NULL DEFAULT '',
+    model               TEXT    NOT NULL DEFAULT '',
+    requested_model     TEXT    NOT NULL DEFAULT '',
+    backend             TEXT    NOT NULL DEFAULT '',
+    status_code         INTEGER NOT NULL DEFAULT 0,
+    stream              INTEGER NOT NULL DEFAULT 0,
+    request_bytes       INTEGER NOT NULL DEFAULT 0,
+    response_bytes      INTEGER NOT NULL DEFAULT 0,
+    truncated           INTEGER NOT NULL DEFAULT 0,
+    request             BLOB,   -- gzip
+    response            BLOB,   -- gzip
+    created_at          DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_captures_created_at ON captures(created_at);
+CREATE INDEX IF NOT EXISTS idx_captures_user_id    ON captures(user_id);
+CREATE INDEX IF NOT EXISTS idx_captures_api_key_id ON captures(api_key_id);
+CREATE INDEX IF NOT EXISTS idx_captures_upstream   ON captures(upstream_request_id);
+`
+
+const captureColumns = `id, request_id, upstream_request_id, user_id, itcode, api_key_id, method, path, model, requested_model, backend,
+	status_code, stream, request_bytes, response_bytes, truncated, created_at`
+
+// Insert writes captures in a single transaction.
+func (s *Store) Insert(captures []*Capture) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO captures (` + captureColumns + `, request, response)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range captures {
+		req, err := compress(c.Request)
+		if err != nil {
+			return err
+		}
+		resp, err := compress(c.Response)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(c.ID, c.RequestID, c.UpstreamRequestID, c.UserID, c.Itcode, c.APIKeyID, c.Method, c.Path,
+			c.Model, c.RequestedModel, c.Backend, c.StatusCode, c.Stream, c.RequestBytes, c.ResponseBytes,
+			c.Truncated, c.CreatedAt, req, resp); err != nil {
+			return fmt.Errorf("insert capture: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Filter selects captures in List. Zero fields match everything. RequestID
+// matches the capture ID or either the gateway's or the backend's request ID.
+type Filter struct {
+	RequestID string
+	UserID    int64
+	Itcode    string
+	APIKeyID  int64
+	Model     string
+	StartDate string // YYYY-MM-DD
+	EndDate   string
+	Page      int
+	PageSize  int
+}
+
+// List returns captures matching f, newest first, without their bodies.
+func (s *Store) List(f Filter) ([]*Capture, int, error) {
+	where := "WHERE 1=1"
+	var args []interface{}
+	if f.RequestID != "" {
+		where += " AND (id = ? OR request_id = ? OR upstream_request_id = ?)"
+		args = append(args, f.RequestID, f.RequestID, f.RequestID)
+	}
+	if f.UserID > 0 {
+		where += " AND user_id = ?"
+		args = append(args, f.UserID)
+	}
+	if f.Itcode != "" {
+		where += " AND itcode = ?"
+		args = append(args, f.Itcode)
+	}
+	if f.APIKeyID > 0 {
+		where += " AND api_key_id = ?"
+		args = append(args, f.APIKeyID)
+	}
+	if f.Model != "" {
+		where += " AND (model = ? OR requested_model = ?)"
+		args = append(args, f.Model, f.Model)
+	}
+	if f.StartDate != "" {
+		where += " AND created_at >= ?"
+		args = append(args, f.StartDate)
+	}
+	if f.EndDate != "" {
+		where += " AND created_at <= ?"
+		args = append(args, f.EndDate+" 23:59:59")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM captures "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if f.PageSize <= 0 {
+		f.PageSize = 20
+	}
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	rows, err := s.db.Query("SELECT "+captureColumns+" FROM captures "+where+" ORDER BY created_at DESC LIMIT ? OFFSET ?",
+		append(args, f.PageSize, (f.Page-1)*f.PageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var captures []*Capture
+	for rows.Next() {
+		c := &Capture{}
+		if err := rows.Scan(captureFields(c)...); err != nil {
+			return nil, 0, err
+		}
+		captures = append(captures, c)
+	}
+	return captures, total, rows.Err()
+}
+
+// Get returns a capture with its decompressed bodies, or nil if there is none
+// with the given ID.
+func (s *Store) Get(id string) (*Capture, error) {
+	c := &Capture{}
+	var req, resp []byte
+	err := s.db.QueryRow("SELECT "+captureColumns+", request, response FROM captures WHERE id = ?", id).
+		Scan(append(captureFields(c), &req, &resp)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Request, err = decompress(req); err != nil {
+		return nil, fmt.Errorf("capture %s request: %w", id, err)
+	}
+	if c.Response, err = decompress(resp); err != nil {
+		return nil, fmt.Errorf("capture %s response: %w", id, err)
+	}
+	return c, nil
+}
+
+// Prune deletes captures created before cutoff and returns how many were deleted.
+func (s *Store) Prune(cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM captures WHERE created_at < ?", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("prune captures: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func captureFields(c *Capture) []interface{} {
+	return []interface{}{&c.ID, &c.RequestID, &c.UpstreamRequestID, &c.UserID, &c.Itcode, &c.APIKeyID, &c.Method, &c.Path,
+		&c.Model, &c.RequestedModel, &c.Backend, &c.StatusCode, &c.Stream, &c.RequestBytes, &c.ResponseBytes,
+		&c.Truncated, &c.CreatedAt}
+}
+
+func compress(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
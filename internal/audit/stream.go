@@ -0,0 +1,339 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// streamAssembler rebuilds the complete response from an event stream as it
+// passes through, so a capture holds one message instead of thousands of
+// deltas. Anthropic Messages and OpenAI chat completion streams are assembled;
+// any other stream is kept as raw bytes.
+//
+// Content beyond limit bytes (0 = no limit) is dropped and the result is
+// marked truncated.
+type streamAssembler struct {
+	limit     int
+	size      int
+	truncated bool
+	line      []byte
+	overflow  bool // the current line exceeded limit
+	raw       []byte
+
+	anthropic *anthropicMessage
+	openai    *openAICompletion
+}
+
+type anthropicMessage struct {
+	ID           string                     `json:"id"`
+	Type         string                     `json:"type"`
+	Role         string                     `json:"role"`
+	Model        string                     `json:"model"`
+	Content      []*anthropicBlock          `json:"content"`
+	StopReason   string                     `json:"stop_reason,omitempty"`
+	StopSequence string                     `json:"stop_sequence,omitempty"`
+	Usage        map[string]json.RawMessage `json:"usage,omitempty"`
+	Error        json.RawMessage            `json:"error,omitempty"` // an error event ended the stream
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	partial   []byte          // input_json_delta fragments
+}
+
+type openAICompletion struct {
+	ID      string          `json:"id"`
+	Object  string          `json:"object"`
+	Created int64           `json:"created,omitempty"`
+	Model   string          `json:"model"`
+	Choices []*openAIChoice `json:"choices"`
+	Usage   json.RawMessage `json:"usage,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int           `json:"index"`
+	Message      openAIMessage `json:"message"`
+	FinishReason string        `json:"finish_reason,omitempty"`
+}
+
+type openAIMessage struct {
+	Role             string            `json:"role"`
+	Content          string            `json:"content"`
+	ReasoningContent string            `json:"reasoning_content,omitempty"`
+	ToolCalls        []*openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// maxIndexGap bounds how far past the last content block or choice an event's
+// index may point, so a bogus index cannot allocate a huge slice.
+const maxIndexGap = 64
+
+func (a *streamAssembler) Write(p []byte) (int, error) {
+	n := len(p)
+	a.keepRaw(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			a.appendLine(p)
+			break
+		}
+		a.appendLine(p[:i])
+		if !a.overflow {
+			a.observeLine(a.line)
+		}
+		a.line, a.overflow = a.line[:0], false
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (a *streamAssembler) keepRaw(p []byte) {
+	if a.limit > 0 && len(a.raw)+len(p) > a.limit {
+		p = p[:max(a.limit-len(a.raw), 0)]
+	}
+	a.raw = append(a.raw, p...)
+}
+
+func (a *streamAssembler) appendLine(p []byte) {
+	if a.overflow {
+		return
+	}
+	if a.limit > 0 && len(a.line)+len(p) > a.limit {
+		a.overflow, a.truncated = true, true
+		a.line = a.line[:0]
+		return
+	}
+	a.line = append(a.line, p...)
+}
+
+func (a *streamAssembler) observeLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+	data := bytes.TrimSpace(line[len("data:"):])
+	var head struct {
+		Type   string `json:"type"`
+		Object string `json:"object"`
+	}
+	if json.Unmarshal(data, &head) != nil {
+		return
+	}
+	switch {
+	case head.Object == "chat.completion.chunk":
+		a.observeOpenAI(data)
+	case head.Type != "":
+		a.observeAnthropic(head.Type, data)
+	}
+}
+
+func (a *streamAssembler) observeAnthropic(typ string, data []byte) {
+	var ev struct {
+		Index        int                        `json:"index"`
+		Message      *anthropicMessage          `json:"message"`
+		ContentBlock *anthropicBlock            `json:"content_block"`
+		Delta        json.RawMessage            `json:"delta"`
+		Usage        map[string]json.RawMessage `json:"usage"`
+		Error        json.RawMessage            `json:"error"`
+	}
+	if json.Unmarshal(data, &ev) != nil {
+		return
+	}
+	if typ == "message_start" && ev.Message != nil {
+		a.anthropic = ev.Message
+		a.anthropic.Content = nil
+		return
+	}
+	if a.anthropic == nil {
+		a.anthropic = &anthropicMessage{Type: "message", Role: "assistant"}
+	}
+	m := a.anthropic
+
+	switch typ {
+	case "content_block_start":
+		if ev.ContentBlock != nil && ev.Index >= 0 && ev.Index < len(m.Content)+maxIndexGap {
+			for len(m.Content) <= ev.Index {
+				m.Content = append(m.Content, nil)
+			}
+			ev.ContentBlock.Input = nil // streamed as input_json_delta
+			m.Content[ev.Index] = ev.ContentBlock
+		}
+	case "content_block_delta":
+		if ev.Index < 0 || ev.Index >= len(m.Content) || m.Content[ev.Index] == nil {
+			return
+		}
+		var d struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			Thinking    string `json:"thinking"`
+			Signature   string `json:"signature"`
+			PartialJSON string `json:"partial_json"`
+		}
+		if json.Unmarshal(ev.Delta, &d) != nil {
+			return
+		}
+		b := m.Content[ev.Index]
+		switch d.Type {
+		case "text_delta":
+			b.Text += a.take(d.Text)
+		case "thinking_delta":
+			b.Thinking += a.take(d.Thinking)
+		case "signature_delta":
+			b.Signature += d.Signature
+		case "input_json_delta":
+			b.partial = append(b.partial, a.take(d.PartialJSON)...)
+		}
+	case "content_block_stop":
+		if ev.Index >= 0 && ev.Index < len(m.Content) && m.Content[ev.Index] != nil {
+			m.Content[ev.Index].finish()
+		}
+	case "message_delta":
+		var d struct {
+			StopReason   string `json:"stop_reason"`
+			StopSequence string `json:"stop_sequence"`
+		}
+		if json.Unmarshal(ev.Delta, &d) == nil {
+			m.StopReason, m.StopSequence = d.StopReason, d.StopSequence
+		}
+		if m.Usage == nil {
+			m.Usage = ev.Usage
+		} else {
+			for k, v := range ev.Usage {
+				m.Usage[k] = v
+			}
+		}
+	case "error":
+		m.Error = ev.Error
+	}
+}
+
+// finish sets a tool_use block's input from its streamed fragments.
+func (b *anthropicBlock) finish() {
+	if b.partial == nil {
+		return
+	}
+	if json.Valid(b.partial) {
+		b.Input = b.partial
+	} else {
+		b.Input, _ = json.Marshal(string(b.partial)) // truncated or malformed; keep it as a string
+	}
+	b.partial = nil
+}
+
+func (a *streamAssembler) observeOpenAI(data []byte) {
+	var chunk struct {
+		ID      string `json:"id"`
+		Created int64  `json:"created"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Index int `json:"index"`
+			Delta struct {
+				Role             string            `json:"role"`
+				Content          string            `json:"content"`
+				ReasoningContent string            `json:"reasoning_content"`
+				ToolCalls        []*openAIToolCall `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage json.RawMessage `json:"usage"`
+	}
+	if json.Unmarshal(data, &chunk) != nil {
+		return
+	}
+	if a.openai == nil {
+		a.openai = &openAICompletion{ID: chunk.ID, Object: "chat.completion", Created: chunk.Created, Model: chunk.Model}
+	}
+	o := a.openai
+	if len(chunk.Usage) > 0 && string(chunk.Usage) != "null" {
+		o.Usage = chunk.Usage
+	}
+	for _, ch := range chunk.Choices {
+		if ch.Index < 0 || ch.Index >= len(o.Choices)+maxIndexGap {
+			continue
+		}
+		for len(o.Choices) <= ch.Index {
+			o.Choices = append(o.Choices, &openAIChoice{Index: len(o.Choices), Message: openAIMessage{Role: "assistant"}})
+		}
+		c := o.Choices[ch.Index]
+		if ch.Delta.Role != "" {
+			c.Message.Role = ch.Delta.Role
+		}
+		c.Message.Content += a.take(ch.Delta.Content)
+		c.Message.ReasoningContent += a.take(ch.Delta.ReasoningContent)
+		for _, tc := range ch.Delta.ToolCalls {
+			if tc.Index < 0 || tc.Index >= len(c.Message.ToolCalls)+maxIndexGap {
+				continue
+			}
+			for len(c.Message.ToolCalls) <= tc.Index {
+				c.Message.ToolCalls = append(c.Message.ToolCalls, &openAIToolCall{Index: len(c.Message.ToolCalls)})
+			}
+			call := c.Message.ToolCalls[tc.Index]
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+			if tc.Function.Name != "" {
+				call.Function.Name = tc.Function.Name
+			}
+			call.Function.Arguments += a.take(tc.Function.Arguments)
+		}
+		if ch.FinishReason != "" {
+			c.FinishReason = ch.FinishReason
+		}
+	}
+}
+
+// take returns as much of s as still fits in the limit.
+func (a *streamAssembler) take(s string) string {
+	if a.limit > 0 && a.size+len(s) > a.limit {
+		s = s[:max(a.limit-a.size, 0)]
+		a.truncated = true
+	}
+	a.size += len(s)
+	return s
+}
+
+// Result returns the assembled response, or the raw stream if it was not
+// recognised, and whether anything was dropped.
+func (a *streamAssembler) Result() ([]byte, bool) {
+	if !a.overflow && len(a.line) > 0 {
+		a.observeLine(a.line)
+		a.line = a.line[:0]
+	}
+	var v interface{}
+	switch {
+	case a.anthropic != nil:
+		for _, b := range a.anthropic.Content {
+			if b != nil {
+				b.finish()
+			}
+		}
+		v = a.anthropic
+	case a.openai != nil:
+		v = a.openai
+	default:
+		return a.raw, a.truncated || a.limit > 0 && len(a.raw) >= a.limit
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return a.raw, a.truncated
+	}
+	return out, a.truncated
+}
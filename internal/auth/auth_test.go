@@ -40,7 +40,7 @@ func TestCodeStore_Expiry(t *testing.T) {
 }
 
 func TestKeyStore_AddAndGet(t *testing.T) {
-	ks := auth.NewKeyStore()
+	ks := auth.NewKeyStore("test-secret")
 
 	key, err := auth.GenerateKey()
 	if err != nil {
@@ -53,7 +53,7 @@ func TestKeyStore_AddAndGet(t *testing.T) {
 		QuotaTokens: 1000000,
 		UserStatus:  "active",
 	}
-	ks.Add(key, info)
+	ks.Add(ks.Hash(key), info)
 
 	got := ks.Get(key)
 	if got == nil {
@@ -63,7 +63,7 @@ func TestKeyStore_AddAndGet(t *testing.T) {
 		t.Fatalf("expected UserID 42, got %d", got.UserID)
 	}
 
-	ks.Remove(key)
+	ks.Remove(ks.Hash(key))
 	if ks.Get(key) != nil {
 		t.Fatal("expected nil after remove")
 	}
@@ -81,3 +81,51 @@ func TestGenerateKey(t *testing.T) {
 		t.Fatalf("key should start with sk-: %s", key)
 	}
 }
+
+func TestKeyInfo_AllowsModel(t *testing.T) {
+	info := &auth.KeyInfo{Models: []string{"claude-opus-4-20250514"}}
+	defaults := []string{"claude-sonnet-4*"}
+
+	if !info.AllowsModel("claude-sonnet-4-20250514", defaults) {
+		t.Fatal("expected default glob to allow sonnet")
+	}
+	if !info.AllowsModel("claude-opus-4-20250514", defaults) {
+		t.Fatal("expected granted model to be allowed")
+	}
+	if info.AllowsModel("claude-opus-4-1", defaults) {
+		t.Fatal("expected ungranted model to be rejected")
+	}
+}
+
+func TestKeyStore_SetUserModels(t *testing.T) {
+	ks := auth.NewKeyStore("test-secret")
+	old := &auth.KeyInfo{KeyID: 1, UserID: 42, UserStatus: "active"}
+	ks.Add(ks.Hash("sk-a"), old)
+	ks.Add(ks.Hash("sk-b"), &auth.KeyInfo{KeyID: 2, UserID: 7, UserStatus: "active"})
+
+	ks.SetUserModels(42, []string{"claude-opus-4"})
+
+	if got := ks.Get("sk-a"); len(got.Models) != 1 || got.Models[0] != "claude-opus-4" {
+		t.Fatalf("expected granted model on user's key, got %v", got.Models)
+	}
+	if len(old.Models) != 0 {
+		t.Fatal("expected previously returned KeyInfo to be left untouched")
+	}
+	if got := ks.Get("sk-b"); len(got.Models) != 0 {
+		t.Fatalf("expected other user's key unchanged, got %v", got.Models)
+	}
+}
+
+func TestHashKeyAndHint(t *testing.T) {
+	key := "sk-abcdEFGH23456789mnpqRSTUwxyzABCDEF"
+	h := auth.HashKey([]byte("secret"), key)
+	if len(h) != 64 || h == key {
+		t.Fatalf("expected a hex sha256 digest, got %q", h)
+	}
+	if auth.HashKey([]byte("other"), key) == h {
+		t.Fatal("expected the digest to depend on the secret")
+	}
+	if got := auth.KeyHint(key); got != "sk-abcd...CDEF" {
+		t.Fatalf("unexpected hint %q", got)
+	}
+}
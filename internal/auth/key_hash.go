@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashKey returns the hex HMAC-SHA256 of a plaintext API key. Only the digest
+// is stored, so a leaked database does not expose usable keys.
+func HashKey(secret []byte, key string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// KeyHint returns a short, non-secret form of a key for display, such as "sk-abcd...wxyz".
+func KeyHint(key string) string {
+	const head, tail = 7, 4
+	if len(key) <= head+tail {
+		return key[:min(len(key), 3)] + "..."
+	}
+	return key[:head] + "..." + key[len(key)-tail:]
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/rand"
 	"fmt"
+	"path"
 	"sync"
 	"time"
 
@@ -14,23 +15,50 @@ type KeyInfo struct {
 	KeyID       int64
 	UserID      int64
 	Itcode      string
-	QuotaTokens int64  // 0 = unlimited
-	UserStatus  string // active | disabled
+	QuotaTokens int64    // 0 = unlimited
+	UserStatus  string   // active | disabled
+	Models      []string // models granted to the user, in addition to the defaults
 }
 
-// KeyStore holds all active API keys in memory for O(1) lookup.
+// AllowsModel reports whether the key may call model. The user's granted models
+// and the gateway-wide defaults are matched as exact names or glob patterns.
+func (k *KeyInfo) AllowsModel(model string, defaults []string) bool {
+	return matchModel(model, defaults) || matchModel(model, k.Models)
+}
+
+func matchModel(model string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == model {
+			return true
+		}
+		if ok, _ := path.Match(p, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyStore holds all active API keys in memory for O(1) lookup. Keys are
+// indexed by their HMAC digest; plaintext keys are never kept.
 type KeyStore struct {
-	mu   sync.RWMutex
-	keys map[string]*KeyInfo // key string -> KeyInfo
+	mu     sync.RWMutex
+	secret []byte
+	keys   map[string]*KeyInfo // key hash -> KeyInfo
+}
+
+// NewKeyStore creates an empty KeyStore hashing keys with secret.
+func NewKeyStore(secret string) *KeyStore {
+	return &KeyStore{secret: []byte(secret), keys: make(map[string]*KeyInfo)}
 }
 
-// NewKeyStore creates an empty KeyStore.
-func NewKeyStore() *KeyStore {
-	return &KeyStore{keys: make(map[string]*KeyInfo)}
+// Hash returns the digest under which a plaintext key is stored.
+func (ks *KeyStore) Hash(key string) string {
+	return HashKey(ks.secret, key)
 }
 
 // Load replaces the entire key map (called at startup).
-func (ks *KeyStore) Load(keys []model.APIKey, users map[int64]*model.User) {
+// models holds each user's granted model names.
+func (ks *KeyStore) Load(keys []model.APIKey, users map[int64]*model.User, models map[int64][]string) {
 	m := make(map[string]*KeyInfo, len(keys))
 	for _, k := range keys {
 		if k.Status != "active" {
@@ -43,12 +71,13 @@ func (ks *KeyStore) Load(keys []model.APIKey, users map[int64]*model.User) {
 		if !ok || u.Status != "active" {
 			continue
 		}
-		m[k.Key] = &KeyInfo{
+		m[k.KeyHash] = &KeyInfo{
 			KeyID:       k.ID,
 			UserID:      k.UserID,
 			Itcode:      u.Itcode,
 			QuotaTokens: u.QuotaTokens,
 			UserStatus:  u.Status,
+			Models:      models[k.UserID],
 		}
 	}
 	ks.mu.Lock()
@@ -56,25 +85,48 @@ func (ks *KeyStore) Load(keys []model.APIKey, users map[int64]*model.User) {
 	ks.mu.Unlock()
 }
 
-// Get looks up a key; returns nil if not found or inactive.
+// SetUserModels replaces the granted models on every key owned by userID.
+// KeyInfo values may be held by in-flight requests, so they are copied rather than mutated.
+func (ks *KeyStore) SetUserModels(userID int64, models []string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for hash, info := range ks.keys {
+		if info.UserID != userID {
+			continue
+		}
+		updated := *info
+		updated.Models = models
+		ks.keys[hash] = &updated
+	}
+}
+
+// Get looks up a plaintext key; returns nil if not found or inactive.
 func (ks *KeyStore) Get(key string) *KeyInfo {
+	hash := ks.Hash(key)
 	ks.mu.RLock()
-	info := ks.keys[key]
+	info := ks.keys[hash]
 	ks.mu.RUnlock()
 	return info
 }
 
-// Add inserts or updates a key in memory.
-func (ks *KeyStore) Add(key string, info *KeyInfo) {
+// Len returns the number of active keys in memory.
+func (ks *KeyStore) Len() int {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.keys)
+}
+
+// Add inserts or updates a key in memory by its hash.
+func (ks *KeyStore) Add(hash string, info *KeyInfo) {
 	ks.mu.Lock()
-	ks.keys[key] = info
+	ks.keys[hash] = info
 	ks.mu.Unlock()
 }
 
-// Remove deletes a key from memory.
-func (ks *KeyStore) Remove(key string) {
+// Remove deletes a key from memory by its hash.
+func (ks *KeyStore) Remove(hash string) {
 	ks.mu.Lock()
-	delete(ks.keys, key)
+	delete(ks.keys, hash)
 	ks.mu.Unlock()
 }
 
@@ -68,10 +68,30 @@ func (d *DB) ListApplications(userID int64, status string) ([]*model.Application
 	return apps, rows.Err()
 }
 
+// ReviewApplication records the review of an application. Approving it also
+// grants the model to the applicant, in the same transaction.
 func (d *DB) ReviewApplication(id, reviewerID int64, status, note string) error {
-	_, err := d.Exec(
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	if _, err := tx.Exec(
 		`UPDATE applications SET status=?, reviewer_id=?, review_note=?, updated_at=? WHERE id=?`,
-		status, reviewerID, note, time.Now(), id,
-	)
-	return err
+		status, reviewerID, note, now, id,
+	); err != nil {
+		return fmt.Errorf("review application: %w", err)
+	}
+	if status == "approved" {
+		if _, err := tx.Exec(
+			`INSERT INTO user_models (user_id, model, application_id, created_at)
+			 SELECT user_id, model, id, ? FROM applications WHERE id=?
+			 ON CONFLICT(user_id, model) DO NOTHING`,
+			now, id,
+		); err != nil {
+			return fmt.Errorf("grant user model: %w", err)
+		}
+	}
+	return tx.Commit()
 }
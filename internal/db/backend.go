@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+const backendColumns = `id, name, url, api_key, weight, enabled, protocol, models, priority, max_in_flight, from_config, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBackend(row rowScanner) (*model.Backend, error) {
+	b := &model.Backend{}
+	var models string
+	if err := row.Scan(&b.ID, &b.Name, &b.URL, &b.APIKey, &b.Weight, &b.Enabled, &b.Protocol, &models,
+		&b.Priority, &b.MaxInFlight, &b.FromConfig, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(models), &b.Models); err != nil {
+		return nil, fmt.Errorf("backend %s: decode models: %w", b.Name, err)
+	}
+	return b, nil
+}
+
+func encodeModels(models []string) string {
+	if models == nil {
+		models = []string{}
+	}
+	data, _ := json.Marshal(models)
+	return string(data)
+}
+
+// ListBackends returns every backend, enabled or not, ordered by ID.
+func (d *DB) ListBackends() ([]*model.Backend, error) {
+	rows, err := d.Query(`SELECT ` + backendColumns + ` FROM backends ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*model.Backend
+	for rows.Next() {
+		b, err := scanBackend(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, b)
+	}
+	return result, rows.Err()
+}
+
+// GetBackend returns a backend by ID, or nil if it does not exist.
+func (d *DB) GetBackend(id int64) (*model.Backend, error) {
+	b, err := scanBackend(d.QueryRow(`SELECT `+backendColumns+` FROM backends WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get backend: %w", err)
+	}
+	return b, nil
+}
+
+// CreateBackend inserts a backend.
+func (d *DB) CreateBackend(b *model.Backend) error {
+	now := time.Now()
+	res, err := d.Exec(
+		`INSERT INTO backends (name, url, api_key, weight, enabled, protocol, models, priority, max_in_flight, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		b.Name, b.URL, b.APIKey, b.Weight, b.Enabled, b.Protocol, encodeModels(b.Models), b.Priority, b.MaxInFlight, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("create backend: %w", err)
+	}
+	b.ID, _ = res.LastInsertId()
+	b.CreatedAt, b.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateBackend saves changes to an existing backend.
+func (d *DB) UpdateBackend(b *model.Backend) error {
+	b.UpdatedAt = time.Now()
+	_, err := d.Exec(
+		`UPDATE backends SET name=?, url=?, api_key=?, weight=?, enabled=?, protocol=?, models=?, priority=?, max_in_flight=?, updated_at=?
+		 WHERE id=?`,
+		b.Name, b.URL, b.APIKey, b.Weight, b.Enabled, b.Protocol, encodeModels(b.Models), b.Priority, b.MaxInFlight, b.UpdatedAt, b.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update backend: %w", err)
+	}
+	return nil
+}
+
+// UpsertBackends stores the backends declared in the config file: each is
+// inserted, or overwrites every setting of the stored backend with the same
+// name, and is marked as from the config file. Backends no longer declared
+// lose that mark, so they can be edited or deleted in the admin console.
+func (tx *Tx) UpsertBackends(backends []*model.Backend) error {
+	if _, err := tx.Exec(`UPDATE backends SET from_config = 0 WHERE from_config = 1`); err != nil {
+		return fmt.Errorf("upsert backends: %w", err)
+	}
+	now := time.Now()
+	for _, b := range backends {
+		if _, err := tx.Exec(
+			`INSERT INTO backends (name, url, api_key, weight, enabled, protocol, models, priority, max_in_flight, from_config, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+			 ON CONFLICT(name) DO UPDATE SET
+				url           = excluded.url,
+				api_key       = excluded.api_key,
+				weight        = excluded.weight,
+				enabled       = excluded.enabled,
+				protocol      = excluded.protocol,
+				models        = excluded.models,
+				priority      = excluded.priority,
+				max_in_flight = excluded.max_in_flight,
+				from_config   = 1,
+				updated_at    = excluded.updated_at`,
+			b.Name, b.URL, b.APIKey, b.Weight, b.Enabled, b.Protocol, encodeModels(b.Models), b.Priority, b.MaxInFlight, now, now,
+		); err != nil {
+			return fmt.Errorf("upsert backend %q: %w", b.Name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteBackend removes a backend and reports whether it existed.
+func (d *DB) DeleteBackend(id int64) (bool, error) {
+	res, err := d.Exec(`DELETE FROM backends WHERE id=?`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete backend: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("delete backend: %w", err)
+	}
+	return n > 0, nil
+}
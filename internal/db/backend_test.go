@@ -0,0 +1,49 @@
+package db_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+func TestUpsertBackends_OverwritesByNameAndKeepsOthers(t *testing.T) {
+	d, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, b := range []*model.Backend{
+		{Name: "declared", URL: "https://old.example", APIKey: "old", Weight: 1, Priority: 1, Enabled: true, Protocol: "anthropic"},
+		{Name: "console", URL: "https://console.example", APIKey: "k", Weight: 1, Priority: 1, Enabled: true, Protocol: "anthropic"},
+	} {
+		if err := d.CreateBackend(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = d.Update(func(tx *db.Tx) error {
+		return tx.UpsertBackends([]*model.Backend{
+			{Name: "declared", URL: "https://new.example", APIKey: "rotated", Weight: 3, Priority: 1, Enabled: true, Protocol: "anthropic"},
+			{Name: "added", URL: "https://added.example", APIKey: "k", Weight: 1, Priority: 2, Enabled: true, Protocol: "openai"},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := d.ListBackends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*model.Backend)
+	for _, b := range stored {
+		byName[b.Name] = b
+	}
+	if len(stored) != 3 || byName["console"] == nil || byName["added"] == nil {
+		t.Fatalf("expected the console backend to be kept and one to be added, got %d backends", len(stored))
+	}
+	if b := byName["declared"]; b.APIKey != "rotated" || b.URL != "https://new.example" || b.Weight != 3 {
+		t.Fatalf("expected the declared backend to be overwritten, got %+v", b)
+	}
+}
@@ -17,9 +17,28 @@ func (d *DB) AggregateDaily() error {
 	return d.aggregateForDate(yesterday)
 }
 
+// AggregateRange re-aggregates daily_stats for every day from startDate to
+// endDate (YYYY-MM-DD, inclusive).
+func (d *DB) AggregateRange(startDate, endDate string) error {
+	start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid start date: %w", err)
+	}
+	end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid end date: %w", err)
+	}
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if err := d.aggregateForDate(day.Format("2006-01-02")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *DB) aggregateForDate(date string) error {
 	_, err := d.Exec(`
-		INSERT INTO daily_stats (date, user_id, model, requests, input_tokens, output_tokens, total_tokens, cost_usd)
+		INSERT INTO daily_stats (date, user_id, model, requests, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_tokens, cost_usd)
 		SELECT
 			? as date,
 			user_id,
@@ -27,6 +46,8 @@ func (d *DB) aggregateForDate(date string) error {
 			COUNT(*) as requests,
 			SUM(input_tokens) as input_tokens,
 			SUM(output_tokens) as output_tokens,
+			SUM(cache_creation_tokens) as cache_creation_tokens,
+			SUM(cache_read_tokens) as cache_read_tokens,
 			SUM(total_tokens) as total_tokens,
 			SUM(cost_usd) as cost_usd
 		FROM usage_logs
@@ -36,6 +57,8 @@ func (d *DB) aggregateForDate(date string) error {
 			requests      = excluded.requests,
 			input_tokens  = excluded.input_tokens,
 			output_tokens = excluded.output_tokens,
+			cache_creation_tokens = excluded.cache_creation_tokens,
+			cache_read_tokens     = excluded.cache_read_tokens,
 			total_tokens  = excluded.total_tokens,
 			cost_usd      = excluded.cost_usd
 	`, date, date)
@@ -68,7 +91,7 @@ func (d *DB) GetDailyStats(userID int64, startDate, endDate, modelFilter string)
 	}
 
 	rows, err := d.Query(
-		`SELECT id, date, user_id, model, requests, input_tokens, output_tokens, total_tokens, cost_usd
+		`SELECT id, date, user_id, model, requests, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, total_tokens, cost_usd
 		 FROM daily_stats `+where+` ORDER BY date DESC, user_id`, args...)
 	if err != nil {
 		return nil, err
@@ -79,7 +102,7 @@ func (d *DB) GetDailyStats(userID int64, startDate, endDate, modelFilter string)
 	for rows.Next() {
 		s := &model.DailyStats{}
 		if err := rows.Scan(&s.ID, &s.Date, &s.UserID, &s.Model, &s.Requests,
-			&s.InputTokens, &s.OutputTokens, &s.TotalTokens, &s.CostUSD); err != nil {
+			&s.InputTokens, &s.OutputTokens, &s.CacheCreationTokens, &s.CacheReadTokens, &s.TotalTokens, &s.CostUSD); err != nil {
 			return nil, err
 		}
 		result = append(result, s)
@@ -3,6 +3,10 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	_ "modernc.org/sqlite"
 )
@@ -10,10 +14,28 @@ import (
 // DB wraps the sql.DB connection.
 type DB struct {
 	*sql.DB
+	observe atomic.Pointer[func(op string, d time.Duration)]
+
+	// hashKey and keyHint convert plaintext API keys during migration.
+	hashKey, keyHint func(key string) string
 }
 
-// Init opens (or creates) the SQLite database at path and runs migrations.
+// Init opens (or creates) the SQLite database at path and applies pending
+// migrations.
 func Init(path string) (*DB, error) {
+	d, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.Migrate(); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return d, nil
+}
+
+// Open opens (or creates) the SQLite database at path without migrating it.
+func Open(path string) (*DB, error) {
 	sqlDB, err := sql.Open("sqlite", path+"?_journal_mode=WAL&_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite: %w", err)
@@ -23,23 +45,79 @@ func Init(path string) (*DB, error) {
 	sqlDB.SetMaxIdleConns(1)
 
 	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
 		return nil, fmt.Errorf("ping sqlite: %w", err)
 	}
+	return &DB{DB: sqlDB}, nil
+}
 
-	d := &DB{sqlDB}
-	if err := d.migrate(); err != nil {
-		return nil, fmt.Errorf("migrate: %w", err)
+// SetKeyHasher sets the functions that give the stored digest and display
+// hint of an API key. They must be set before migrating a database that still
+// stores keys in plaintext.
+func (d *DB) SetKeyHasher(hash, hint func(key string) string) {
+	d.hashKey, d.keyHint = hash, hint
+}
+
+// SetQueryObserver registers fn to receive the duration of every Exec, Query
+// and QueryRow, keyed by the statement's leading keyword (SELECT, INSERT, ...).
+// Statements in transactions are not observed.
+func (d *DB) SetQueryObserver(fn func(op string, d time.Duration)) {
+	d.observe.Store(&fn)
+}
+
+// Exec runs a statement on the underlying sql.DB, reporting its duration.
+func (d *DB) Exec(query string, args ...any) (sql.Result, error) {
+	defer d.observeSince(query, time.Now())
+	return d.DB.Exec(query, args...)
+}
+
+// Query runs a query on the underlying sql.DB, reporting how long it took to
+// produce its first row.
+func (d *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	defer d.observeSince(query, time.Now())
+	return d.DB.Query(query, args...)
+}
+
+// QueryRow runs a single-row query on the underlying sql.DB, reporting its duration.
+func (d *DB) QueryRow(query string, args ...any) *sql.Row {
+	defer d.observeSince(query, time.Now())
+	return d.DB.QueryRow(query, args...)
+}
+
+func (d *DB) observeSince(query string, start time.Time) {
+	observe := d.observe.Load()
+	if observe == nil {
+		return
 	}
+	op := strings.TrimSpace(query)
+	if i := strings.IndexFunc(op, unicode.IsSpace); i >= 0 {
+		op = op[:i]
+	}
+	(*observe)(strings.ToUpper(op), time.Since(start))
+}
 
-	return d, nil
+// Tx is a transaction on the database.
+type Tx struct {
+	*sql.Tx
 }
 
-func (d *DB) migrate() error {
-	_, err := d.Exec(schema)
-	return err
+// Update runs fn in a transaction, which is committed if fn returns nil and
+// rolled back otherwise.
+func (d *DB) Update(fn func(tx *Tx) error) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(&Tx{tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-const schema = `
+// baselineSchema is the schema of the first release, created by migration 1.
+// It must not change; schema changes are new migrations.
+const baselineSchema = `
 CREATE TABLE IF NOT EXISTS users (
     id           INTEGER PRIMARY KEY AUTOINCREMENT,
     itcode       TEXT    NOT NULL UNIQUE,
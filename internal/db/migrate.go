@@ -0,0 +1,314 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Migration is a numbered, forward-only change to the database schema.
+type Migration struct {
+	Version int
+	Name    string
+	up      func(d *DB, tx *sql.Tx) error
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+// migrations are applied in order, each in its own transaction together with
+// its row in schema_migrations. A released migration is never edited or
+// removed; schema changes are made by appending a new one with the next
+// version.
+//
+// Before versioned migrations the schema was brought up to date at every
+// start, so a database from that time may already have any of the tables
+// and columns of migrations 2 to 12; those skip what exists.
+var migrations = []Migration{
+	{Version: 1, Name: "baseline", up: execMigration(baselineSchema)},
+	{Version: 2, Name: "user_models", up: execMigration(`
+CREATE TABLE IF NOT EXISTS user_models (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id        INTEGER NOT NULL REFERENCES users(id),
+    model          TEXT    NOT NULL,
+    application_id INTEGER,
+    created_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, model)
+);
+CREATE INDEX IF NOT EXISTS idx_user_models_user_id ON user_models(user_id);`)},
+	{Version: 3, Name: "cache_tokens", up: addColumns(
+		column{"usage_logs", "cache_creation_tokens", "INTEGER NOT NULL DEFAULT 0"},
+		column{"usage_logs", "cache_read_tokens", "INTEGER NOT NULL DEFAULT 0"},
+		column{"daily_stats", "cache_creation_tokens", "INTEGER NOT NULL DEFAULT 0"},
+		column{"daily_stats", "cache_read_tokens", "INTEGER NOT NULL DEFAULT 0"},
+	)},
+	{Version: 4, Name: "client_aborted", up: addColumns(
+		column{"usage_logs", "client_aborted", "INTEGER NOT NULL DEFAULT 0"},
+	)},
+	{Version: 5, Name: "model_prices", up: execMigration(`
+CREATE TABLE IF NOT EXISTS model_prices (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    model             TEXT    NOT NULL,
+    input_price       REAL    NOT NULL DEFAULT 0,
+    output_price      REAL    NOT NULL DEFAULT 0,
+    cache_write_price REAL    NOT NULL DEFAULT 0,
+    cache_read_price  REAL    NOT NULL DEFAULT 0,
+    effective_from    TEXT    NOT NULL DEFAULT '',
+    created_at        DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at        DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(model, effective_from)
+);`)},
+	{Version: 6, Name: "rate_limits", up: execMigration(`
+CREATE TABLE IF NOT EXISTS rate_limits (
+    id                       INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope                    TEXT    NOT NULL,
+    subject_id               INTEGER NOT NULL,
+    requests_per_minute      INTEGER NOT NULL DEFAULT 0,
+    input_tokens_per_minute  INTEGER NOT NULL DEFAULT 0,
+    output_tokens_per_minute INTEGER NOT NULL DEFAULT 0,
+    max_concurrent           INTEGER NOT NULL DEFAULT 0,
+    created_at               DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at               DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(scope, subject_id)
+);`)},
+	{Version: 7, Name: "api_key_hint", up: addColumns(
+		column{"api_keys", "key_hint", "TEXT NOT NULL DEFAULT ''"},
+	)},
+	{Version: 8, Name: "hash_api_keys", up: hashAPIKeys},
+	{Version: 9, Name: "backends", up: execMigration(`
+CREATE TABLE IF NOT EXISTS backends (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT    NOT NULL UNIQUE,
+    url        TEXT    NOT NULL,
+    api_key    TEXT    NOT NULL,
+    weight     INTEGER NOT NULL DEFAULT 1,
+    enabled    INTEGER NOT NULL DEFAULT 1,
+    protocol   TEXT    NOT NULL DEFAULT 'anthropic',
+    models     TEXT    NOT NULL DEFAULT '[]', -- JSON array of model names or globs
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`)},
+	{Version: 10, Name: "backend_priority", up: addColumns(
+		column{"backends", "priority", "INTEGER NOT NULL DEFAULT 1"},
+		column{"backends", "max_in_flight", "INTEGER NOT NULL DEFAULT 0"},
+	)},
+	{Version: 11, Name: "requested_model", up: addColumns(
+		column{"usage_logs", "requested_model", "TEXT NOT NULL DEFAULT ''"},
+	)},
+	{Version: 12, Name: "request_ids", up: chain(
+		addColumns(
+			column{"usage_logs", "request_id", "TEXT NOT NULL DEFAULT ''"},
+			column{"usage_logs", "upstream_request_id", "TEXT NOT NULL DEFAULT ''"},
+			column{"usage_logs", "client_ip", "TEXT NOT NULL DEFAULT ''"},
+			column{"usage_logs", "user_agent", "TEXT NOT NULL DEFAULT ''"},
+		),
+		execMigration(`
+CREATE INDEX IF NOT EXISTS idx_usage_logs_request_id ON usage_logs(request_id);
+CREATE INDEX IF NOT EXISTS idx_usage_logs_upstream_request_id ON usage_logs(upstream_request_id);`),
+	)},
+	// 1-hour cache writes cost twice the input price, against 1.25 times for
+	// the 5-minute cache priced by cache_write_price.
+	{Version: 13, Name: "cache_write_1h", up: execMigration(`
+ALTER TABLE usage_logs ADD COLUMN cache_write_1h_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE model_prices ADD COLUMN cache_write_1h_price REAL NOT NULL DEFAULT 0;
+UPDATE model_prices SET cache_write_1h_price = input_price * 2 WHERE cache_write_price > 0;`)},
+	// Backends declared in the config file are read-only in the admin console.
+	{Version: 14, Name: "backend_from_config", up: execMigration(`
+ALTER TABLE backends ADD COLUMN from_config INTEGER NOT NULL DEFAULT 0;`)},
+}
+
+// LatestVersion is the schema version this binary migrates databases to.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT    NOT NULL,
+    applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// SchemaVersion returns the highest migration version applied to the
+// database, or 0 if none is.
+func (d *DB) SchemaVersion() (int, error) {
+	applied, err := d.appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+	v := 0
+	for version := range applied {
+		v = max(v, version)
+	}
+	return v, nil
+}
+
+// PendingMigrations returns the migrations not yet applied to the database.
+// It fails if the database has a migration this binary does not know, i.e.
+// it was migrated by a newer version of the gateway.
+func (d *DB) PendingMigrations() ([]Migration, error) {
+	applied, err := d.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	for version := range applied {
+		if version > LatestVersion() {
+			return nil, fmt.Errorf("database schema version %d is newer than this binary supports (%d); upgrade the gateway", version, LatestVersion())
+		}
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order and returns them. A failed
+// migration is rolled back and stops the ones after it.
+func (d *DB) Migrate() ([]Migration, error) {
+	if _, err := d.Exec(migrationsTable); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	pending, err := d.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		if err := d.apply(m); err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+func (d *DB) apply(m Migration) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.up(d, tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appliedVersions returns the versions recorded in schema_migrations, which
+// does not exist before the first migration.
+func (d *DB) appliedVersions() (map[int]bool, error) {
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&n); err != nil {
+		return nil, fmt.Errorf("inspect schema_migrations: %w", err)
+	}
+	applied := make(map[int]bool)
+	if n == 0 {
+		return applied, nil
+	}
+	rows, err := d.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// execMigration returns a migration that runs the statements in stmts.
+func execMigration(stmts string) func(d *DB, tx *sql.Tx) error {
+	return func(_ *DB, tx *sql.Tx) error {
+		_, err := tx.Exec(stmts)
+		return err
+	}
+}
+
+// column is a column added to an existing table.
+type column struct {
+	table, name, definition string
+}
+
+// addColumns returns a migration that adds each of columns unless its table
+// already has it.
+func addColumns(columns ...column) func(d *DB, tx *sql.Tx) error {
+	return func(_ *DB, tx *sql.Tx) error {
+		for _, c := range columns {
+			if err := ensureColumn(tx, c.table, c.name, c.definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// chain returns a migration that runs steps in order.
+func chain(steps ...func(d *DB, tx *sql.Tx) error) func(d *DB, tx *sql.Tx) error {
+	return func(d *DB, tx *sql.Tx) error {
+		for _, step := range steps {
+			if err := step(d, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ensureColumn adds column to table unless it already exists.
+func ensureColumn(tx *sql.Tx, table, column, definition string) error {
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil {
+		return fmt.Errorf("inspect %s: %w", table, err)
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// hashAPIKeys replaces keys stored in plaintext, as they were before keys
+// were hashed, with their digest and display hint. Clients keep using the
+// same keys.
+func hashAPIKeys(d *DB, tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, key FROM api_keys WHERE key LIKE 'sk-%'`)
+	if err != nil {
+		return err
+	}
+	plain := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return err
+		}
+		plain[id] = key
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+	if d.hashKey == nil || d.keyHint == nil {
+		return fmt.Errorf("%d api keys are stored in plaintext and no key hasher is set", len(plain))
+	}
+	for id, key := range plain {
+		if _, err := tx.Exec(`UPDATE api_keys SET key=?, key_hint=? WHERE id=?`, d.hashKey(key), d.keyHint(key), id); err != nil {
+			return fmt.Errorf("hash api key %d: %w", id, err)
+		}
+	}
+	return nil
+}
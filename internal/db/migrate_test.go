@@ -0,0 +1,108 @@
+package db_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wjzhangq/claude-gateway/internal/db"
+)
+
+// openBaseline creates a database with the schema of the first release, from
+// before versioned migrations, holding a user with a plaintext API key and a
+// usage row.
+func openBaseline(t *testing.T) *db.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.db")
+	legacy, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := os.ReadFile(filepath.Join("testdata", "baseline.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		string(schema),
+		`INSERT INTO users (itcode) VALUES ('alice')`,
+		`INSERT INTO api_keys (user_id, key) VALUES (1, 'sk-plaintext-0123456789')`,
+		`INSERT INTO usage_logs (user_id, api_key_id, model, input_tokens) VALUES (1, 1, 'claude', 7)`,
+	} {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return legacy
+}
+
+func TestMigrate_UpgradesBaselineDatabase(t *testing.T) {
+	d := openBaseline(t)
+	defer d.Close()
+	d.SetKeyHasher(func(key string) string { return "digest:" + key }, func(string) string { return "sk-p...6789" })
+	applied, err := d.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != db.LatestVersion() {
+		t.Fatalf("expected every migration to be applied, got %v", applied)
+	}
+	if v, err := d.SchemaVersion(); err != nil || v != db.LatestVersion() {
+		t.Fatalf("expected schema version %d, got %d (%v)", db.LatestVersion(), v, err)
+	}
+
+	var requestID string
+	var cacheRead int64
+	if err := d.QueryRow(`SELECT request_id, cache_read_tokens FROM usage_logs WHERE user_id = 1`).Scan(&requestID, &cacheRead); err != nil {
+		t.Fatalf("expected existing usage rows to gain the new columns: %v", err)
+	}
+	var key, hint string
+	if err := d.QueryRow(`SELECT key, key_hint FROM api_keys WHERE id = 1`).Scan(&key, &hint); err != nil {
+		t.Fatal(err)
+	}
+	if key != "digest:sk-plaintext-0123456789" || hint != "sk-p...6789" {
+		t.Fatalf("expected the plaintext key to be hashed, got key=%q hint=%q", key, hint)
+	}
+	for _, table := range []string{"user_models", "model_prices", "rate_limits", "backends"} {
+		var n int
+		if err := d.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatalf("expected table %s to exist: %v", table, err)
+		}
+	}
+	if applied, err := d.Migrate(); err != nil || len(applied) != 0 {
+		t.Fatalf("expected a migrated database to have nothing pending, got %v (%v)", applied, err)
+	}
+}
+
+func TestMigrate_PlaintextKeysNeedAHasher(t *testing.T) {
+	d := openBaseline(t)
+	defer d.Close()
+	applied, err := d.Migrate()
+	if err == nil || !strings.Contains(err.Error(), "hash_api_keys") {
+		t.Fatalf("expected the key hashing migration to fail, got %v", err)
+	}
+	if v, _ := d.SchemaVersion(); v != len(applied) || v >= db.LatestVersion() {
+		t.Fatalf("expected migration to stop before hashing keys, at version %d after %v", v, applied)
+	}
+	var key string
+	if err := d.QueryRow(`SELECT key FROM api_keys WHERE id = 1`).Scan(&key); err != nil || key != "sk-plaintext-0123456789" {
+		t.Fatalf("expected the key to be left as it was, got %q (%v)", key, err)
+	}
+}
+
+func TestMigrate_RefusesNewerDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.db")
+	d, err := db.Init(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := db.LatestVersion() + 1
+	if _, err := d.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'from_the_future')`, newer); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	if _, err := db.Init(path); err == nil || !strings.Contains(err.Error(), "newer than this binary") {
+		t.Fatalf("expected a newer database to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+// ListModelPrices returns every price entry, ordered by model and effective date.
+func (d *DB) ListModelPrices() ([]*model.ModelPrice, error) {
+	rows, err := d.Query(
+		`SELECT id, model, input_price, output_price, cache_write_price, cache_write_1h_price, cache_read_price, effective_from, created_at, updated_at
+		 FROM model_prices ORDER BY model, effective_from`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*model.ModelPrice
+	for rows.Next() {
+		p := &model.ModelPrice{}
+		if err := rows.Scan(&p.ID, &p.Model, &p.InputPrice, &p.OutputPrice, &p.CacheWritePrice, &p.CacheWrite1hPrice, &p.CacheReadPrice,
+			&p.EffectiveFrom, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// GetModelPrice returns a price entry by ID, or nil if it does not exist.
+func (d *DB) GetModelPrice(id int64) (*model.ModelPrice, error) {
+	p := &model.ModelPrice{}
+	err := d.QueryRow(
+		`SELECT id, model, input_price, output_price, cache_write_price, cache_write_1h_price, cache_read_price, effective_from, created_at, updated_at
+		 FROM model_prices WHERE id = ?`, id,
+	).Scan(&p.ID, &p.Model, &p.InputPrice, &p.OutputPrice, &p.CacheWritePrice, &p.CacheWrite1hPrice, &p.CacheReadPrice,
+		&p.EffectiveFrom, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get model price: %w", err)
+	}
+	return p, nil
+}
+
+// CreateModelPrice inserts a price entry.
+func (d *DB) CreateModelPrice(p *model.ModelPrice) error {
+	now := time.Now()
+	res, err := d.Exec(
+		`INSERT INTO model_prices (model, input_price, output_price, cache_write_price, cache_write_1h_price, cache_read_price, effective_from, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Model, p.InputPrice, p.OutputPrice, p.CacheWritePrice, p.CacheWrite1hPrice, p.CacheReadPrice, p.EffectiveFrom, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("create model price: %w", err)
+	}
+	p.ID, _ = res.LastInsertId()
+	p.CreatedAt, p.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateModelPrice saves changes to an existing price entry.
+func (d *DB) UpdateModelPrice(p *model.ModelPrice) error {
+	p.UpdatedAt = time.Now()
+	_, err := d.Exec(
+		`UPDATE model_prices SET model=?, input_price=?, output_price=?, cache_write_price=?, cache_write_1h_price=?, cache_read_price=?, effective_from=?, updated_at=?
+		 WHERE id=?`,
+		p.Model, p.InputPrice, p.OutputPrice, p.CacheWritePrice, p.CacheWrite1hPrice, p.CacheReadPrice, p.EffectiveFrom, p.UpdatedAt, p.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update model price: %w", err)
+	}
+	return nil
+}
+
+// UpsertModelPrice inserts a price entry or overwrites the prices of the entry
+// with the same model and effective date.
+func (tx *Tx) UpsertModelPrice(p *model.ModelPrice) error {
+	now := time.Now()
+	_, err := tx.Exec(
+		`INSERT INTO model_prices (model, input_price, output_price, cache_write_price, cache_write_1h_price, cache_read_price, effective_from, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(model, effective_from) DO UPDATE SET
+			input_price          = excluded.input_price,
+			output_price         = excluded.output_price,
+			cache_write_price    = excluded.cache_write_price,
+			cache_write_1h_price = excluded.cache_write_1h_price,
+			cache_read_price     = excluded.cache_read_price,
+			updated_at           = excluded.updated_at`,
+		p.Model, p.InputPrice, p.OutputPrice, p.CacheWritePrice, p.CacheWrite1hPrice, p.CacheReadPrice, p.EffectiveFrom, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert model price: %w", err)
+	}
+	return nil
+}
+
+// DeleteModelPrice removes a price entry.
+func (d *DB) DeleteModelPrice(id int64) error {
+	if _, err := d.Exec(`DELETE FROM model_prices WHERE id=?`, id); err != nil {
+		return fmt.Errorf("delete model price: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+// ListRateLimits returns every rate limit override.
+func (d *DB) ListRateLimits() ([]*model.RateLimit, error) {
+	rows, err := d.Query(
+		`SELECT id, scope, subject_id, requests_per_minute, input_tokens_per_minute, output_tokens_per_minute, max_concurrent, created_at, updated_at
+		 FROM rate_limits ORDER BY scope, subject_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*model.RateLimit
+	for rows.Next() {
+		r := &model.RateLimit{}
+		if err := rows.Scan(&r.ID, &r.Scope, &r.SubjectID, &r.RequestsPerMinute, &r.InputTokensPerMinute,
+			&r.OutputTokensPerMinute, &r.MaxConcurrent, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// UpsertRateLimit sets the rate limits of a user or API key, replacing any existing override.
+func (d *DB) UpsertRateLimit(r *model.RateLimit) error {
+	now := time.Now()
+	_, err := d.Exec(
+		`INSERT INTO rate_limits (scope, subject_id, requests_per_minute, input_tokens_per_minute, output_tokens_per_minute, max_concurrent, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(scope, subject_id) DO UPDATE SET
+			requests_per_minute      = excluded.requests_per_minute,
+			input_tokens_per_minute  = excluded.input_tokens_per_minute,
+			output_tokens_per_minute = excluded.output_tokens_per_minute,
+			max_concurrent           = excluded.max_concurrent,
+			updated_at               = excluded.updated_at`,
+		r.Scope, r.SubjectID, r.RequestsPerMinute, r.InputTokensPerMinute, r.OutputTokensPerMinute, r.MaxConcurrent, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert rate limit: %w", err)
+	}
+	r.UpdatedAt = now
+	return d.QueryRow(`SELECT id, created_at FROM rate_limits WHERE scope=? AND subject_id=?`, r.Scope, r.SubjectID).
+		Scan(&r.ID, &r.CreatedAt)
+}
+
+// DeleteRateLimit removes an override, so the subject falls back to the configured defaults.
+func (d *DB) DeleteRateLimit(id int64) error {
+	if _, err := d.Exec(`DELETE FROM rate_limits WHERE id=?`, id); err != nil {
+		return fmt.Errorf("delete rate limit: %w", err)
+	}
+	return nil
+}
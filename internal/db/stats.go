@@ -7,25 +7,57 @@ import (
 	"github.com/wjzhangq/claude-gateway/internal/model"
 )
 
+const insertUsageLogSQL = `INSERT INTO usage_logs
+	 (user_id, api_key_id, model, requested_model, backend, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, cache_write_1h_tokens, total_tokens, cost_usd, status_code, latency_ms, client_aborted, request_id, upstream_request_id, client_ip, user_agent, created_at)
+	 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func usageLogArgs(log *model.UsageLog) []interface{} {
+	createdAt := log.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	return []interface{}{
+		log.UserID, log.APIKeyID, log.Model, log.RequestedModel, log.Backend,
+		log.InputTokens, log.OutputTokens, log.CacheCreationTokens, log.CacheReadTokens, log.CacheWrite1hTokens, log.TotalTokens,
+		log.CostUSD, log.StatusCode, log.Latency, log.ClientAborted,
+		log.RequestID, log.UpstreamRequestID, log.ClientIP, log.UserAgent,
+		createdAt,
+	}
+}
+
 // InsertUsageLog writes a single usage record to the database.
 func (d *DB) InsertUsageLog(log *model.UsageLog) error {
-	_, err := d.Exec(
-		`INSERT INTO usage_logs
-		 (user_id, api_key_id, model, backend, input_tokens, output_tokens, total_tokens, cost_usd, status_code, latency_ms, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		log.UserID, log.APIKeyID, log.Model, log.Backend,
-		log.InputTokens, log.OutputTokens, log.TotalTokens,
-		log.CostUSD, log.StatusCode, log.Latency,
-		time.Now(),
-	)
-	if err != nil {
+	if _, err := d.Exec(insertUsageLogSQL, usageLogArgs(log)...); err != nil {
 		return fmt.Errorf("insert usage log: %w", err)
 	}
 	return nil
 }
 
-// ListUsageLogs queries usage logs with optional filters.
-func (d *DB) ListUsageLogs(userID int64, startDate, endDate, modelFilter string, page, pageSize int) ([]*model.UsageLog, int, error) {
+// InsertUsageLogs writes usage records in a single transaction; either all
+// rows are written or none are.
+func (d *DB) InsertUsageLogs(logs []*model.UsageLog) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(insertUsageLogSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, log := range logs {
+		if _, err := stmt.Exec(usageLogArgs(log)...); err != nil {
+			return fmt.Errorf("insert usage log: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListUsageLogs queries usage logs with optional filters. modelFilter matches
+// either the requested or the effective model, requestID either the gateway's
+// or the backend's request ID.
+func (d *DB) ListUsageLogs(userID int64, startDate, endDate, modelFilter, requestID string, page, pageSize int) ([]*model.UsageLog, int, error) {
 	countWhere := "WHERE 1=1"
 	joinWhere := "WHERE 1=1"
 	args := []interface{}{}
@@ -46,9 +78,14 @@ func (d *DB) ListUsageLogs(userID int64, startDate, endDate, modelFilter string,
 		args = append(args, endDate+" 23:59:59")
 	}
 	if modelFilter != "" {
-		countWhere += " AND model = ?"
-		joinWhere += " AND l.model = ?"
-		args = append(args, modelFilter)
+		countWhere += " AND (model = ? OR requested_model = ?)"
+		joinWhere += " AND (l.model = ? OR l.requested_model = ?)"
+		args = append(args, modelFilter, modelFilter)
+	}
+	if requestID != "" {
+		countWhere += " AND (request_id = ? OR upstream_request_id = ?)"
+		joinWhere += " AND (l.request_id = ? OR l.upstream_request_id = ?)"
+		args = append(args, requestID, requestID)
 	}
 
 	var total int
@@ -63,7 +100,7 @@ func (d *DB) ListUsageLogs(userID int64, startDate, endDate, modelFilter string,
 	joinArgs := append(args, pageSize, offset)
 
 	rows, err := d.Query(
-		`SELECT l.id, l.user_id, u.itcode, l.api_key_id, l.model, l.backend, l.input_tokens, l.output_tokens, l.total_tokens, l.cost_usd, l.status_code, l.latency_ms, l.created_at
+		`SELECT l.id, l.user_id, u.itcode, l.api_key_id, l.model, l.requested_model, l.backend, l.input_tokens, l.output_tokens, l.cache_creation_tokens, l.cache_read_tokens, l.cache_write_1h_tokens, l.total_tokens, l.cost_usd, l.status_code, l.latency_ms, l.client_aborted, l.request_id, l.upstream_request_id, l.client_ip, l.user_agent, l.created_at
 		 FROM usage_logs l LEFT JOIN users u ON u.id = l.user_id `+joinWhere+` ORDER BY l.created_at DESC LIMIT ? OFFSET ?`, joinArgs...)
 	if err != nil {
 		return nil, 0, err
@@ -73,9 +110,10 @@ func (d *DB) ListUsageLogs(userID int64, startDate, endDate, modelFilter string,
 	var logs []*model.UsageLog
 	for rows.Next() {
 		l := &model.UsageLog{}
-		if err := rows.Scan(&l.ID, &l.UserID, &l.Itcode, &l.APIKeyID, &l.Model, &l.Backend,
-			&l.InputTokens, &l.OutputTokens, &l.TotalTokens, &l.CostUSD,
-			&l.StatusCode, &l.Latency, &l.CreatedAt); err != nil {
+		if err := rows.Scan(&l.ID, &l.UserID, &l.Itcode, &l.APIKeyID, &l.Model, &l.RequestedModel, &l.Backend,
+			&l.InputTokens, &l.OutputTokens, &l.CacheCreationTokens, &l.CacheReadTokens, &l.CacheWrite1hTokens, &l.TotalTokens, &l.CostUSD,
+			&l.StatusCode, &l.Latency, &l.ClientAborted, &l.RequestID, &l.UpstreamRequestID, &l.ClientIP, &l.UserAgent,
+			&l.CreatedAt); err != nil {
 			return nil, 0, err
 		}
 		logs = append(logs, l)
@@ -133,3 +171,100 @@ func (d *DB) GetBackendStats(startDate, endDate string) ([]*BackendStat, error)
 	return result, rows.Err()
 }
 
+// SumTokensByUserSince returns total tokens consumed per user since the given time.
+// A zero since sums the whole history.
+func (d *DB) SumTokensByUserSince(since time.Time) (map[int64]int64, error) {
+	where := ""
+	args := []interface{}{}
+	if !since.IsZero() {
+		where = "WHERE created_at >= ?"
+		// created_at is written as a local time; compare in the same zone.
+		args = append(args, since.In(time.Local))
+	}
+	rows, err := d.Query(
+		`SELECT user_id, COALESCE(SUM(total_tokens), 0) FROM usage_logs `+where+` GROUP BY user_id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("sum tokens by user: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int64]int64)
+	for rows.Next() {
+		var userID, tokens int64
+		if err := rows.Scan(&userID, &tokens); err != nil {
+			return nil, err
+		}
+		result[userID] = tokens
+	}
+	return result, rows.Err()
+}
+
+// UsageCostFunc returns the cost of a usage log entry.
+type UsageCostFunc func(model string, at time.Time, inputTokens, outputTokens, cacheCreationTokens, cacheWrite1hTokens, cacheReadTokens int) float64
+
+// RecomputeCosts recalculates cost_usd with cost for every usage log created
+// between startDate and endDate (YYYY-MM-DD, inclusive). It returns the number of
+// rows updated.
+func (d *DB) RecomputeCosts(startDate, endDate string, cost UsageCostFunc) (int, error) {
+	const batchSize = 1000
+	type update struct {
+		id   int64
+		cost float64
+	}
+
+	updated := 0
+	var lastID int64
+	for {
+		rows, err := d.Query(
+			`SELECT id, model, input_tokens, output_tokens, cache_creation_tokens, cache_write_1h_tokens, cache_read_tokens, cost_usd, created_at
+			 FROM usage_logs WHERE created_at >= ? AND created_at <= ? AND id > ? ORDER BY id LIMIT ?`,
+			startDate, endDate+" 23:59:59", lastID, batchSize)
+		if err != nil {
+			return updated, fmt.Errorf("recompute costs: %w", err)
+		}
+		var batch []update
+		n := 0
+		for rows.Next() {
+			var (
+				id                                           int64
+				modelName                                    string
+				in, out, cacheWrite, cacheWrite1h, cacheRead int
+				old                                          float64
+				createdAt                                    time.Time
+			)
+			if err := rows.Scan(&id, &modelName, &in, &out, &cacheWrite, &cacheWrite1h, &cacheRead, &old, &createdAt); err != nil {
+				rows.Close()
+				return updated, err
+			}
+			n++
+			lastID = id
+			if c := cost(modelName, createdAt, in, out, cacheWrite, cacheWrite1h, cacheRead); c != old {
+				batch = append(batch, update{id, c})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return updated, err
+		}
+
+		if len(batch) > 0 {
+			tx, err := d.Begin()
+			if err != nil {
+				return updated, err
+			}
+			for _, u := range batch {
+				if _, err := tx.Exec(`UPDATE usage_logs SET cost_usd=? WHERE id=?`, u.cost, u.id); err != nil {
+					tx.Rollback()
+					return updated, fmt.Errorf("update cost: %w", err)
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				return updated, err
+			}
+			updated += len(batch)
+		}
+		if n < batchSize {
+			return updated, nil
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package db_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+func TestSumTokensByUserSince_NonUTCZone(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	d, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	now := time.Now()
+	for _, l := range []*model.UsageLog{
+		{UserID: 1, Model: "claude", TotalTokens: 10, CreatedAt: now.Add(-2 * time.Hour)},
+		{UserID: 1, Model: "claude", TotalTokens: 5, CreatedAt: now},
+	} {
+		if err := d.InsertUsageLog(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	used, err := d.SumTokensByUserSince(now.Add(-time.Hour).UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used[1] != 5 {
+		t.Fatalf("expected only the last hour's 5 tokens, got %d", used[1])
+	}
+}
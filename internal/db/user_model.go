@@ -0,0 +1,70 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+// GrantUserModel entitles a user to call a model. Granting an existing entitlement is a no-op.
+func (d *DB) GrantUserModel(userID int64, modelName string, applicationID *int64) error {
+	_, err := d.Exec(
+		`INSERT INTO user_models (user_id, model, application_id, created_at)
+		 VALUES (?, ?, ?, ?)
+		 ON CONFLICT(user_id, model) DO NOTHING`,
+		userID, modelName, applicationID, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("grant user model: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserModel removes a user's entitlement to a model.
+func (d *DB) RevokeUserModel(userID int64, modelName string) error {
+	_, err := d.Exec(`DELETE FROM user_models WHERE user_id=? AND model=?`, userID, modelName)
+	if err != nil {
+		return fmt.Errorf("revoke user model: %w", err)
+	}
+	return nil
+}
+
+// ListUserModels returns the models a user has been granted.
+func (d *DB) ListUserModels(userID int64) ([]*model.UserModel, error) {
+	rows, err := d.Query(
+		`SELECT id, user_id, model, application_id, created_at
+		 FROM user_models WHERE user_id = ? ORDER BY model`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*model.UserModel
+	for rows.Next() {
+		m := &model.UserModel{}
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Model, &m.ApplicationID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// ListAllUserModels returns every granted model name keyed by user ID.
+func (d *DB) ListAllUserModels() (map[int64][]string, error) {
+	rows, err := d.Query(`SELECT user_id, model FROM user_models ORDER BY user_id, model`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[int64][]string)
+	for rows.Next() {
+		var userID int64
+		var m string
+		if err := rows.Scan(&userID, &m); err != nil {
+			return nil, err
+		}
+		result[userID] = append(result[userID], m)
+	}
+	return result, rows.Err()
+}
@@ -47,11 +47,20 @@ func (h *APIKeyHandler) CreateKey(c *gin.Context) {
 		return
 	}
 
+	// Read the grants first, so a failure leaves no key without its models.
+	models, err := userModelNames(h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	k := &model.APIKey{
-		UserID: userID,
-		Key:    keyStr,
-		Name:   req.Name,
-		Status: "active",
+		UserID:  userID,
+		Key:     keyStr,
+		KeyHash: h.keyStore.Hash(keyStr),
+		KeyHint: auth.KeyHint(keyStr),
+		Name:    req.Name,
+		Status:  "active",
 	}
 	if err := h.db.CreateAPIKey(k); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -64,14 +73,16 @@ func (h *APIKeyHandler) CreateKey(c *gin.Context) {
 	if user != nil {
 		quota = user.QuotaTokens
 	}
-	h.keyStore.Add(keyStr, &auth.KeyInfo{
+	h.keyStore.Add(k.KeyHash, &auth.KeyInfo{
 		KeyID:       k.ID,
 		UserID:      userID,
 		Itcode:      func() string { if user != nil { return user.Itcode }; return "" }(),
 		QuotaTokens: quota,
 		UserStatus:  "active",
+		Models:      models,
 	})
 
+	// The plaintext key is returned only in this response.
 	c.JSON(http.StatusCreated, gin.H{"key": k})
 }
 
@@ -97,7 +108,7 @@ func (h *APIKeyHandler) setKeyStatus(c *gin.Context, status string) {
 	}
 	// Sync memory: fetch the key record to get the key string
 	// For simplicity, reload all keys (low frequency operation)
-	h.reloadKeys()
+	reloadKeyStore(h.db, h.keyStore)
 	c.JSON(http.StatusOK, gin.H{"status": status})
 }
 
@@ -112,16 +123,22 @@ func (h *APIKeyHandler) DeleteKey(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	h.reloadKeys()
+	reloadKeyStore(h.db, h.keyStore)
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
 
-func (h *APIKeyHandler) reloadKeys() {
-	keys, err := h.db.ListAllActiveAPIKeys()
+// reloadKeyStore rebuilds the in-memory key map from the database so that
+// key and user changes take effect on the proxy path immediately.
+func reloadKeyStore(database *db.DB, ks *auth.KeyStore) {
+	keys, err := database.ListAllActiveAPIKeys()
 	if err != nil {
 		return
 	}
-	users, err := h.db.ListUsers()
+	users, err := database.ListUsers()
+	if err != nil {
+		return
+	}
+	models, err := database.ListAllUserModels()
 	if err != nil {
 		return
 	}
@@ -133,5 +150,18 @@ func (h *APIKeyHandler) reloadKeys() {
 	for i, k := range keys {
 		apiKeys[i] = *k
 	}
-	h.keyStore.Load(apiKeys, userMap)
+	ks.Load(apiKeys, userMap, models)
+}
+
+// userModelNames returns the names of the models granted to a user.
+func userModelNames(database *db.DB, userID int64) ([]string, error) {
+	granted, err := database.ListUserModels(userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(granted))
+	for i, m := range granted {
+		names[i] = m.Model
+	}
+	return names, nil
 }
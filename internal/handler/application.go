@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/wjzhangq/claude-gateway/internal/auth"
 	"github.com/wjzhangq/claude-gateway/internal/db"
 	"github.com/wjzhangq/claude-gateway/internal/middleware"
 	"github.com/wjzhangq/claude-gateway/internal/model"
@@ -13,11 +14,12 @@ import (
 
 // ApplicationHandler manages model access applications.
 type ApplicationHandler struct {
-	db *db.DB
+	db       *db.DB
+	keyStore *auth.KeyStore
 }
 
-func NewApplicationHandler(database *db.DB) *ApplicationHandler {
-	return &ApplicationHandler{db: database}
+func NewApplicationHandler(database *db.DB, ks *auth.KeyStore) *ApplicationHandler {
+	return &ApplicationHandler{db: database, keyStore: ks}
 }
 
 // Submit godoc: POST /api/applications
@@ -84,10 +86,30 @@ func (h *ApplicationHandler) Review(c *gin.Context) {
 		return
 	}
 
+	app, err := h.db.GetApplicationByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if app == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "application not found"})
+		return
+	}
+
 	reviewerID := c.GetInt64("session_user_id")
 	if err := h.db.ReviewApplication(id, reviewerID, req.Status, req.Note); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	// Approval grants the model to the applicant immediately.
+	if req.Status == "approved" {
+		models, err := userModelNames(h.db, app.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		h.keyStore.SetUserModels(app.UserID, models)
+	}
 	c.JSON(http.StatusOK, gin.H{"status": req.Status})
 }
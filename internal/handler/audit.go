@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/audit"
+)
+
+// AuditHandler searches and shows captured requests and responses (admin only).
+// store is nil when audit logging is disabled.
+type AuditHandler struct {
+	store *audit.Store
+}
+
+func NewAuditHandler(store *audit.Store) *AuditHandler {
+	return &AuditHandler{store: store}
+}
+
+// ListCaptures godoc: GET /admin/api/audit
+// Query params: request_id (gateway or upstream), user_id, itcode, api_key_id,
+// model, start_date (YYYY-MM-DD), end_date, page, page_size
+func (h *AuditHandler) ListCaptures(c *gin.Context) {
+	if h.store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "audit logging is disabled"})
+		return
+	}
+	userID, _ := strconv.ParseInt(c.Query("user_id"), 10, 64)
+	keyID, _ := strconv.ParseInt(c.Query("api_key_id"), 10, 64)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	captures, total, err := h.store.List(audit.Filter{
+		RequestID: c.Query("request_id"),
+		UserID:    userID,
+		Itcode:    c.Query("itcode"),
+		APIKeyID:  keyID,
+		Model:     c.Query("model"),
+		StartDate: c.Query("start_date"),
+		EndDate:   c.Query("end_date"),
+		Page:      page,
+		PageSize:  pageSize,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"captures":  captures,
+	})
+}
+
+// GetCapture godoc: GET /admin/api/audit/:id
+// Returns a capture with its request and response bodies. Bodies that are
+// valid JSON are embedded as JSON, anything else as a string.
+func (h *AuditHandler) GetCapture(c *gin.Context) {
+	if h.store == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "audit logging is disabled"})
+		return
+	}
+	capture, err := h.store.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if capture == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "capture not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"capture":  capture,
+		"request":  bodyJSON(capture.Request),
+		"response": bodyJSON(capture.Response),
+	})
+}
+
+func bodyJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	if json.Valid(b) {
+		return json.RawMessage(b)
+	}
+	return string(b)
+}
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"net/http"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -19,13 +20,27 @@ import (
 
 // AuthHandler handles login/logout and verification code flows.
 type AuthHandler struct {
-	db        *db.DB
-	codeStore *auth.CodeStore
-	cfg       *config.AuthConfig
+	db         *db.DB
+	codeStore  *auth.CodeStore
+	cfg        *config.AuthConfig
+	inviteCode atomic.Pointer[string]
 }
 
 func NewAuthHandler(database *db.DB, cs *auth.CodeStore, cfg *config.AuthConfig) *AuthHandler {
-	return &AuthHandler{db: database, codeStore: cs, cfg: cfg}
+	h := &AuthHandler{db: database, codeStore: cs, cfg: cfg}
+	h.SetInviteCode(cfg.InviteCode)
+	return h
+}
+
+// SetInviteCode changes the invite code required to log in. An empty code
+// lets anyone log in. It is safe to call while serving.
+func (h *AuthHandler) SetInviteCode(code string) {
+	h.inviteCode.Store(&code)
+}
+
+func (h *AuthHandler) validInviteCode(code string) bool {
+	want := *h.inviteCode.Load()
+	return want == "" || code == want
 }
 
 // SendCode godoc: POST /api/auth/send-code
@@ -40,7 +55,7 @@ func (h *AuthHandler) SendCode(c *gin.Context) {
 	}
 
 	// Validate invite code before sending
-	if h.cfg.InviteCode != "" && req.InviteCode != h.cfg.InviteCode {
+	if !h.validInviteCode(req.InviteCode) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "invalid invite code"})
 		return
 	}
@@ -114,7 +129,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Validate invite code
-	if h.cfg.InviteCode != "" && req.InviteCode != h.cfg.InviteCode {
+	if !h.validInviteCode(req.InviteCode) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "invalid invite code"})
 		return
 	}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+)
+
+// BackendHandler manages upstream backends (admin only). Changes are applied to
+// the load balancer immediately; in-flight requests finish on the backend they started on.
+// Backends declared in the config file are read-only here, as the config file
+// would overwrite changes on the next start or reload.
+type BackendHandler struct {
+	db *db.DB
+	lb *proxy.LoadBalancer
+}
+
+func NewBackendHandler(database *db.DB, lb *proxy.LoadBalancer) *BackendHandler {
+	return &BackendHandler{db: database, lb: lb}
+}
+
+// backendView is a backend as returned by the API. The upstream key is write-only.
+type backendView struct {
+	*model.Backend
+	HasAPIKey        bool                 `json:"has_api_key"`
+	Health           *proxy.BackendHealth `json:"health,omitempty"`            // nil while the backend is disabled
+	DiscoveredModels []string             `json:"discovered_models,omitempty"` // nil until GET /v1/models succeeds
+}
+
+type backendRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	URL         string   `json:"url" binding:"required"`
+	APIKey      string   `json:"api_key"` // required on create; empty keeps the current key on update
+	Weight      int      `json:"weight"`
+	Priority    int      `json:"priority"`
+	MaxInFlight int      `json:"max_in_flight"`
+	Enabled     *bool    `json:"enabled"`
+	Protocol    string   `json:"protocol"`
+	Models      []string `json:"models"`
+}
+
+func (r *backendRequest) apply(b *model.Backend) error {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http(s) URL")
+	}
+	switch r.Protocol {
+	case "":
+		r.Protocol = proxy.ProtocolAnthropic
+	case proxy.ProtocolAnthropic, proxy.ProtocolOpenAI:
+	default:
+		return fmt.Errorf("protocol must be anthropic or openai")
+	}
+	for _, m := range r.Models {
+		if _, err := path.Match(m, ""); err != nil || m == "" {
+			return fmt.Errorf("invalid model pattern %q", m)
+		}
+	}
+	if r.Weight <= 0 {
+		r.Weight = 1
+	}
+	if r.Priority <= 0 {
+		r.Priority = 1
+	}
+	if r.MaxInFlight < 0 {
+		return fmt.Errorf("max_in_flight must not be negative")
+	}
+
+	b.Name = r.Name
+	b.URL = r.URL
+	if r.APIKey != "" {
+		b.APIKey = r.APIKey
+	}
+	b.Weight = r.Weight
+	b.Priority = r.Priority
+	b.MaxInFlight = r.MaxInFlight
+	if r.Enabled != nil {
+		b.Enabled = *r.Enabled
+	}
+	b.Protocol = r.Protocol
+	b.Models = r.Models
+	return nil
+}
+
+// ListBackends godoc: GET /admin/api/backends
+func (h *BackendHandler) ListBackends(c *gin.Context) {
+	backends, err := h.db.ListBackends()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	live := make(map[string]*proxy.Backend)
+	for _, b := range h.lb.Backends() {
+		live[b.Name] = b
+	}
+	result := make([]backendView, len(backends))
+	for i, b := range backends {
+		result[i] = backendView{Backend: b, HasAPIKey: b.APIKey != ""}
+		if running, ok := live[b.Name]; ok {
+			hs := running.Health()
+			result[i].Health = &hs
+			result[i].DiscoveredModels = running.DiscoveredModels()
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"backends": result})
+}
+
+// CreateBackend godoc: POST /admin/api/backends
+func (h *BackendHandler) CreateBackend(c *gin.Context) {
+	var req backendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.APIKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_key is required"})
+		return
+	}
+	b := &model.Backend{Enabled: true}
+	if err := req.apply(b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.CreateBackend(b); err != nil {
+		writeBackendError(c, err)
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusCreated, backendView{Backend: b, HasAPIKey: true})
+}
+
+// UpdateBackend godoc: PUT /admin/api/backends/:id
+func (h *BackendHandler) UpdateBackend(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	b, err := h.db.GetBackend(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if b == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "backend not found"})
+		return
+	}
+	if b.FromConfig {
+		writeFromConfig(c)
+		return
+	}
+	var req backendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.apply(b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.UpdateBackend(b); err != nil {
+		writeBackendError(c, err)
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusOK, backendView{Backend: b, HasAPIKey: b.APIKey != ""})
+}
+
+// DeleteBackend godoc: DELETE /admin/api/backends/:id
+func (h *BackendHandler) DeleteBackend(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	b, err := h.db.GetBackend(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if b != nil && b.FromConfig {
+		writeFromConfig(c)
+		return
+	}
+	deleted, err := h.db.DeleteBackend(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "backend not found"})
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// reload applies the backends stored in the database to the load balancer.
+func (h *BackendHandler) reload() {
+	if err := reloadBackends(h.db, h.lb); err != nil {
+		logger.Errorf("reload backends: %v", err)
+	}
+}
+
+// reloadBackends replaces the load balancer's backend set with the backends
+// stored in the database.
+func reloadBackends(database *db.DB, lb *proxy.LoadBalancer) error {
+	backends, err := database.ListBackends()
+	if err != nil {
+		return err
+	}
+	cfgs := make([]config.BackendAPI, len(backends))
+	for i, b := range backends {
+		cfgs[i] = config.BackendAPI{
+			Name:        b.Name,
+			URL:         b.URL,
+			APIKey:      b.APIKey,
+			Weight:      b.Weight,
+			Priority:    b.Priority,
+			MaxInFlight: b.MaxInFlight,
+			Enabled:     b.Enabled,
+			Protocol:    b.Protocol,
+			Models:      b.Models,
+		}
+	}
+	lb.Replace(cfgs)
+	return nil
+}
+
+func writeFromConfig(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{"error": "this backend is declared in the config file; change it there"})
+}
+
+func writeBackendError(c *gin.Context, err error) {
+	if strings.Contains(err.Error(), "UNIQUE") {
+		c.JSON(http.StatusConflict, gin.H{"error": "a backend with this name already exists"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
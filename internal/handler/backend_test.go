@@ -0,0 +1,84 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/handler"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+)
+
+// storeDeclared stores backends as the gateway does for the config file at
+// startup and on reload.
+func storeDeclared(t *testing.T, d *db.DB, backends ...*model.Backend) {
+	t.Helper()
+	if err := d.Update(func(tx *db.Tx) error { return tx.UpsertBackends(backends) }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackendHandler_ConfigBackendsAreReadOnly(t *testing.T) {
+	d, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	declared := &model.Backend{Name: "declared", URL: "https://declared.example", APIKey: "k", Weight: 1, Priority: 1, Enabled: true, Protocol: "anthropic"}
+	storeDeclared(t, d, declared)
+	console := &model.Backend{Name: "console", URL: "https://console.example", APIKey: "k", Weight: 1, Priority: 1, Enabled: true, Protocol: "anthropic"}
+	if err := d.CreateBackend(console); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	h := handler.NewBackendHandler(d, proxy.NewLoadBalancer(nil, config.HealthCheckConfig{}))
+	r := gin.New()
+	r.PUT("/backends/:id", h.UpdateBackend)
+	r.DELETE("/backends/:id", h.DeleteBackend)
+	send := func(method, path, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	edit := `{"name":"%s","url":"https://edited.example","weight":5}`
+
+	if code := send(http.MethodPut, "/backends/1", fmt.Sprintf(edit, "declared")); code != http.StatusConflict {
+		t.Fatalf("expected editing a config backend to conflict, got %d", code)
+	}
+	if code := send(http.MethodDelete, "/backends/1", ""); code != http.StatusConflict {
+		t.Fatalf("expected deleting a config backend to conflict, got %d", code)
+	}
+	if code := send(http.MethodPut, "/backends/2", fmt.Sprintf(edit, "console")); code != http.StatusOK {
+		t.Fatalf("expected editing a console backend to succeed, got %d", code)
+	}
+
+	// A reload that still declares the backend leaves both as they are.
+	storeDeclared(t, d, declared)
+	stored, err := d.ListBackends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0].URL != "https://declared.example" || !stored[0].FromConfig {
+		t.Fatalf("expected the config backend to be kept unchanged, got %+v", stored)
+	}
+	if stored[1].URL != "https://edited.example" || stored[1].Weight != 5 || stored[1].FromConfig {
+		t.Fatalf("expected the console edit to survive the reload, got %+v", stored[1])
+	}
+
+	// Once dropped from the config file, the backend can be managed here.
+	storeDeclared(t, d)
+	if code := send(http.MethodDelete, "/backends/1", ""); code != http.StatusOK {
+		t.Fatalf("expected deleting an undeclared backend to succeed, got %d", code)
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+)
+
+// ConfigReloader holds the config in force and reloads it from the config file.
+type ConfigReloader interface {
+	Current() *config.Config
+	// Reload applies the live settings that changed in the config file and
+	// returns them, along with changes that need a restart.
+	Reload() (applied, pending []config.Change, err error)
+}
+
+// ConfigHandler shows and reloads the gateway config (admin only).
+type ConfigHandler struct {
+	reloader ConfigReloader
+}
+
+func NewConfigHandler(reloader ConfigReloader) *ConfigHandler {
+	return &ConfigHandler{reloader: reloader}
+}
+
+// GetConfig godoc: GET /admin/api/config
+// Returns the config in force, with secrets masked.
+func (h *ConfigHandler) GetConfig(c *gin.Context) {
+	tree, err := h.reloader.Current().MaskedTree()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+// ReloadConfig godoc: POST /admin/api/config/reload
+// An invalid config file is rejected with 422 and the running config is kept.
+func (h *ConfigHandler) ReloadConfig(c *gin.Context) {
+	applied, pending, err := h.reloader.Reload()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if applied == nil {
+		applied = []config.Change{}
+	}
+	if pending == nil {
+		pending = []config.Change{}
+	}
+	c.JSON(http.StatusOK, gin.H{"applied": applied, "pending_restart": pending})
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/pricing"
+)
+
+// PricingHandler manages the model price table (admin only). Changes take
+// effect for new requests immediately.
+type PricingHandler struct {
+	db     *db.DB
+	prices *pricing.Table
+}
+
+func NewPricingHandler(database *db.DB, prices *pricing.Table) *PricingHandler {
+	return &PricingHandler{db: database, prices: prices}
+}
+
+type priceRequest struct {
+	Model             string  `json:"model" binding:"required"`
+	InputPrice        float64 `json:"input_price"`
+	OutputPrice       float64 `json:"output_price"`
+	CacheWritePrice   float64 `json:"cache_write_price"`
+	CacheWrite1hPrice float64 `json:"cache_write_1h_price"` // 0 or omitted: the usual price for models with prompt caching
+	CacheReadPrice    float64 `json:"cache_read_price"`
+	EffectiveFrom     string  `json:"effective_from"`
+}
+
+func (r *priceRequest) apply(p *model.ModelPrice) {
+	p.Model = r.Model
+	p.InputPrice = r.InputPrice
+	p.OutputPrice = r.OutputPrice
+	p.CacheWritePrice = r.CacheWritePrice
+	p.CacheWrite1hPrice = r.CacheWrite1hPrice
+	if p.CacheWrite1hPrice == 0 {
+		p.CacheWrite1hPrice = pricing.CacheWrite1hPrice(r.InputPrice, r.CacheWritePrice)
+	}
+	p.CacheReadPrice = r.CacheReadPrice
+	p.EffectiveFrom = r.EffectiveFrom
+}
+
+// ListPrices godoc: GET /admin/api/pricing
+func (h *PricingHandler) ListPrices(c *gin.Context) {
+	prices, err := h.db.ListModelPrices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"prices": prices})
+}
+
+// CreatePrice godoc: POST /admin/api/pricing
+func (h *PricingHandler) CreatePrice(c *gin.Context) {
+	var req priceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p := &model.ModelPrice{}
+	req.apply(p)
+	if err := pricing.Validate(p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.CreateModelPrice(p); err != nil {
+		writePriceError(c, err)
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusCreated, p)
+}
+
+// UpdatePrice godoc: PUT /admin/api/pricing/:id
+func (h *PricingHandler) UpdatePrice(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	p, err := h.db.GetModelPrice(id)
+	if err != nil || p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "price not found"})
+		return
+	}
+	var req priceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.apply(p)
+	if err := pricing.Validate(p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.UpdateModelPrice(p); err != nil {
+		writePriceError(c, err)
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusOK, p)
+}
+
+// DeletePrice godoc: DELETE /admin/api/pricing/:id
+func (h *PricingHandler) DeletePrice(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := h.db.DeleteModelPrice(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// Recompute godoc: POST /admin/api/pricing/recompute
+// Recalculates cost_usd of usage logs in a date range with the current price
+// table, then re-aggregates the affected daily stats.
+func (h *PricingHandler) Recompute(c *gin.Context) {
+	var req struct {
+		StartDate string `json:"start_date" binding:"required"`
+		EndDate   string `json:"end_date" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, err1 := time.Parse(pricing.DateLayout, req.StartDate)
+	end, err2 := time.Parse(pricing.DateLayout, req.EndDate)
+	if err1 != nil || err2 != nil || end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_date and end_date must be YYYY-MM-DD with start_date <= end_date"})
+		return
+	}
+
+	updated, err := h.db.RecomputeCosts(req.StartDate, req.EndDate, h.prices.Cost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "updated": updated})
+		return
+	}
+	if err := h.db.AggregateRange(req.StartDate, req.EndDate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "updated": updated})
+		return
+	}
+	logger.Infof("recomputed cost of %d usage logs from %s to %s", updated, req.StartDate, req.EndDate)
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// reload refreshes the in-memory price table from the database.
+func (h *PricingHandler) reload() {
+	prices, err := h.db.ListModelPrices()
+	if err == nil {
+		err = h.prices.Load(prices)
+	}
+	if err != nil {
+		logger.Errorf("reload price table: %v", err)
+	}
+}
+
+func writePriceError(c *gin.Context, err error) {
+	if strings.Contains(err.Error(), "UNIQUE") {
+		c.JSON(http.StatusConflict, gin.H{"error": "a price for this model and effective date already exists"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
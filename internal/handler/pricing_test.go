@@ -0,0 +1,64 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/handler"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/pricing"
+)
+
+func TestPricingHandler_DefaultsOneHourCacheWritePrice(t *testing.T) {
+	d, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	gin.SetMode(gin.TestMode)
+	h := handler.NewPricingHandler(d, pricing.NewTable())
+	r := gin.New()
+	r.POST("/pricing", h.CreatePrice)
+	r.PUT("/pricing/:id", h.UpdatePrice)
+	send := func(method, path, body string) model.ModelPrice {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body)
+		}
+		var p model.ModelPrice
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// The admin form sends 0 for a blank field.
+	p := send(http.MethodPost, "/pricing", `{"model":"claude-x","input_price":3,"output_price":15,"cache_write_price":3.75,"cache_write_1h_price":0,"cache_read_price":0.3}`)
+	if p.CacheWrite1hPrice != 6 {
+		t.Fatalf("expected a blank 1-hour cache write price to default to 6, got %v", p.CacheWrite1hPrice)
+	}
+	p = send(http.MethodPut, "/pricing/1", `{"model":"claude-x","input_price":1,"output_price":5,"cache_write_price":1.25}`)
+	if p.CacheWrite1hPrice != 2 {
+		t.Fatalf("expected an omitted 1-hour cache write price to default to 2, got %v", p.CacheWrite1hPrice)
+	}
+	p = send(http.MethodPut, "/pricing/1", `{"model":"claude-x","input_price":1,"output_price":5,"cache_write_price":1.25,"cache_write_1h_price":2.5}`)
+	if p.CacheWrite1hPrice != 2.5 {
+		t.Fatalf("expected an explicit 1-hour cache write price to be kept, got %v", p.CacheWrite1hPrice)
+	}
+	p = send(http.MethodPut, "/pricing/1", `{"model":"claude-x","input_price":1,"output_price":5}`)
+	if p.CacheWrite1hPrice != 0 {
+		t.Fatalf("expected no 1-hour cache write price without prompt caching, got %v", p.CacheWrite1hPrice)
+	}
+}
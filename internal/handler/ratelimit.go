@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/ratelimit"
+)
+
+// RateLimitHandler manages per-user and per-key rate limit overrides (admin only).
+// Changes take effect for new requests immediately.
+type RateLimitHandler struct {
+	db      *db.DB
+	limiter *ratelimit.Limiter
+}
+
+func NewRateLimitHandler(database *db.DB, limiter *ratelimit.Limiter) *RateLimitHandler {
+	return &RateLimitHandler{db: database, limiter: limiter}
+}
+
+// ListRateLimits godoc: GET /admin/api/rate-limits
+func (h *RateLimitHandler) ListRateLimits(c *gin.Context) {
+	limits, err := h.db.ListRateLimits()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rate_limits": limits})
+}
+
+// SetRateLimit godoc: PUT /admin/api/rate-limits
+// Creates or replaces the override for a user or API key.
+func (h *RateLimitHandler) SetRateLimit(c *gin.Context) {
+	var req struct {
+		Scope                 string `json:"scope" binding:"required"`
+		SubjectID             int64  `json:"subject_id" binding:"required"`
+		RequestsPerMinute     int64  `json:"requests_per_minute"`
+		InputTokensPerMinute  int64  `json:"input_tokens_per_minute"`
+		OutputTokensPerMinute int64  `json:"output_tokens_per_minute"`
+		MaxConcurrent         int64  `json:"max_concurrent"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Scope != ratelimit.ScopeUser && req.Scope != ratelimit.ScopeKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be user or key"})
+		return
+	}
+	if req.RequestsPerMinute < 0 || req.InputTokensPerMinute < 0 || req.OutputTokensPerMinute < 0 || req.MaxConcurrent < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limits must not be negative"})
+		return
+	}
+	r := &model.RateLimit{
+		Scope:                 req.Scope,
+		SubjectID:             req.SubjectID,
+		RequestsPerMinute:     req.RequestsPerMinute,
+		InputTokensPerMinute:  req.InputTokensPerMinute,
+		OutputTokensPerMinute: req.OutputTokensPerMinute,
+		MaxConcurrent:         req.MaxConcurrent,
+	}
+	if err := h.db.UpsertRateLimit(r); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusOK, r)
+}
+
+// DeleteRateLimit godoc: DELETE /admin/api/rate-limits/:id
+func (h *RateLimitHandler) DeleteRateLimit(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := h.db.DeleteRateLimit(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.reload()
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// reload refreshes the limiter's overrides from the database.
+func (h *RateLimitHandler) reload() {
+	limits, err := h.db.ListRateLimits()
+	if err != nil {
+		logger.Errorf("reload rate limits: %v", err)
+		return
+	}
+	h.limiter.SetOverrides(limits)
+}
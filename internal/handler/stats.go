@@ -8,19 +8,32 @@ import (
 
 	"github.com/wjzhangq/claude-gateway/internal/db"
 	"github.com/wjzhangq/claude-gateway/internal/middleware"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/quota"
+	"github.com/wjzhangq/claude-gateway/internal/stats"
 )
 
 // StatsHandler serves usage statistics endpoints.
 type StatsHandler struct {
-	db *db.DB
+	db        *db.DB
+	quota     *quota.Tracker
+	collector *stats.Collector
+	lb        *proxy.LoadBalancer
 }
 
-func NewStatsHandler(database *db.DB) *StatsHandler {
-	return &StatsHandler{db: database}
+func NewStatsHandler(database *db.DB, tracker *quota.Tracker, collector *stats.Collector, lb *proxy.LoadBalancer) *StatsHandler {
+	return &StatsHandler{db: database, quota: tracker, collector: collector, lb: lb}
+}
+
+// GetCollectorStats godoc: GET /admin/api/usage/collector
+// Returns the usage log writer's queue and write counters.
+func (h *StatsHandler) GetCollectorStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.collector.Stats())
 }
 
 // GetUsage godoc: GET /admin/api/usage
-// Query params: user_id, start_date (YYYY-MM-DD), end_date, model, page, page_size
+// Query params: user_id, start_date (YYYY-MM-DD), end_date, model, request_id
+// (the gateway's X-Request-Id or the backend's request-id), page, page_size
 func (h *StatsHandler) GetUsage(c *gin.Context) {
 	userID, _ := strconv.ParseInt(c.Query("user_id"), 10, 64)
 	start := c.Query("start_date")
@@ -29,7 +42,7 @@ func (h *StatsHandler) GetUsage(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
 
-	logs, total, err := h.db.ListUsageLogs(userID, start, end, model, page, pageSize)
+	logs, total, err := h.db.ListUsageLogs(userID, start, end, model, c.Query("request_id"), page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -58,6 +71,8 @@ func (h *StatsHandler) GetDailyStats(c *gin.Context) {
 }
 
 // GetBackendStats godoc: GET /admin/api/backends/stats
+// Returns historical usage per backend plus the load balancer's live view:
+// the active strategy and each backend's in-flight count, latency and score.
 func (h *StatsHandler) GetBackendStats(c *gin.Context) {
 	start := c.Query("start_date")
 	end := c.Query("end_date")
@@ -67,7 +82,8 @@ func (h *StatsHandler) GetBackendStats(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"stats": stats})
+	strategy, live := h.lb.Loads()
+	c.JSON(http.StatusOK, gin.H{"stats": stats, "strategy": strategy, "live": live})
 }
 
 // GetMyUsage godoc: GET /api/usage  (user's own stats, session or API key auth)
@@ -79,16 +95,22 @@ func (h *StatsHandler) GetMyUsage(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
 
-	logs, total, err := h.db.ListUsageLogs(userID, start, end, model, page, pageSize)
+	logs, total, err := h.db.ListUsageLogs(userID, start, end, model, c.Query("request_id"), page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	user, err := h.db.GetUserByID(userID)
+	if err != nil || user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"total":     total,
 		"page":      page,
 		"page_size": pageSize,
 		"logs":      logs,
+		"quota":     h.quota.Status(userID, user.QuotaTokens),
 	})
 }
 
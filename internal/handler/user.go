@@ -6,17 +6,27 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/wjzhangq/claude-gateway/internal/auth"
 	"github.com/wjzhangq/claude-gateway/internal/db"
 	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/quota"
 )
 
 // UserHandler manages user CRUD (admin only).
 type UserHandler struct {
-	db *db.DB
+	db       *db.DB
+	keyStore *auth.KeyStore
+	quota    *quota.Tracker
 }
 
-func NewUserHandler(database *db.DB) *UserHandler {
-	return &UserHandler{db: database}
+func NewUserHandler(database *db.DB, ks *auth.KeyStore, tracker *quota.Tracker) *UserHandler {
+	return &UserHandler{db: database, keyStore: ks, quota: tracker}
+}
+
+// userWithQuota adds the current quota position to a user listing row.
+type userWithQuota struct {
+	*db.UserWithStats
+	Quota quota.Status `json:"quota"`
 }
 
 // ListUsers godoc: GET /admin/api/users
@@ -26,7 +36,11 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	result := make([]userWithQuota, len(users))
+	for i, u := range users {
+		result[i] = userWithQuota{UserWithStats: u, Quota: h.quota.Status(u.ID, u.QuotaTokens)}
+	}
+	c.JSON(http.StatusOK, gin.H{"users": result})
 }
 
 // GetUser godoc: GET /admin/api/users/:id
@@ -111,5 +125,74 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// Status and quota are cached on each KeyInfo; refresh them.
+	reloadKeyStore(h.db, h.keyStore)
 	c.JSON(http.StatusOK, user)
 }
+
+// ListUserModels godoc: GET /admin/api/users/:id/models
+func (h *UserHandler) ListUserModels(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	models, err := h.db.ListUserModels(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+// GrantUserModel godoc: POST /admin/api/users/:id/models
+func (h *UserHandler) GrantUserModel(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req struct {
+		Model string `json:"model" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.GrantUserModel(id, req.Model, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	models, err := userModelNames(h.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.keyStore.SetUserModels(id, models)
+	c.JSON(http.StatusCreated, gin.H{"model": req.Model})
+}
+
+// RevokeUserModel godoc: DELETE /admin/api/users/:id/models?model=...
+func (h *UserHandler) RevokeUserModel(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	modelName := c.Query("model")
+	if modelName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+	if err := h.db.RevokeUserModel(id, modelName); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	models, err := userModelNames(h.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.keyStore.SetUserModels(id, models)
+	c.JSON(http.StatusOK, gin.H{"message": "revoked"})
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/stats"
+)
+
+var (
+	// LLM requests routinely run for minutes, so buckets reach well past the usual HTTP defaults.
+	requestBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	ttftBuckets    = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}
+	queryBuckets   = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+// Gateway holds the gateway's metrics.
+type Gateway struct {
+	registry         *Registry
+	httpRequests     *CounterVec
+	httpDuration     *HistogramVec
+	upstreamRequests *CounterVec
+	upstreamDuration *HistogramVec
+	ttft             *HistogramVec
+	tokens           *CounterVec
+	dbQueries        *HistogramVec
+}
+
+// NewGateway registers the gateway's metrics. Values owned by other components
+// (backend health, key count, usage queue, connection pool) are read at scrape time.
+func NewGateway(database *db.DB, lb *proxy.LoadBalancer, keys *auth.KeyStore, collector *stats.Collector) *Gateway {
+	r := NewRegistry()
+	g := &Gateway{
+		registry: r,
+		httpRequests: r.Counter("gateway_http_requests_total",
+			"HTTP requests handled, by route, method and status.", "route", "method", "status"),
+		httpDuration: r.Histogram("gateway_http_request_duration_seconds",
+			"HTTP request duration, by route and method.", requestBuckets, "route", "method"),
+		upstreamRequests: r.Counter("gateway_upstream_requests_total",
+			"Proxied /v1 requests, by model, backend and upstream status.", "model", "backend", "status"),
+		upstreamDuration: r.Histogram("gateway_upstream_request_duration_seconds",
+			"Proxied /v1 request duration, by model and backend.", requestBuckets, "model", "backend"),
+		ttft: r.Histogram("gateway_stream_time_to_first_token_seconds",
+			"Time until the first bytes of a streamed response arrived, by model and backend.", ttftBuckets, "model", "backend"),
+		tokens: r.Counter("gateway_tokens_total",
+			"Tokens consumed, by model and type (input, output, cache_creation, cache_read).", "model", "type"),
+		dbQueries: r.Histogram("gateway_db_query_duration_seconds",
+			"SQLite statement duration, by statement type.", queryBuckets, "op"),
+	}
+
+	r.GaugeFunc("gateway_backend_up", "Whether the backend's circuit breaker is closed (1) or open or half-open (0).", func() []Sample {
+		backends := lb.Backends()
+		samples := make([]Sample, len(backends))
+		for i, b := range backends {
+			up := 0.0
+			if b.Healthy() {
+				up = 1
+			}
+			samples[i] = Sample{up, []string{b.Name}}
+		}
+		return samples
+	}, "backend")
+	r.CounterFunc("gateway_backend_errors_total", "Upstream errors recorded against the backend.", func() []Sample {
+		backends := lb.Backends()
+		samples := make([]Sample, len(backends))
+		for i, b := range backends {
+			samples[i] = Sample{float64(b.Errors()), []string{b.Name}}
+		}
+		return samples
+	}, "backend")
+
+	r.GaugeFunc("gateway_api_keys", "Active API keys loaded in memory.", func() []Sample {
+		return []Sample{{Value: float64(keys.Len())}}
+	})
+
+	r.GaugeFunc("gateway_usage_queue_depth", "Usage records waiting to be written.", func() []Sample {
+		return []Sample{{Value: float64(collector.Stats().Queued)}}
+	})
+	r.CounterFunc("gateway_usage_records_total", "Usage records by outcome (written, spilled, dropped).", func() []Sample {
+		s := collector.Stats()
+		return []Sample{
+			{float64(s.Written), []string{"written"}},
+			{float64(s.Spilled), []string{"spilled"}},
+			{float64(s.Dropped), []string{"dropped"}},
+		}
+	}, "outcome")
+	r.CounterFunc("gateway_usage_batch_seconds_total", "Time spent writing usage batches.", func() []Sample {
+		return []Sample{{Value: collector.Stats().TotalBatchLatency / 1000}}
+	})
+	r.CounterFunc("gateway_usage_batches_total", "Usage batches written.", func() []Sample {
+		return []Sample{{Value: float64(collector.Stats().Batches)}}
+	})
+
+	// The database has a single connection, so waiting for it is the main source of contention.
+	r.CounterFunc("gateway_db_wait_total", "Queries that waited for the database connection.", func() []Sample {
+		return []Sample{{Value: float64(database.Stats().WaitCount)}}
+	})
+	r.CounterFunc("gateway_db_wait_seconds_total", "Time spent waiting for the database connection.", func() []Sample {
+		return []Sample{{Value: database.Stats().WaitDuration.Seconds()}}
+	})
+
+	return g
+}
+
+// Middleware records the count and duration of every HTTP request.
+func (g *Gateway) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		g.httpRequests.Add(1, route, c.Request.Method, strconv.Itoa(c.Writer.Status()))
+		g.httpDuration.Observe(time.Since(start).Seconds(), route, c.Request.Method)
+	}
+}
+
+// ObserveUsage records a proxied request. It is registered with Collector.Subscribe.
+func (g *Gateway) ObserveUsage(r stats.Record) {
+	g.upstreamRequests.Add(1, r.Model, r.Backend, strconv.Itoa(r.StatusCode))
+	g.upstreamDuration.Observe(r.Latency.Seconds(), r.Model, r.Backend)
+	if r.TimeToFirstByte > 0 {
+		g.ttft.Observe(r.TimeToFirstByte.Seconds(), r.Model, r.Backend)
+	}
+	for typ, n := range map[string]int{
+		"input":          r.InputTokens,
+		"output":         r.OutputTokens,
+		"cache_creation": r.CacheCreationTokens,
+		"cache_read":     r.CacheReadTokens,
+	} {
+		if n > 0 {
+			g.tokens.Add(float64(n), r.Model, typ)
+		}
+	}
+}
+
+// ObserveQuery records a database statement. It is registered with DB.SetQueryObserver.
+func (g *Gateway) ObserveQuery(op string, d time.Duration) {
+	g.dbQueries.Observe(d.Seconds(), op)
+}
+
+// Handler serves the metrics. If token is set, requests must carry it as a bearer token.
+func (g *Gateway) Handler(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" {
+			got := c.GetHeader("Authorization")
+			if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid metrics token"})
+				return
+			}
+		}
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := g.registry.Write(c.Writer); err != nil {
+			logger.Warnf("write metrics: %v", err)
+		}
+	}
+}
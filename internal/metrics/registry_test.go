@@ -0,0 +1,150 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/metrics"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/stats"
+)
+
+// parseExposition parses text with the Prometheus text format parser, failing
+// the test on any syntax error.
+func parseExposition(t *testing.T, text string) map[string]*dto.MetricFamily {
+	t.Helper()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("output is not valid Prometheus text format: %v\n%s", err, text)
+	}
+	return families
+}
+
+func TestRegistry_TextFormat(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.Counter("test_requests_total", "Requests.", "route", "status")
+	latency := r.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	r.GaugeFunc("test_up", "Up.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: 1}}
+	})
+
+	requests.Add(1, "/v1/*path", "200")
+	requests.Add(2, "/v1/*path", "200")
+	requests.Add(1, `we"ird`, "500")
+	latency.Observe(0.05, "/v1/*path")
+	latency.Observe(0.5, "/v1/*path")
+	latency.Observe(5, "/v1/*path")
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/v1/*path",status="200"} 3
+test_requests_total{route="we\"ird",status="500"} 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/v1/*path",le="0.1"} 1
+test_latency_seconds_bucket{route="/v1/*path",le="1"} 2
+test_latency_seconds_bucket{route="/v1/*path",le="+Inf"} 3
+test_latency_seconds_sum{route="/v1/*path"} 5.55
+test_latency_seconds_count{route="/v1/*path"} 3
+# HELP test_up Up.
+# TYPE test_up gauge
+test_up 1
+`
+	if b.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistry_OutputParses(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.Counter("test_requests_total", "Requests with \\ and\nnewlines.", "route")
+	latency := r.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	requests.Add(2, "back\\slash \"quoted\"\nnewline")
+	latency.Observe(0.5, "/v1/*path")
+	latency.Observe(5, "/v1/*path")
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	families := parseExposition(t, b.String())
+
+	counter := families["test_requests_total"]
+	if counter.GetType() != dto.MetricType_COUNTER || len(counter.Metric) != 1 {
+		t.Fatalf("unexpected counter family: %v", counter)
+	}
+	if got := counter.GetHelp(); got != "Requests with \\ and\nnewlines." {
+		t.Errorf("expected the help text to round-trip, got %q", got)
+	}
+	if got := counter.Metric[0].Label[0].GetValue(); got != "back\\slash \"quoted\"\nnewline" {
+		t.Errorf("expected the label value to round-trip, got %q", got)
+	}
+	if got := counter.Metric[0].Counter.GetValue(); got != 2 {
+		t.Errorf("expected counter 2, got %v", got)
+	}
+
+	hist := families["test_latency_seconds"].Metric[0].Histogram
+	if hist.GetSampleCount() != 2 || hist.GetSampleSum() != 5.5 {
+		t.Errorf("unexpected histogram count %d, sum %v", hist.GetSampleCount(), hist.GetSampleSum())
+	}
+	if b := hist.Bucket; len(b) != 3 || b[0].GetCumulativeCount() != 0 || b[1].GetCumulativeCount() != 1 || b[2].GetCumulativeCount() != 2 {
+		t.Errorf("unexpected buckets %v", b)
+	}
+}
+
+func TestGateway_OutputParses(t *testing.T) {
+	database, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	collector := stats.NewCollector(database, config.UsageLogConfig{BufferSize: 16, BatchSize: 1, FlushInterval: 10 * time.Millisecond})
+	defer collector.Close(context.Background())
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "primary", URL: "http://127.0.0.1:1", APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{})
+
+	g := metrics.NewGateway(database, lb, auth.NewKeyStore("secret"), collector)
+	database.SetQueryObserver(g.ObserveQuery)
+	g.ObserveUsage(stats.Record{Model: "claude-sonnet-4", Backend: "primary", StatusCode: 200,
+		InputTokens: 10, OutputTokens: 5, Latency: time.Second, TimeToFirstByte: time.Second / 2})
+	if _, err := database.ListBackends(); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(g.Middleware())
+	r.GET("/metrics", g.Handler(""))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	families := parseExposition(t, w.Body.String())
+	for _, name := range []string{
+		"gateway_http_requests_total", "gateway_upstream_request_duration_seconds",
+		"gateway_stream_time_to_first_token_seconds", "gateway_tokens_total",
+		"gateway_db_query_duration_seconds", "gateway_backend_up", "gateway_usage_records_total",
+	} {
+		if families[name] == nil {
+			t.Errorf("expected %s in the output", name)
+		}
+	}
+}
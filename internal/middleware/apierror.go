@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IsOpenAIRequest reports whether the request targets an OpenAI-style endpoint,
+// so errors can be returned in the shape the client SDK expects.
+func IsOpenAIRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions")
+}
+
+// AbortWithAPIError aborts a /v1 request with an error body in Anthropic format,
+// or in OpenAI format for OpenAI-style endpoints. errType is the Anthropic error
+// type (e.g. rate_limit_error) and is reused as the OpenAI error code.
+func AbortWithAPIError(c *gin.Context, status int, errType, message string) {
+	if IsOpenAIRequest(c) {
+		c.AbortWithStatusJSON(status, gin.H{
+			"error": gin.H{
+				"message": message,
+				"type":    errType,
+				"code":    errType,
+			},
+		})
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
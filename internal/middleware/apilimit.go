@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/ratelimit"
+)
+
+// APIRateLimit enforces the per-user and per-key limits of /v1 requests and
+// reports them in anthropic-ratelimit-* headers. Rejected requests get 429 with
+// a retry-after header. It must run after AuthMiddleware.
+func APIRateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, _ := c.Get(CtxKeyInfo)
+		info, ok := v.(*auth.KeyInfo)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		d, release := limiter.Acquire(
+			ratelimit.Subject{Scope: ratelimit.ScopeUser, ID: info.UserID},
+			ratelimit.Subject{Scope: ratelimit.ScopeKey, ID: info.KeyID},
+		)
+		setRateLimitHeaders(c, "requests", d.Requests)
+		setRateLimitHeaders(c, "input-tokens", d.InputTokens)
+		setRateLimitHeaders(c, "output-tokens", d.OutputTokens)
+
+		if !d.Allowed {
+			secs := int64(math.Ceil(d.RetryAfter.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			c.Header("retry-after", strconv.FormatInt(secs, 10))
+			AbortWithAPIError(c, http.StatusTooManyRequests, "rate_limit_error", d.Reason)
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+func setRateLimitHeaders(c *gin.Context, name string, w ratelimit.Window) {
+	if w.Limit == 0 {
+		return
+	}
+	prefix := "anthropic-ratelimit-" + name + "-"
+	c.Header(prefix+"limit", strconv.FormatInt(w.Limit, 10))
+	c.Header(prefix+"remaining", strconv.FormatInt(w.Remaining, 10))
+	c.Header(prefix+"reset", w.Reset.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,47 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+	"github.com/wjzhangq/claude-gateway/internal/ratelimit"
+)
+
+func TestAPIRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := ratelimit.NewLimiter(ratelimit.Limits{}, ratelimit.Limits{RequestsPerMinute: 1})
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(middleware.CtxKeyInfo, &auth.KeyInfo{KeyID: 1, UserID: 1})
+	})
+	r.Use(middleware.APIRateLimit(limiter))
+	r.POST("/v1/messages", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("anthropic-ratelimit-requests-limit"); got != "1" {
+		t.Fatalf("expected requests limit header 1, got %q", got)
+	}
+	if got := w.Header().Get("anthropic-ratelimit-requests-remaining"); got != "0" {
+		t.Fatalf("expected requests remaining header 0, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("retry-after"); got != "60" {
+		t.Fatalf("expected retry-after 60, got %q", got)
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/wjzhangq/claude-gateway/internal/auth"
 )
@@ -15,6 +19,9 @@ const (
 	CtxUserRole  = "user_role"
 )
 
+// tracerName names the tracer of spans started in this package.
+const tracerName = "github.com/wjzhangq/claude-gateway/internal/middleware"
+
 // AuthMiddleware validates the Bearer API key from Authorization header.
 // It uses the in-memory KeyStore for O(1) lookup.
 func AuthMiddleware(ks *auth.KeyStore) gin.HandlerFunc {
@@ -32,7 +39,13 @@ func AuthMiddleware(ks *auth.KeyStore) gin.HandlerFunc {
 			raw = strings.TrimPrefix(raw, "bearer ")
 		}
 
+		_, span := otel.Tracer(tracerName).Start(c.Request.Context(), "auth.lookup")
 		info := ks.Get(raw)
+		if info != nil {
+			span.SetAttributes(semconv.EnduserID(info.Itcode), attribute.Int64("gateway.api_key_id", info.KeyID))
+			trace.SpanFromContext(c.Request.Context()).SetAttributes(semconv.EnduserID(info.Itcode))
+		}
+		span.End()
 		if info == nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired api key"})
 			return
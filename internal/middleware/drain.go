@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Drainer coordinates a graceful shutdown: once draining starts, readiness
+// fails and new proxy requests are refused while in-flight ones finish.
+type Drainer struct {
+	draining atomic.Bool
+	inFlight atomic.Int64
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{}
+}
+
+// Start begins draining. It is safe to call more than once.
+func (d *Drainer) Start() {
+	d.draining.Store(true)
+}
+
+// Draining reports whether draining has started.
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// InFlight returns the number of requests admitted by DrainMiddleware that have not finished.
+func (d *Drainer) InFlight() int64 {
+	return d.inFlight.Load()
+}
+
+// Wait blocks until no admitted request is in flight or ctx is done, and
+// reports whether all requests finished.
+func (d *Drainer) Wait(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for d.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// DrainMiddleware counts in-flight requests and rejects new ones with 503 once
+// draining has started, asking the client to retry against another instance.
+func DrainMiddleware(d *Drainer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d.Draining() {
+			c.Header("Connection", "close")
+			c.Header("retry-after", "1")
+			AbortWithAPIError(c, http.StatusServiceUnavailable, "overloaded_error", "gateway is shutting down, please retry")
+			return
+		}
+		d.inFlight.Add(1)
+		defer d.inFlight.Add(-1)
+		c.Next()
+	}
+}
+
+// Healthz answers liveness probes: the process is up and serving HTTP.
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz answers readiness probes and starts failing as soon as draining begins,
+// so load balancers stop sending new traffic.
+func Readyz(d *Drainer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d.Draining() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
@@ -0,0 +1,67 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+)
+
+func TestDrain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	d := middleware.NewDrainer()
+	release := make(chan struct{})
+	r := gin.New()
+	r.GET("/readyz", middleware.Readyz(d))
+	r.POST("/v1/messages", middleware.DrainMiddleware(d), func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected ready before drain, got %d", w.Code)
+	}
+
+	// A request that is in flight when draining starts runs to completion.
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+		done <- w.Code
+	}()
+	for d.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	d.Start()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readiness to fail while draining, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected new request to be refused while draining, got %d", w.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if d.Wait(ctx) {
+		t.Fatal("expected Wait to time out while a request is in flight")
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected in-flight request to finish with 200, got %d", code)
+	}
+	if !d.Wait(context.Background()) {
+		t.Fatal("expected Wait to return once the request finished")
+	}
+}
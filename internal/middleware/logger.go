@@ -12,7 +12,9 @@ import (
 )
 
 // RequestLogger logs each HTTP request with method, path, status, and latency.
-// For proxy endpoints it also logs itcode and backend name.
+// For proxy endpoints it also logs itcode and backend name. Lines carry the
+// request ID (see RequestID) and, for proxy requests, the backend's request ID,
+// so they can be matched with usage_logs rows.
 func RequestLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -27,6 +29,9 @@ func RequestLogger() gin.HandlerFunc {
 			"latency": time.Since(start).Milliseconds(),
 			"ip":      c.ClientIP(),
 		}
+		if id := c.GetString(CtxRequestID); id != "" {
+			fields["request_id"] = id
+		}
 
 		// Distinguish proxy (forward) requests from management API requests
 		isProxy := strings.HasPrefix(path, "/v1/")
@@ -35,6 +40,9 @@ func RequestLogger() gin.HandlerFunc {
 			if backend, ok := c.Get("proxy_backend"); ok {
 				fields["backend"] = backend
 			}
+			if id := c.GetString(CtxUpstreamRequestID); id != "" {
+				fields["upstream_request_id"] = id
+			}
 			if info, ok := c.Get(CtxKeyInfo); ok {
 				if ki, ok := info.(*auth.KeyInfo); ok && ki.Itcode != "" {
 					fields["itcode"] = ki.Itcode
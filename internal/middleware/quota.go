@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/quota"
+)
+
+// QuotaMiddleware rejects /v1 requests from users whose token quota is used up.
+// It must run after AuthMiddleware.
+func QuotaMiddleware(tracker *quota.Tracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, _ := c.Get(CtxKeyInfo)
+		info, ok := v.(*auth.KeyInfo)
+		if !ok || info.QuotaTokens <= 0 {
+			c.Next()
+			return
+		}
+
+		st := tracker.Status(info.UserID, info.QuotaTokens)
+		if st.Exceeded {
+			msg := fmt.Sprintf("token quota exceeded: used %d of %d tokens", st.Used, st.Limit)
+			if st.ResetAt != nil {
+				msg += ", resets at " + st.ResetAt.Format("2006-01-02 15:04:05 MST")
+			}
+			AbortWithAPIError(c, http.StatusTooManyRequests, "rate_limit_error", msg)
+			return
+		}
+		c.Next()
+	}
+}
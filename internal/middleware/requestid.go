@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys for request correlation.
+const (
+	CtxRequestID         = "request_id"
+	CtxUpstreamRequestID = "upstream_request_id" // request-id returned by the backend
+)
+
+// RequestIDHeader carries the gateway's request ID in requests and responses.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds a client-supplied request ID.
+const maxRequestIDLen = 128
+
+// RequestID assigns every request an ID, or keeps the one the client sent in
+// X-Request-Id if it is short and made of safe characters. The ID is stored in
+// the context and returned in the X-Request-Id response header.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(CtxRequestID, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -14,16 +14,19 @@ type User struct {
 	UpdatedAt   time.Time `db:"updated_at"    json:"updated_at"`
 }
 
-// APIKey represents a user's API key.
+// APIKey represents a user's API key. Only the key's HMAC digest is stored;
+// Key holds the plaintext just once, in the response that creates it.
 type APIKey struct {
-	ID         int64      `db:"id"          json:"id"`
-	UserID     int64      `db:"user_id"     json:"user_id"`
-	Key        string     `db:"key"         json:"key"`
-	Name       string     `db:"name"        json:"name"`
-	Status     string     `db:"status"      json:"status"`
-	ExpiresAt  *time.Time `db:"expires_at"  json:"expires_at"`
-	CreatedAt  time.Time  `db:"created_at"  json:"created_at"`
-	UpdatedAt  time.Time  `db:"updated_at"  json:"updated_at"`
+	ID         int64      `db:"id"           json:"id"`
+	UserID     int64      `db:"user_id"      json:"user_id"`
+	Key        string     `db:"-"            json:"key,omitempty"`
+	KeyHash    string     `db:"key"          json:"-"`
+	KeyHint    string     `db:"key_hint"     json:"key_hint"` // e.g. "sk-abcd...wxyz"
+	Name       string     `db:"name"         json:"name"`
+	Status     string     `db:"status"       json:"status"`
+	ExpiresAt  *time.Time `db:"expires_at"   json:"expires_at"`
+	CreatedAt  time.Time  `db:"created_at"   json:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at"   json:"updated_at"`
 	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
 	Requests   int64      `db:"-"            json:"requests"`
 	CostUSD    float64    `db:"-"            json:"cost_usd"`
@@ -31,32 +34,43 @@ type APIKey struct {
 
 // UsageLog records a single API call.
 type UsageLog struct {
-	ID           int64     `db:"id"            json:"id"`
-	UserID       int64     `db:"user_id"       json:"user_id"`
-	Itcode       string    `db:"-"             json:"itcode"`
-	APIKeyID     int64     `db:"api_key_id"    json:"api_key_id"`
-	Model        string    `db:"model"         json:"model"`
-	Backend      string    `db:"backend"       json:"backend"`
-	InputTokens  int       `db:"input_tokens"  json:"input_tokens"`
-	OutputTokens int       `db:"output_tokens" json:"output_tokens"`
-	TotalTokens  int       `db:"total_tokens"  json:"total_tokens"`
-	CostUSD      float64   `db:"cost_usd"      json:"cost_usd"`
-	StatusCode   int       `db:"status_code"   json:"status_code"`
-	Latency      int64     `db:"latency_ms"    json:"latency_ms"`
-	CreatedAt    time.Time `db:"created_at"    json:"created_at"`
+	ID                  int64     `db:"id"                    json:"id"`
+	UserID              int64     `db:"user_id"               json:"user_id"`
+	Itcode              string    `db:"-"                     json:"itcode"`
+	APIKeyID            int64     `db:"api_key_id"            json:"api_key_id"`
+	Model               string    `db:"model"                 json:"model"`           // model sent upstream
+	RequestedModel      string    `db:"requested_model"       json:"requested_model"` // model the client asked for, before rewriting
+	Backend             string    `db:"backend"               json:"backend"`
+	InputTokens         int       `db:"input_tokens"          json:"input_tokens"`
+	OutputTokens        int       `db:"output_tokens"         json:"output_tokens"`
+	CacheCreationTokens int       `db:"cache_creation_tokens" json:"cache_creation_tokens"`
+	CacheReadTokens     int       `db:"cache_read_tokens"     json:"cache_read_tokens"`
+	CacheWrite1hTokens  int       `db:"cache_write_1h_tokens" json:"cache_write_1h_tokens"` // part of CacheCreationTokens written to the 1-hour cache
+	TotalTokens         int       `db:"total_tokens"          json:"total_tokens"`
+	CostUSD             float64   `db:"cost_usd"              json:"cost_usd"`
+	StatusCode          int       `db:"status_code"           json:"status_code"`
+	Latency             int64     `db:"latency_ms"            json:"latency_ms"`
+	ClientAborted       bool      `db:"client_aborted"        json:"client_aborted"`
+	RequestID           string    `db:"request_id"            json:"request_id"`
+	UpstreamRequestID   string    `db:"upstream_request_id"   json:"upstream_request_id"` // request-id returned by the backend
+	ClientIP            string    `db:"client_ip"             json:"client_ip"`
+	UserAgent           string    `db:"user_agent"            json:"user_agent"`
+	CreatedAt           time.Time `db:"created_at"            json:"created_at"`
 }
 
 // DailyStats aggregates usage per user per model per day.
 type DailyStats struct {
-	ID           int64   `db:"id"            json:"id"`
-	Date         string  `db:"date"          json:"date"`
-	UserID       int64   `db:"user_id"       json:"user_id"`
-	Model        string  `db:"model"         json:"model"`
-	Requests     int     `db:"requests"      json:"requests"`
-	InputTokens  int64   `db:"input_tokens"  json:"input_tokens"`
-	OutputTokens int64   `db:"output_tokens" json:"output_tokens"`
-	TotalTokens  int64   `db:"total_tokens"  json:"total_tokens"`
-	CostUSD      float64 `db:"cost_usd"      json:"cost_usd"`
+	ID                  int64   `db:"id"                    json:"id"`
+	Date                string  `db:"date"                  json:"date"`
+	UserID              int64   `db:"user_id"               json:"user_id"`
+	Model               string  `db:"model"                 json:"model"`
+	Requests            int     `db:"requests"              json:"requests"`
+	InputTokens         int64   `db:"input_tokens"          json:"input_tokens"`
+	OutputTokens        int64   `db:"output_tokens"         json:"output_tokens"`
+	CacheCreationTokens int64   `db:"cache_creation_tokens" json:"cache_creation_tokens"`
+	CacheReadTokens     int64   `db:"cache_read_tokens"     json:"cache_read_tokens"`
+	TotalTokens         int64   `db:"total_tokens"          json:"total_tokens"`
+	CostUSD             float64 `db:"cost_usd"              json:"cost_usd"`
 }
 
 // Application is a user's request to access a model.
@@ -71,3 +85,59 @@ type Application struct {
 	CreatedAt   time.Time `db:"created_at"  json:"created_at"`
 	UpdatedAt   time.Time `db:"updated_at"  json:"updated_at"`
 }
+
+// UserModel is a model entitlement granted to a user, usually by an approved application.
+type UserModel struct {
+	ID            int64     `db:"id"             json:"id"`
+	UserID        int64     `db:"user_id"        json:"user_id"`
+	Model         string    `db:"model"          json:"model"`
+	ApplicationID *int64    `db:"application_id" json:"application_id"`
+	CreatedAt     time.Time `db:"created_at"     json:"created_at"`
+}
+
+// ModelPrice is the price of a model, in USD per million tokens, from EffectiveFrom on.
+// Model is an exact model name or a glob such as "claude-sonnet-4*".
+type ModelPrice struct {
+	ID                int64     `db:"id"                   json:"id"`
+	Model             string    `db:"model"                json:"model"`
+	InputPrice        float64   `db:"input_price"          json:"input_price"`
+	OutputPrice       float64   `db:"output_price"         json:"output_price"`
+	CacheWritePrice   float64   `db:"cache_write_price"    json:"cache_write_price"`    // 5-minute cache
+	CacheWrite1hPrice float64   `db:"cache_write_1h_price" json:"cache_write_1h_price"` // 1-hour cache
+	CacheReadPrice    float64   `db:"cache_read_price"     json:"cache_read_price"`
+	EffectiveFrom     string    `db:"effective_from"       json:"effective_from"` // YYYY-MM-DD, empty for always
+	CreatedAt         time.Time `db:"created_at"           json:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at"           json:"updated_at"`
+}
+
+// RateLimit overrides the default rate limits of a user or an API key.
+// A zero limit means unlimited.
+type RateLimit struct {
+	ID                    int64     `db:"id"                       json:"id"`
+	Scope                 string    `db:"scope"                    json:"scope"` // user | key
+	SubjectID             int64     `db:"subject_id"               json:"subject_id"`
+	RequestsPerMinute     int64     `db:"requests_per_minute"      json:"requests_per_minute"`
+	InputTokensPerMinute  int64     `db:"input_tokens_per_minute"  json:"input_tokens_per_minute"`
+	OutputTokensPerMinute int64     `db:"output_tokens_per_minute" json:"output_tokens_per_minute"`
+	MaxConcurrent         int64     `db:"max_concurrent"           json:"max_concurrent"`
+	CreatedAt             time.Time `db:"created_at"               json:"created_at"`
+	UpdatedAt             time.Time `db:"updated_at"               json:"updated_at"`
+}
+
+// Backend is an upstream API endpoint managed by administrators.
+// APIKey is write-only: it is never serialized in API responses.
+type Backend struct {
+	ID          int64     `db:"id"         json:"id"`
+	Name        string    `db:"name"       json:"name"`
+	URL         string    `db:"url"        json:"url"`
+	APIKey      string    `db:"api_key"    json:"-"`
+	Weight      int       `db:"weight"     json:"weight"`
+	Enabled     bool      `db:"enabled"    json:"enabled"`
+	Protocol    string    `db:"protocol"   json:"protocol"`         // anthropic | openai
+	Models      []string  `db:"models"     json:"models"`           // allowlist of names or globs; empty serves every model
+	Priority    int       `db:"priority"      json:"priority"`      // tier for the priority strategy; 1 is tried first
+	MaxInFlight int       `db:"max_in_flight" json:"max_in_flight"` // requests above which the backend counts as saturated; 0 = no limit
+	FromConfig  bool      `db:"from_config"   json:"from_config"`   // declared in the config file, so read-only in the admin console
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
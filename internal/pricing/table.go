@@ -0,0 +1,168 @@
+package pricing
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+// DateLayout is the format of ModelPrice.EffectiveFrom.
+const DateLayout = "2006-01-02"
+
+// Table resolves the price of a model at a point in time. It is safe for
+// concurrent use and may be reloaded while requests are being priced.
+type Table struct {
+	mu      sync.RWMutex
+	entries []entry
+	warned  sync.Map // model name -> struct{}, unknown models already logged
+}
+
+type entry struct {
+	price       model.ModelPrice
+	from        time.Time // zero when always in effect
+	specificity int
+}
+
+// NewTable creates an empty Table. Every model costs 0 until prices are loaded.
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Validate checks that p has a usable model pattern, date and prices.
+func Validate(p *model.ModelPrice) error {
+	if p.Model == "" {
+		return fmt.Errorf("model is required")
+	}
+	if _, err := path.Match(p.Model, ""); err != nil {
+		return fmt.Errorf("invalid model pattern %q: %w", p.Model, err)
+	}
+	if _, err := parseDate(p.EffectiveFrom); err != nil {
+		return fmt.Errorf("invalid effective_from %q, expected YYYY-MM-DD", p.EffectiveFrom)
+	}
+	if p.InputPrice < 0 || p.OutputPrice < 0 || p.CacheWritePrice < 0 || p.CacheWrite1hPrice < 0 || p.CacheReadPrice < 0 {
+		return fmt.Errorf("prices must not be negative")
+	}
+	return nil
+}
+
+// Load replaces the table contents.
+func (t *Table) Load(prices []*model.ModelPrice) error {
+	entries := make([]entry, 0, len(prices))
+	for _, p := range prices {
+		if err := Validate(p); err != nil {
+			return fmt.Errorf("price for %q: %w", p.Model, err)
+		}
+		from, _ := parseDate(p.EffectiveFrom)
+		entries = append(entries, entry{price: *p, from: from, specificity: specificity(p.Model)})
+	}
+	t.mu.Lock()
+	t.entries = entries
+	t.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the price in force for modelName at time at. When several
+// patterns match, the most specific one wins: an exact name beats any glob, and
+// a longer literal prefix beats a shorter one. Among entries of that pattern,
+// the latest one effective at or before at applies.
+func (t *Table) Lookup(modelName string, at time.Time) (model.ModelPrice, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var best *entry
+	for i := range t.entries {
+		e := &t.entries[i]
+		if e.from.After(at) || !matches(e.price.Model, modelName) {
+			continue
+		}
+		if best == nil || e.specificity > best.specificity ||
+			e.specificity == best.specificity && e.from.After(best.from) {
+			best = e
+		}
+	}
+	if best == nil {
+		return model.ModelPrice{}, false
+	}
+	return best.price, true
+}
+
+// Cost returns the USD cost of a request to modelName made at time at.
+// cacheWrite1hTokens is the part of cacheWriteTokens written to the 1-hour
+// cache. Unknown models cost 0; a warning is logged the first time each one is
+// seen.
+func (t *Table) Cost(modelName string, at time.Time, inputTokens, outputTokens, cacheWriteTokens, cacheWrite1hTokens, cacheReadTokens int) float64 {
+	if t == nil {
+		return 0
+	}
+	p, ok := t.Lookup(modelName, at)
+	if !ok {
+		if _, seen := t.warned.LoadOrStore(modelName, struct{}{}); !seen {
+			logger.Warnf("no price configured for model %q, recording cost as 0", modelName)
+		}
+		return 0
+	}
+	return (float64(inputTokens)*p.InputPrice +
+		float64(outputTokens)*p.OutputPrice +
+		float64(cacheWriteTokens-cacheWrite1hTokens)*p.CacheWritePrice +
+		float64(cacheWrite1hTokens)*p.CacheWrite1hPrice +
+		float64(cacheReadTokens)*p.CacheReadPrice) / 1_000_000
+}
+
+// CacheWrite1hPrice returns the usual price of writes to the 1-hour prompt
+// cache, twice the input price, for models with prompt caching, i.e. a
+// 5-minute cache write price.
+func CacheWrite1hPrice(input, cacheWrite float64) float64 {
+	if cacheWrite == 0 {
+		return 0
+	}
+	return input * 2
+}
+
+func matches(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// specificity ranks a pattern by how much of it is literal text.
+func specificity(pattern string) int {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return 1 << 30
+	}
+	return len(pattern) - strings.Count(pattern, "*") - strings.Count(pattern, "?")
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(DateLayout, s, time.Local)
+}
+
+// Defaults returns list prices for common models, used to seed an empty price table.
+func Defaults() []*model.ModelPrice {
+	price := func(pattern string, input, output, cacheWrite, cacheRead float64) *model.ModelPrice {
+		return &model.ModelPrice{Model: pattern, InputPrice: input, OutputPrice: output,
+			CacheWritePrice: cacheWrite, CacheWrite1hPrice: CacheWrite1hPrice(input, cacheWrite), CacheReadPrice: cacheRead}
+	}
+	return []*model.ModelPrice{
+		price("claude-opus-4*", 15, 75, 18.75, 1.5),
+		price("claude-opus-4-5*", 5, 25, 6.25, 0.5),
+		price("claude-sonnet-4*", 3, 15, 3.75, 0.3),
+		price("claude-3-7-sonnet*", 3, 15, 3.75, 0.3),
+		price("claude-3-5-sonnet*", 3, 15, 3.75, 0.3),
+		price("claude-haiku-4*", 1, 5, 1.25, 0.1),
+		price("claude-3-5-haiku*", 0.8, 4, 1, 0.08),
+		price("claude-3-haiku*", 0.25, 1.25, 0.3, 0.03),
+		price("claude-3-opus*", 15, 75, 18.75, 1.5),
+		price("gpt-4o*", 2.5, 10, 0, 1.25),
+		price("gpt-4o-mini*", 0.15, 0.6, 0, 0.075),
+	}
+}
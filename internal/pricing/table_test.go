@@ -0,0 +1,82 @@
+package pricing_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/pricing"
+)
+
+func TestTable_MostSpecificPatternWins(t *testing.T) {
+	table := pricing.NewTable()
+	if err := table.Load(pricing.Defaults()); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	now := time.Now()
+
+	cases := map[string]float64{
+		"claude-opus-4-1-20250805":   15,
+		"claude-opus-4-5-20251101":   5,
+		"claude-sonnet-4-5-20250929": 3,
+		"gpt-4o-mini-2024-07-18":     0.15,
+	}
+	for name, want := range cases {
+		p, ok := table.Lookup(name, now)
+		if !ok || p.InputPrice != want {
+			t.Errorf("%s: expected input price %v, got %+v (found=%v)", name, want, p, ok)
+		}
+	}
+	if _, ok := table.Lookup("llama-3-70b", now); ok {
+		t.Error("expected no price for unknown model")
+	}
+	if cost := table.Cost("llama-3-70b", now, 1000, 1000, 0, 0, 0); cost != 0 {
+		t.Errorf("expected unknown model to cost 0, got %v", cost)
+	}
+}
+
+func TestTable_EffectiveFrom(t *testing.T) {
+	table := pricing.NewTable()
+	err := table.Load([]*model.ModelPrice{
+		{Model: "m", InputPrice: 10, OutputPrice: 20},
+		{Model: "m", InputPrice: 5, OutputPrice: 10, EffectiveFrom: "2025-06-01"},
+		{Model: "m-exact", InputPrice: 1, EffectiveFrom: "2025-06-01"},
+		{Model: "m*", InputPrice: 2},
+	})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	before := time.Date(2025, 5, 31, 23, 0, 0, 0, time.Local)
+	after := time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local)
+	if p, _ := table.Lookup("m", before); p.InputPrice != 10 {
+		t.Errorf("expected old price before the change, got %v", p.InputPrice)
+	}
+	if p, _ := table.Lookup("m", after); p.InputPrice != 5 {
+		t.Errorf("expected new price after the change, got %v", p.InputPrice)
+	}
+	// The exact entry is not yet in force, so the glob applies.
+	if p, _ := table.Lookup("m-exact", before); p.InputPrice != 2 {
+		t.Errorf("expected glob price before the exact entry takes effect, got %v", p.InputPrice)
+	}
+
+	cost := table.Cost("m", after, 1_000_000, 100_000, 0, 0, 0)
+	if math.Abs(cost-6) > 1e-9 {
+		t.Errorf("expected cost 6, got %v", cost)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []*model.ModelPrice{
+		{Model: ""},
+		{Model: "claude-[", InputPrice: 1},
+		{Model: "m", EffectiveFrom: "June 1"},
+		{Model: "m", InputPrice: -1},
+	}
+	for _, p := range bad {
+		if err := pricing.Validate(p); err == nil {
+			t.Errorf("expected %+v to be rejected", p)
+		}
+	}
+}
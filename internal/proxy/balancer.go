@@ -1,9 +1,11 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"log"
-	"math/rand"
+	"fmt"
+	"path"
 	"strings"
 	"net/http"
 	"sync"
@@ -11,169 +13,478 @@ import (
 	"time"
 
 	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
 )
 
 // Backend represents a single upstream API endpoint with its HTTP client.
 type Backend struct {
-	Name            string
-	URL             string
-	APIKey          string
-	Weight          int
-	client          *http.Client
-	errCount        atomic.Int64
-	lastErr         atomic.Int64 // unix timestamp of last error
-	disabled        atomic.Bool
-	validationFailed atomic.Bool  // set on startup validation failure; never auto-recovered
+	Name        string
+	URL         string
+	APIKey      string
+	Weight      int
+	Protocol    string   // anthropic | openai
+	Models      []string // model allowlist (names or globs); empty serves every model
+	Priority    int      // tier for the priority strategy; 1 is tried first
+	MaxInFlight int      // concurrent requests above which the backend is saturated; 0 = no limit
+	cfg         config.BackendAPI
+	client      *http.Client
+	breaker     *breaker
+	errTotal    atomic.Int64 // failures since the backend was added
+	inFlight    atomic.Int64
+	latencyMu   sync.Mutex
+	latency     latency // time to response headers
+	modelsMu    sync.RWMutex
+	discovered  map[string]ModelInfo // models listed by GET /v1/models, by ID; nil until discovered
 }
 
 // Client returns the backend's dedicated HTTP client.
 func (b *Backend) Client() *http.Client { return b.client }
 
-// RecordError increments the error counter and disables the backend after 5 consecutive errors.
-func (b *Backend) RecordError() {
-	b.errCount.Add(1)
-	b.lastErr.Store(time.Now().Unix())
-	if b.errCount.Load() >= 5 {
-		b.disabled.Store(true)
+// Serves reports whether the backend can serve model. A configured allowlist
+// takes precedence; otherwise the model must be in the discovered model set,
+// and a backend whose models have not been discovered serves every model.
+// An empty model matches every backend.
+func (b *Backend) Serves(model string) bool {
+	if model == "" {
+		return true
 	}
+	if len(b.Models) > 0 {
+		for _, p := range b.Models {
+			if p == model {
+				return true
+			}
+			if ok, _ := path.Match(p, model); ok {
+				return true
+			}
+		}
+		return false
+	}
+	b.modelsMu.RLock()
+	defer b.modelsMu.RUnlock()
+	if b.discovered == nil {
+		return true
+	}
+	if _, ok := b.discovered[model]; ok {
+		return true
+	}
+	for id := range b.discovered {
+		if isSnapshotOf(id, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordError counts a failed request against the backend's circuit breaker.
+// Requests admitted by Pick report through their Lease instead.
+func (b *Backend) RecordError() {
+	b.recordOutcome(outcomeFailure, "request failed", 0)
 }
 
-// RecordSuccess resets the error counter and re-enables the backend.
+// RecordSuccess counts a successful request for the backend's circuit breaker.
+// Requests admitted by Pick report through their Lease instead.
 func (b *Backend) RecordSuccess() {
-	b.errCount.Store(0)
-	b.disabled.Store(false)
+	b.recordOutcome(outcomeSuccess, "", 0)
+}
+
+func (b *Backend) recordOutcome(o outcome, reason string, t ticket) {
+	if o == outcomeFailure {
+		b.errTotal.Add(1)
+	}
+	b.breaker.record(o, reason, t)
+}
+
+// Lease is a backend admitted for one request by its circuit breaker. The
+// request's outcome must be reported once, with RecordError, RecordSuccess
+// or release, so that a half-open backend can admit its next trial.
+type Lease struct {
+	*Backend
+	ticket ticket
+}
+
+// RecordError counts the failed request against the backend's circuit breaker.
+func (l *Lease) RecordError() {
+	l.recordOutcome(outcomeFailure, "request failed")
+}
+
+// RecordSuccess counts the successful request for the backend's circuit breaker.
+func (l *Lease) RecordSuccess() {
+	l.recordOutcome(outcomeSuccess, "")
+}
+
+func (l *Lease) recordOutcome(o outcome, reason string) {
+	l.Backend.recordOutcome(o, reason, l.ticket)
+}
+
+// release gives back the request slot without an outcome, e.g. when the
+// request could not be sent at all.
+func (l *Lease) release() {
+	l.Backend.recordOutcome(outcomeIgnored, "", l.ticket)
+}
+
+// Healthy reports whether the backend's circuit is closed.
+func (b *Backend) Healthy() bool {
+	return b.breaker.snapshot().State == StateClosed
+}
+
+// Errors returns the number of failures recorded since the backend was added.
+func (b *Backend) Errors() int64 { return b.errTotal.Load() }
+
+// InFlight returns the number of requests currently running on the backend,
+// including responses still being streamed.
+func (b *Backend) InFlight() int64 { return b.inFlight.Load() }
+
+// LatencyEWMA returns the backend's peak-EWMA time to response headers.
+func (b *Backend) LatencyEWMA() time.Duration {
+	b.latencyMu.Lock()
+	defer b.latencyMu.Unlock()
+	return b.latency.value
+}
+
+func (b *Backend) observeLatency(d time.Duration) {
+	b.latencyMu.Lock()
+	b.latency.observe(d, time.Now())
+	b.latencyMu.Unlock()
 }
 
-// LoadBalancer selects backends using weighted random selection with health tracking.
+// saturated reports whether the backend is running as many requests as it may.
+func (b *Backend) saturated() bool {
+	return b.MaxInFlight > 0 && b.inFlight.Load() >= int64(b.MaxInFlight)
+}
+
+// Health returns the state of the backend's circuit breaker.
+func (b *Backend) Health() BackendHealth {
+	h := b.breaker.snapshot()
+	h.Errors = b.errTotal.Load()
+	return h
+}
+
+// LoadBalancer selects backends with a configurable strategy, skipping
+// backends whose circuit breaker is open.
 type LoadBalancer struct {
-	mu       sync.RWMutex
-	backends []*Backend
+	mu           sync.RWMutex
+	backends     []*Backend
+	health       config.HealthCheckConfig
+	strategy     strategy
+	strategyName string
+}
+
+// NewLoadBalancer builds backends from config and, if health.ProbeInterval is
+// set, starts probing them in the background. If health.ModelRefreshInterval
+// is set, each backend's model list is discovered and refreshed periodically.
+func NewLoadBalancer(cfgs []config.BackendAPI, health config.HealthCheckConfig) *LoadBalancer {
+	lb := &LoadBalancer{health: health, strategy: weightedRandom{}, strategyName: StrategyWeightedRandom}
+	lb.Replace(cfgs)
+	if health.ProbeInterval > 0 {
+		go lb.probeLoop()
+	}
+	if health.ModelRefreshInterval > 0 {
+		go lb.modelLoop()
+	}
+	return lb
 }
 
-// NewLoadBalancer builds backends from config and starts the recovery ticker.
-func NewLoadBalancer(cfgs []config.BackendAPI) *LoadBalancer {
-	lb := &LoadBalancer{}
+// Replace atomically swaps in a new backend set. Backends whose configuration
+// is unchanged keep their Backend object, health state and connection pool;
+// requests already running on a replaced or removed backend finish on the old
+// object, whose idle connections are then released.
+func (lb *LoadBalancer) Replace(cfgs []config.BackendAPI) {
+	lb.mu.Lock()
+	current := make(map[string]*Backend, len(lb.backends))
+	for _, b := range lb.backends {
+		current[b.Name] = b
+	}
+	var next, undiscovered []*Backend
+	kept := make(map[*Backend]bool)
 	for _, c := range cfgs {
 		if !c.Enabled {
 			continue
 		}
-		transport := &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 20,
-			IdleConnTimeout:     90 * time.Second,
-		}
-		lb.backends = append(lb.backends, &Backend{
-			Name:   c.Name,
-			URL:    c.URL,
-			APIKey: c.APIKey,
-			Weight: c.Weight,
-			client: &http.Client{
-				Transport: transport,
-				Timeout:   300 * time.Second, // long for streaming
-			},
-		})
-	}
-	go lb.recoveryLoop()
-	return lb
+		if c.Protocol == "" {
+			c.Protocol = ProtocolAnthropic
+		}
+		prev, ok := current[c.Name]
+		if ok && sameBackendConfig(prev.cfg, c) {
+			delete(current, c.Name)
+			next = append(next, prev)
+			kept[prev] = true
+			continue
+		}
+		b := newBackend(c, lb.health)
+		if ok && prev.URL == c.URL {
+			// Same endpoint, e.g. a rotated key: the model list still applies.
+			b.setModels(prev.models())
+		} else {
+			undiscovered = append(undiscovered, b)
+		}
+		next = append(next, b)
+	}
+	old := lb.backends
+	lb.backends = next
+	lb.mu.Unlock()
+
+	for _, b := range old {
+		if !kept[b] {
+			b.client.CloseIdleConnections()
+		}
+	}
+	if lb.health.ModelRefreshInterval > 0 {
+		for _, b := range undiscovered {
+			go lb.refreshModels(b)
+		}
+	}
+}
+
+func newBackend(c config.BackendAPI, health config.HealthCheckConfig) *Backend {
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 20,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Backend{
+		Name:        c.Name,
+		URL:         c.URL,
+		APIKey:      c.APIKey,
+		Weight:      c.Weight,
+		Protocol:    c.Protocol,
+		Models:      c.Models,
+		Priority:    max(c.Priority, 1),
+		MaxInFlight: c.MaxInFlight,
+		cfg:         c,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   300 * time.Second, // long for streaming
+		},
+		breaker: newBreaker(c.Name, health),
+	}
 }
 
-// Pick selects a healthy backend using weighted random selection.
-// Returns nil if no healthy backend is available.
-func (lb *LoadBalancer) Pick() *Backend {
+func sameBackendConfig(a, b config.BackendAPI) bool {
+	if a.Name != b.Name || a.URL != b.URL || a.APIKey != b.APIKey || a.Weight != b.Weight ||
+		a.Enabled != b.Enabled || a.Protocol != b.Protocol || len(a.Models) != len(b.Models) ||
+		a.Priority != b.Priority || a.MaxInFlight != b.MaxInFlight {
+		return false
+	}
+	for i := range a.Models {
+		if a.Models[i] != b.Models[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Backends returns the current backend set.
+func (lb *LoadBalancer) Backends() []*Backend {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
+	backends := make([]*Backend, len(lb.backends))
+	copy(backends, lb.backends)
+	return backends
+}
+
+// SetStrategy switches the load balancing strategy for new requests.
+func (lb *LoadBalancer) SetStrategy(name string) error {
+	s, err := newStrategy(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = StrategyWeightedRandom
+	}
+	lb.mu.Lock()
+	lb.strategy, lb.strategyName = s, name
+	lb.mu.Unlock()
+	return nil
+}
+
+// Pick selects an available backend using the configured strategy.
+// Returns nil if no backend is available.
+func (lb *LoadBalancer) Pick() *Lease {
+	return lb.PickExcluding("", nil)
+}
 
-	var pool []*Backend
-	totalWeight := 0
+// PickExcluding is like Pick but only considers backends serving model and
+// never returns a backend in exclude. exclude is used to choose a different
+// backend when retrying a failed request. Saturated backends are only chosen
+// when every candidate is saturated.
+//
+// The returned backend's circuit breaker has admitted the request; the caller
+// must report its outcome through the Lease.
+func (lb *LoadBalancer) PickExcluding(model string, exclude map[*Backend]bool) *Lease {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	var pool, unsaturated []*Backend
 	for _, b := range lb.backends {
-		if !b.disabled.Load() && !b.validationFailed.Load() {
-			pool = append(pool, b)
-			totalWeight += b.Weight
+		if exclude[b] || !b.Serves(model) || !b.breaker.available() {
+			continue
+		}
+		pool = append(pool, b)
+		if !b.saturated() {
+			unsaturated = append(unsaturated, b)
 		}
 	}
-	if len(pool) == 0 {
-		return nil
+	if len(unsaturated) > 0 {
+		pool = unsaturated
 	}
 
-	r := rand.Intn(totalWeight)
-	for _, b := range pool {
-		r -= b.Weight
-		if r < 0 {
-			return b
+	// A half-open backend admits one trial at a time, so the chosen backend may
+	// refuse; fall back to the others.
+	for len(pool) > 0 {
+		b := lb.strategy.choose(pool)
+		if t, ok := b.breaker.acquire(); ok {
+			return &Lease{Backend: b, ticket: t}
+		}
+		for i := range pool {
+			if pool[i] == b {
+				pool = append(pool[:i:i], pool[i+1:]...)
+				break
+			}
 		}
 	}
-	return pool[len(pool)-1]
+	return nil
 }
 
-// recoveryLoop re-enables backends that have been quiet for 30 seconds.
-func (lb *LoadBalancer) recoveryLoop() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		now := time.Now().Unix()
-		lb.mu.RLock()
-		for _, b := range lb.backends {
-			if b.disabled.Load() && !b.validationFailed.Load() && now-b.lastErr.Load() > 30 {
-				b.RecordSuccess()
-			}
+// HasModel reports whether any backend serves model, whatever its health.
+func (lb *LoadBalancer) HasModel(model string) bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for _, b := range lb.backends {
+		if b.Serves(model) {
+			return true
 		}
-		lb.mu.RUnlock()
 	}
+	return false
 }
 
-// ValidateBackends calls GET /v1/models on each backend and logs the result.
-// Backends that fail validation (non-200, missing/empty data array) are disabled (weight=0).
-func (lb *LoadBalancer) ValidateBackends() {
-	lb.mu.RLock()
-	backends := make([]*Backend, len(lb.backends))
-	copy(backends, lb.backends)
-	lb.mu.RUnlock()
+// BackendLoad is a backend's live load as seen by the load balancer.
+type BackendLoad struct {
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	InFlight    int64   `json:"in_flight"`
+	MaxInFlight int     `json:"max_in_flight"`
+	LatencyMs   float64 `json:"latency_ewma_ms"`
+	Weight      int     `json:"weight"`
+	Priority    int     `json:"priority"`
+	Score       float64 `json:"score"` // lower is preferred; 0 for weighted_random
+}
 
-	for _, b := range backends {
-		if ok := validateBackend(b); !ok {
-			b.validationFailed.Store(true)
-			log.Printf("[backend:%s] validate: permanently disabled due to validation failure", b.Name)
+// Loads returns the current strategy and each backend's load and score.
+func (lb *LoadBalancer) Loads() (string, []BackendLoad) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	loads := make([]BackendLoad, len(lb.backends))
+	for i, b := range lb.backends {
+		loads[i] = BackendLoad{
+			Name:        b.Name,
+			State:       b.breaker.snapshot().State,
+			InFlight:    b.InFlight(),
+			MaxInFlight: b.MaxInFlight,
+			LatencyMs:   float64(b.LatencyEWMA()) / float64(time.Millisecond),
+			Weight:      b.Weight,
+			Priority:    b.Priority,
+			Score:       lb.strategy.score(b),
 		}
 	}
+	return lb.strategyName, loads
 }
 
-// validateBackend returns true if the backend passes the /v1/models health check.
-func validateBackend(b *Backend) bool {
-	url := strings.TrimRight(b.URL, "/") + "/v1/models"
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		log.Printf("[backend:%s] validate: build request error: %v", b.Name, err)
-		return false
+// probeLoop checks every backend each probe interval.
+func (lb *LoadBalancer) probeLoop() {
+	ticker := time.NewTicker(lb.health.ProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		lb.ProbeBackends()
 	}
-	req.Header.Set("Authorization", "Bearer "+b.APIKey)
-	req.Header.Set("x-api-key", b.APIKey)
+}
 
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("[backend:%s] validate: request error: %v", b.Name, err)
-		return false
+// ProbeBackends runs an active health probe against every backend in parallel
+// and feeds the results to their circuit breakers. A backend that failed its
+// startup probe comes back once a later probe succeeds.
+func (lb *LoadBalancer) ProbeBackends() {
+	var wg sync.WaitGroup
+	for _, b := range lb.Backends() {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			err := probeBackend(b, lb.health)
+			if err != nil {
+				b.errTotal.Add(1)
+			}
+			b.breaker.probed(err)
+		}(b)
 	}
-	defer resp.Body.Close()
+	wg.Wait()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("[backend:%s] validate: FAIL — HTTP %d", b.Name, resp.StatusCode)
-		return false
+// ValidateBackends probes every backend once at startup. Backends that fail
+// start with an open circuit and are re-checked by the periodic probes.
+func (lb *LoadBalancer) ValidateBackends() {
+	for _, b := range lb.Backends() {
+		if err := probeBackend(b, lb.health); err != nil {
+			b.breaker.trip("startup validation: " + err.Error())
+			continue
+		}
+		logger.Infof("backend %s validated", b.Name)
 	}
+}
 
-	var result struct {
-		Data []json.RawMessage `json:"data"`
+// probeBackend checks a backend with GET /v1/models, or with a one-token
+// message to health.ProbeModel when it is set. A model list fetched by the
+// probe also refreshes the backend's discovered models.
+func probeBackend(b *Backend, health config.HealthCheckConfig) error {
+	timeout := health.ProbeTimeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("[backend:%s] validate: decode response error: %v", b.Name, err)
-		return false
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if health.ProbeModel == "" {
+		models, err := fetchModels(ctx, b)
+		if err != nil {
+			return err
+		}
+		if len(models) == 0 {
+			return fmt.Errorf("model list is empty")
+		}
+		if health.ModelRefreshInterval > 0 {
+			b.setModels(models)
+		}
+		return nil
 	}
 
-	if len(result.Data) == 0 {
-		log.Printf("[backend:%s] validate: FAIL — data array is empty", b.Name)
-		return false
+	path := "/v1/messages"
+	if b.Protocol == ProtocolOpenAI {
+		path = "/v1/chat/completions"
 	}
+	// The request shape is the same in both protocols.
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":      health.ProbeModel,
+		"max_tokens": 1,
+		"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(b.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	setBackendAuth(req, b)
+	req.Header.Set("Content-Type", "application/json")
 
-	log.Printf("[backend:%s] validate: OK — %d model(s) available", b.Name, len(result.Data))
-	return true
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s returned HTTP %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// setBackendAuth sets the headers the gateway itself sends to a backend.
+func setBackendAuth(req *http.Request, b *Backend) {
+	req.Header.Set("Authorization", "Bearer "+b.APIKey)
+	req.Header.Set("x-api-key", b.APIKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
 }
@@ -1,7 +1,12 @@
 package proxy_test
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/wjzhangq/claude-gateway/config"
 	"github.com/wjzhangq/claude-gateway/internal/proxy"
@@ -22,7 +27,7 @@ func makeBackends(weights ...int) []config.BackendAPI {
 }
 
 func TestLoadBalancer_Pick_SingleBackend(t *testing.T) {
-	lb := proxy.NewLoadBalancer(makeBackends(10))
+	lb := proxy.NewLoadBalancer(makeBackends(10), config.HealthCheckConfig{})
 	b := lb.Pick()
 	if b == nil {
 		t.Fatal("expected backend, got nil")
@@ -30,14 +35,14 @@ func TestLoadBalancer_Pick_SingleBackend(t *testing.T) {
 }
 
 func TestLoadBalancer_Pick_NoBackends(t *testing.T) {
-	lb := proxy.NewLoadBalancer(nil)
+	lb := proxy.NewLoadBalancer(nil, config.HealthCheckConfig{})
 	if lb.Pick() != nil {
 		t.Fatal("expected nil for empty backend list")
 	}
 }
 
 func TestBackend_DisableAfterErrors(t *testing.T) {
-	lb := proxy.NewLoadBalancer(makeBackends(10))
+	lb := proxy.NewLoadBalancer(makeBackends(10), config.HealthCheckConfig{})
 	b := lb.Pick()
 	if b == nil {
 		t.Fatal("expected backend")
@@ -59,3 +64,208 @@ func TestBackend_DisableAfterErrors(t *testing.T) {
 		t.Fatal("expected backend after recovery")
 	}
 }
+
+func TestLoadBalancer_Replace(t *testing.T) {
+	a := config.BackendAPI{Name: "a", URL: "http://a", APIKey: "ka", Weight: 1, Enabled: true}
+	b := config.BackendAPI{Name: "b", URL: "http://b", APIKey: "kb", Weight: 1, Enabled: true}
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{a, b}, config.HealthCheckConfig{})
+	before := lb.Backends()
+
+	// Rotate b's key and drop a: b gets a new Backend, the old object stays usable.
+	b.APIKey = "kb2"
+	lb.Replace([]config.BackendAPI{b})
+	after := lb.Backends()
+	if len(after) != 1 || after[0].Name != "b" || after[0].APIKey != "kb2" {
+		t.Fatalf("unexpected backends after replace: %+v", after)
+	}
+	if after[0] == before[1] || before[1].APIKey != "kb" {
+		t.Fatal("expected a changed backend to be replaced without mutating the old object")
+	}
+
+	// An unchanged backend keeps its object and health state.
+	after[0].RecordError()
+	lb.Replace([]config.BackendAPI{b})
+	if lb.Backends()[0] != after[0] {
+		t.Fatal("expected an unchanged backend to be kept")
+	}
+}
+
+func TestLoadBalancer_ModelAllowlist(t *testing.T) {
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "claude", URL: "http://a", APIKey: "k", Weight: 1, Enabled: true, Models: []string{"claude-*"}},
+		{Name: "gpt", URL: "http://b", APIKey: "k", Weight: 1, Enabled: true, Models: []string{"gpt-4o"}},
+	}, config.HealthCheckConfig{})
+	for i := 0; i < 20; i++ {
+		if b := lb.PickExcluding("claude-sonnet-4", nil); b == nil || b.Name != "claude" {
+			t.Fatalf("expected claude backend, got %v", b)
+		}
+	}
+	if b := lb.PickExcluding("gpt-4o", nil); b == nil || b.Name != "gpt" {
+		t.Fatalf("expected gpt backend, got %v", b)
+	}
+	if b := lb.PickExcluding("llama-3", nil); b != nil {
+		t.Fatalf("expected no backend for an unlisted model, got %s", b.Name)
+	}
+}
+
+func TestBackend_CircuitBreakerHalfOpen(t *testing.T) {
+	lb := proxy.NewLoadBalancer(makeBackends(1), config.HealthCheckConfig{
+		FailureThreshold: 2,
+		SuccessThreshold: 1,
+		OpenTimeout:      20 * time.Millisecond,
+	})
+	b := lb.Pick()
+	b.RecordError()
+	b.RecordError()
+	if got := b.Health().State; got != proxy.StateOpen {
+		t.Fatalf("expected open circuit, got %s", got)
+	}
+	if lb.Pick() != nil {
+		t.Fatal("expected no backend while the circuit is open")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	trial := lb.Pick()
+	if trial == nil || trial.Health().State != proxy.StateHalfOpen {
+		t.Fatalf("expected a half-open trial after the open timeout, got %v", trial)
+	}
+	if lb.Pick() != nil {
+		t.Fatal("expected only one trial request while half-open")
+	}
+
+	// A failed trial reopens the circuit; a successful one closes it.
+	trial.RecordError()
+	if got := b.Health().State; got != proxy.StateOpen {
+		t.Fatalf("expected failed trial to reopen the circuit, got %s", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	lb.Pick().RecordSuccess()
+	if got := b.Health().State; got != proxy.StateClosed {
+		t.Fatalf("expected successful trial to close the circuit, got %s", got)
+	}
+}
+
+func TestBackend_HalfOpenTrialIsFreedOnlyByItsOwnOutcome(t *testing.T) {
+	lb := proxy.NewLoadBalancer(makeBackends(1), config.HealthCheckConfig{
+		FailureThreshold: 1,
+		SuccessThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+	})
+	stale := lb.Pick() // still running when the circuit opens
+	lb.Pick().RecordError()
+	time.Sleep(30 * time.Millisecond)
+	trial := lb.Pick()
+	if trial == nil || trial.Health().State != proxy.StateHalfOpen {
+		t.Fatalf("expected a half-open trial, got %v", trial)
+	}
+
+	stale.RecordSuccess()
+	if lb.Pick() != nil {
+		t.Fatal("expected a request started before the circuit opened not to free the trial slot")
+	}
+	trial.RecordSuccess()
+	if got := trial.Health().State; got != proxy.StateClosed {
+		t.Fatalf("expected the trial to close the circuit, got %s", got)
+	}
+}
+
+func TestLoadBalancer_ProbeRevivesBackendThatFailedValidation(t *testing.T) {
+	var up atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"claude-sonnet-4-5"}]}`))
+	}))
+	defer upstream.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "a", URL: upstream.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{OpenTimeout: time.Hour})
+	lb.ValidateBackends()
+	if lb.Pick() != nil {
+		t.Fatal("expected backend that failed validation to be unavailable")
+	}
+
+	up.Store(true)
+	lb.ProbeBackends()
+	b := lb.Pick()
+	if b == nil {
+		t.Fatal("expected a successful probe to admit a trial request")
+	}
+	b.RecordSuccess()
+	if got := b.Health().State; got != proxy.StateClosed {
+		t.Fatalf("expected closed circuit, got %s", got)
+	}
+}
+
+func TestLoadBalancer_PriorityStrategySpillsOver(t *testing.T) {
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "primary", URL: "http://a", APIKey: "k", Weight: 1, Enabled: true, Priority: 1},
+		{Name: "secondary", URL: "http://b", APIKey: "k", Weight: 5, Enabled: true, Priority: 2},
+	}, config.HealthCheckConfig{FailureThreshold: 1, OpenTimeout: time.Hour})
+	if err := lb.SetStrategy(proxy.StrategyPriority); err != nil {
+		t.Fatal(err)
+	}
+	if err := lb.SetStrategy("round_robin"); err == nil {
+		t.Fatal("expected an unknown strategy to be rejected")
+	}
+
+	for i := 0; i < 20; i++ {
+		if b := lb.Pick(); b == nil || b.Name != "primary" {
+			t.Fatalf("expected primary tier, got %v", b)
+		}
+	}
+	lb.Pick().RecordError()
+	if b := lb.Pick(); b == nil || b.Name != "secondary" {
+		t.Fatalf("expected spillover to secondary while primary is open, got %v", b)
+	}
+}
+
+// modelServer lists ids on GET /v1/models, two per page.
+func modelServer(ids ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		for i, id := range ids {
+			if id == r.URL.Query().Get("after_id") {
+				start = i + 1
+			}
+		}
+		end := min(start+2, len(ids))
+		var data []map[string]string
+		for _, id := range ids[start:end] {
+			data = append(data, map[string]string{"id": id})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data":     data,
+			"has_more": end < len(ids),
+			"last_id":  ids[end-1],
+		})
+	}))
+}
+
+func TestLoadBalancer_RoutesByDiscoveredModels(t *testing.T) {
+	claude := modelServer("claude-haiku-4-5-20251001", "claude-sonnet-4-5-20250929", "claude-opus-4-1-20250805")
+	defer claude.Close()
+	qwen := modelServer("qwen-max")
+	defer qwen.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "claude", URL: claude.URL, APIKey: "k", Weight: 1, Enabled: true},
+		{Name: "qwen", URL: qwen.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{ModelRefreshInterval: time.Hour})
+	lb.RefreshModels()
+
+	for _, m := range []string{"claude-opus-4-1-20250805", "claude-sonnet-4-5", "claude-haiku-4-5-latest"} {
+		if b := lb.PickExcluding(m, nil); b == nil || b.Name != "claude" {
+			t.Fatalf("%s: expected claude backend, got %v", m, b)
+		}
+	}
+	if b := lb.PickExcluding("qwen-max", nil); b == nil || b.Name != "qwen" {
+		t.Fatalf("expected qwen backend, got %v", b)
+	}
+	if lb.HasModel("claude-sonnet-4") || lb.PickExcluding("claude-sonnet-4", nil) != nil {
+		t.Fatal("expected a model no backend lists to be unserved")
+	}
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+)
+
+// Circuit breaker states.
+const (
+	StateClosed   = "closed"    // traffic flows normally
+	StateOpen     = "open"      // no traffic until the open timeout passes or a probe succeeds
+	StateHalfOpen = "half_open" // one trial request at a time decides whether to close again
+)
+
+// Default circuit breaker settings, used for zero values in config.HealthCheckConfig.
+const (
+	defaultFailureThreshold = 5
+	defaultSuccessThreshold = 1
+	defaultOpenTimeout      = 30 * time.Second
+)
+
+// outcome classifies an upstream attempt for the circuit breaker.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnored // says nothing about the backend's health, e.g. a malformed client request
+)
+
+// classify decides whether an attempt counts for or against the backend.
+// Client-caused 4xx responses and requests cancelled by the client are ignored;
+// connection errors, timeouts, retryable responses (see isRetryableResponse)
+// and authentication failures (the gateway's upstream key is rejected) count
+// as failures.
+func classify(ctx context.Context, resp *http.Response, err error, retryable bool) outcome {
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return outcomeIgnored
+		}
+		return outcomeFailure
+	}
+	switch {
+	case retryable:
+		return outcomeFailure
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden,
+		resp.StatusCode == http.StatusRequestTimeout:
+		return outcomeFailure
+	case resp.StatusCode >= 400:
+		return outcomeIgnored
+	}
+	return outcomeSuccess
+}
+
+// ticket identifies the request admitted as a half-open trial, so that only
+// its outcome frees the trial slot. Requests admitted while closed get 0.
+type ticket uint64
+
+// breaker is a per-backend circuit breaker. After FailureThreshold consecutive
+// failures it opens; once OpenTimeout has passed (or an active probe succeeds)
+// it lets trial requests through one at a time, closing after SuccessThreshold
+// consecutive successes and reopening on the first failure.
+type breaker struct {
+	name             string
+	failureThreshold int
+	successThreshold int
+	openTimeout      time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int    // consecutive failures while closed
+	successes int    // consecutive successes while half-open
+	trial     ticket // the half-open trial request in flight; 0 if none
+	tickets   ticket // last ticket issued
+	changedAt time.Time
+	lastError string
+}
+
+func newBreaker(name string, hc config.HealthCheckConfig) *breaker {
+	b := &breaker{
+		name:             name,
+		failureThreshold: hc.FailureThreshold,
+		successThreshold: hc.SuccessThreshold,
+		openTimeout:      hc.OpenTimeout,
+		state:            StateClosed,
+		changedAt:        time.Now(),
+	}
+	if b.failureThreshold <= 0 {
+		b.failureThreshold = defaultFailureThreshold
+	}
+	if b.successThreshold <= 0 {
+		b.successThreshold = defaultSuccessThreshold
+	}
+	if b.openTimeout <= 0 {
+		b.openTimeout = defaultOpenTimeout
+	}
+	return b
+}
+
+// available reports whether a request could be sent now, without reserving a trial.
+func (b *breaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		return time.Since(b.changedAt) >= b.openTimeout
+	case StateHalfOpen:
+		return b.trial == 0
+	}
+	return true
+}
+
+// acquire reserves the right to send a request. In half-open state only one
+// trial request may be in flight; it is identified by the returned ticket.
+func (b *breaker) acquire() (ticket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.changedAt) < b.openTimeout {
+			return 0, false
+		}
+		b.setState(StateHalfOpen, "open timeout elapsed")
+		fallthrough
+	case StateHalfOpen:
+		if b.trial != 0 {
+			return 0, false
+		}
+		b.tickets++
+		b.trial = b.tickets
+		return b.trial, true
+	}
+	return 0, true
+}
+
+// record applies the outcome of a request or probe. reason describes a
+// failure. t is the request's ticket from acquire; only the trial's own
+// outcome frees the trial slot, not that of a request started earlier.
+func (b *breaker) record(o outcome, reason string, t ticket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t != 0 && t == b.trial {
+		b.trial = 0
+	}
+	switch o {
+	case outcomeSuccess:
+		b.failures = 0
+		if b.state == StateClosed {
+			return
+		}
+		// A success while open comes from a request started before the circuit opened.
+		if b.state == StateOpen {
+			b.setState(StateHalfOpen, "request succeeded")
+		}
+		b.successes++
+		if b.successes >= b.successThreshold {
+			b.setState(StateClosed, "backend recovered")
+		}
+	case outcomeFailure:
+		b.lastError = reason
+		switch b.state {
+		case StateClosed:
+			b.failures++
+			if b.failures >= b.failureThreshold {
+				b.setState(StateOpen, reason)
+			}
+		case StateHalfOpen:
+			b.setState(StateOpen, reason)
+		case StateOpen:
+			b.changedAt = time.Now() // stay open for another full timeout
+		}
+	}
+}
+
+// probed applies the result of an active health probe. A successful probe lets
+// an open circuit move to half-open without waiting for the open timeout.
+func (b *breaker) probed(err error) {
+	if err != nil {
+		b.record(outcomeFailure, "probe: "+err.Error(), 0)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		b.setState(StateHalfOpen, "probe succeeded")
+	case StateClosed:
+		b.failures = 0
+	}
+}
+
+// trip opens the circuit immediately, e.g. when startup validation fails.
+func (b *breaker) trip(reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastError = reason
+	if b.state != StateOpen {
+		b.setState(StateOpen, reason)
+	}
+}
+
+// setState moves to state and logs the transition. Caller holds mu.
+func (b *breaker) setState(state, reason string) {
+	if state == StateOpen {
+		logger.Warnf("backend %s circuit %s -> %s: %s", b.name, b.state, state, reason)
+	} else {
+		logger.Infof("backend %s circuit %s -> %s: %s", b.name, b.state, state, reason)
+	}
+	b.state = state
+	b.changedAt = time.Now()
+	b.failures = 0
+	b.successes = 0
+	b.trial = 0
+}
+
+// BackendHealth is a snapshot of a backend's circuit breaker.
+type BackendHealth struct {
+	State               string    `json:"state"`
+	Since               time.Time `json:"since"` // when the current state was entered
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Errors              int64     `json:"errors"` // failures since the backend was added
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+func (b *breaker) snapshot() BackendHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BackendHealth{
+		State:               b.state,
+		Since:               b.changedAt,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+)
+
+// Page sizes for GET /v1/models, as in the Anthropic API.
+const (
+	defaultModelPageLimit = 20
+	maxModelPageLimit     = 1000
+)
+
+// Models handles GET /v1/models and GET /v1/models/{id}. The gateway answers
+// itself with the models of all backends that are not circuit-broken, plus the
+// aliases defined by exact and model_replacements rewrite rules, limited to
+// what the calling key may use.
+//
+// Requests carrying an anthropic-version header get the Anthropic format and
+// its default page size; others get the OpenAI format, unpaginated unless
+// limit, after_id or before_id is given.
+func (h *Handler) Models(c *gin.Context) {
+	keyInfo, _ := c.Get(middleware.CtxKeyInfo)
+	info, _ := keyInfo.(*auth.KeyInfo)
+	models := h.visibleModels(info)
+	anthropic := c.GetHeader("anthropic-version") != ""
+
+	if id := strings.Trim(strings.TrimPrefix(c.Param("path"), "/models"), "/"); id != "" {
+		i := slices.IndexFunc(models, func(m ModelInfo) bool { return m.ID == id })
+		if i < 0 {
+			middleware.AbortWithAPIError(c, http.StatusNotFound, "not_found_error", fmt.Sprintf("model %q not found", id))
+			return
+		}
+		if anthropic {
+			c.JSON(http.StatusOK, anthropicModel(models[i]))
+		} else {
+			c.JSON(http.StatusOK, openAIModel(models[i]))
+		}
+		return
+	}
+
+	limit := len(models)
+	if anthropic {
+		limit = defaultModelPageLimit
+	}
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxModelPageLimit {
+			middleware.AbortWithAPIError(c, http.StatusBadRequest, "invalid_request_error",
+				fmt.Sprintf("limit must be between 1 and %d", maxModelPageLimit))
+			return
+		}
+		limit = n
+	}
+	afterID, beforeID := c.Query("after_id"), c.Query("before_id")
+	if afterID != "" && beforeID != "" {
+		middleware.AbortWithAPIError(c, http.StatusBadRequest, "invalid_request_error", "after_id and before_id cannot be used together")
+		return
+	}
+	page, hasMore := paginateModels(models, limit, afterID, beforeID)
+
+	if !anthropic {
+		data := make([]gin.H, len(page))
+		for i, m := range page {
+			data[i] = openAIModel(m)
+		}
+		c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+		return
+	}
+	data := make([]gin.H, len(page))
+	for i, m := range page {
+		data[i] = anthropicModel(m)
+	}
+	var firstID, lastID interface{}
+	if len(page) > 0 {
+		firstID, lastID = page[0].ID, page[len(page)-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data, "has_more": hasMore, "first_id": firstID, "last_id": lastID})
+}
+
+// visibleModels returns the models info may call, newest first. An alias is
+// listed with the details of the model it is rewritten to, as long as that
+// model is served.
+func (h *Handler) visibleModels(info *auth.KeyInfo) []ModelInfo {
+	served := h.lb.Models()
+	byID := make(map[string]ModelInfo, len(served))
+	for _, m := range served {
+		byID[m.ID] = m
+	}
+	for alias, target := range h.rewriter.Load().aliases(info) {
+		if m, ok := byID[target]; ok {
+			m.ID = alias
+			byID[alias] = m
+		}
+	}
+
+	models := make([]ModelInfo, 0, len(byID))
+	for id, m := range byID {
+		if info == nil || info.AllowsModel(id, h.defaultModels) {
+			models = append(models, m)
+		}
+	}
+	slices.SortFunc(models, func(a, b ModelInfo) int {
+		if n := b.CreatedAt.Compare(a.CreatedAt); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return models
+}
+
+// paginateModels returns up to limit models after afterID or immediately
+// before beforeID, and whether more remain in that direction. An unknown
+// cursor yields an empty page.
+func paginateModels(models []ModelInfo, limit int, afterID, beforeID string) ([]ModelInfo, bool) {
+	index := func(id string) int {
+		if i := slices.IndexFunc(models, func(m ModelInfo) bool { return m.ID == id }); i >= 0 {
+			return i
+		}
+		return len(models)
+	}
+	if beforeID != "" {
+		end := index(beforeID)
+		if end == len(models) {
+			return nil, false
+		}
+		start := max(end-limit, 0)
+		return models[start:end], start > 0
+	}
+	start := 0
+	if afterID != "" {
+		start = min(index(afterID)+1, len(models))
+	}
+	end := min(start+limit, len(models))
+	return models[start:end], end < len(models)
+}
+
+func anthropicModel(m ModelInfo) gin.H {
+	name := m.DisplayName
+	if name == "" {
+		name = m.ID
+	}
+	created := m.CreatedAt
+	if created.IsZero() {
+		created = time.Unix(0, 0)
+	}
+	return gin.H{"type": "model", "id": m.ID, "display_name": name, "created_at": created.UTC().Format(time.RFC3339)}
+}
+
+func openAIModel(m ModelInfo) gin.H {
+	owner := m.OwnedBy
+	if owner == "" {
+		owner = "system"
+	}
+	var created int64
+	if !m.CreatedAt.IsZero() {
+		created = m.CreatedAt.Unix()
+	}
+	return gin.H{"id": m.ID, "object": "model", "created": created, "owned_by": owner}
+}
@@ -0,0 +1,114 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+)
+
+func newCatalogRouter(t *testing.T, replacements map[string]string, granted []string) *gin.Engine {
+	claude := modelServer("claude-haiku-4-5-20251001", "claude-opus-4-1-20250805", "claude-sonnet-4-5-20250929")
+	t.Cleanup(claude.Close)
+	down := modelServer("claude-secret-model")
+	t.Cleanup(down.Close)
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "claude", URL: claude.URL, APIKey: "k", Weight: 1, Enabled: true},
+		{Name: "down", URL: down.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{ModelRefreshInterval: time.Hour, FailureThreshold: 1, OpenTimeout: time.Hour})
+	lb.RefreshModels()
+	for _, b := range lb.Backends() {
+		if b.Name == "down" {
+			b.RecordError()
+		}
+	}
+
+	rewriter, err := proxy.NewRewriter(nil, replacements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := proxy.NewHandler(lb, nil, rewriter, []string{"claude-haiku-*", "sonnet"}, config.RetryConfig{MaxAttempts: 1}, nil)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(middleware.CtxKeyInfo, &auth.KeyInfo{UserID: 1, Models: granted})
+	})
+	r.Any("/v1/*path", h.Passthrough)
+	return r
+}
+
+func getModels(t *testing.T, r *gin.Engine, url string, anthropic bool) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if anthropic {
+		req.Header.Set("anthropic-version", "2023-06-01")
+	}
+	r.ServeHTTP(w, req)
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body
+}
+
+func modelIDs(body map[string]interface{}) []string {
+	var ids []string
+	for _, m := range body["data"].([]interface{}) {
+		ids = append(ids, m.(map[string]interface{})["id"].(string))
+	}
+	return ids
+}
+
+func TestModels_FilteredToEntitlementsWithAliases(t *testing.T) {
+	r := newCatalogRouter(t, map[string]string{"sonnet": "claude-sonnet-4-5-20250929"}, []string{"claude-opus-*"})
+
+	code, body := getModels(t, r, "/v1/models", false)
+	if code != http.StatusOK || body["object"] != "list" {
+		t.Fatalf("expected OpenAI list, got %d %v", code, body)
+	}
+	// The unentitled sonnet snapshot and the circuit-broken backend's model are hidden.
+	got := modelIDs(body)
+	want := []string{"claude-haiku-4-5-20251001", "claude-opus-4-1-20250805", "sonnet"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	if code, body := getModels(t, r, "/v1/models/sonnet", true); code != http.StatusOK || body["type"] != "model" {
+		t.Fatalf("expected alias to be retrievable, got %d %v", code, body)
+	}
+	if code, _ := getModels(t, r, "/v1/models/claude-sonnet-4-5-20250929", true); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unentitled model, got %d", code)
+	}
+}
+
+func TestModels_AnthropicPagination(t *testing.T) {
+	r := newCatalogRouter(t, map[string]string{"sonnet": "claude-sonnet-4-5-20250929"}, []string{"claude-*"})
+
+	_, first := getModels(t, r, "/v1/models?limit=2", true)
+	if ids := modelIDs(first); len(ids) != 2 || first["has_more"] != true || first["last_id"] != ids[1] {
+		t.Fatalf("unexpected first page: %v", first)
+	}
+	_, second := getModels(t, r, "/v1/models?limit=2&after_id="+first["last_id"].(string), true)
+	if ids := modelIDs(second); len(ids) != 2 || second["has_more"] != false {
+		t.Fatalf("unexpected second page: %v", second)
+	}
+	_, back := getModels(t, r, "/v1/models?limit=1&before_id="+second["first_id"].(string), true)
+	if ids := modelIDs(back); len(ids) != 1 || ids[0] != first["last_id"] || back["has_more"] != true {
+		t.Fatalf("unexpected page before %v: %v", second["first_id"], back)
+	}
+	if code, _ := getModels(t, r, "/v1/models?limit=0", true); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid limit, got %d", code)
+	}
+}
@@ -1,40 +1,63 @@
 package proxy
 
 import (
-	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/audit"
 	"github.com/wjzhangq/claude-gateway/internal/auth"
 	"github.com/wjzhangq/claude-gateway/internal/logger"
 	"github.com/wjzhangq/claude-gateway/internal/middleware"
+	"github.com/wjzhangq/claude-gateway/internal/pricing"
 	"github.com/wjzhangq/claude-gateway/internal/stats"
 )
 
 // Handler forwards requests to upstream Claude backends.
 type Handler struct {
-	lb                *LoadBalancer
-	collector         *stats.Collector
-	modelReplacements map[string]string
+	lb            *LoadBalancer
+	collector     *stats.Collector
+	rewriter      atomic.Pointer[Rewriter]
+	defaultModels []string
+	retry         config.RetryConfig
+	prices        *pricing.Table
+	audit         *audit.Recorder
 }
 
-func NewHandler(lb *LoadBalancer, collector *stats.Collector, modelReplacements map[string]string) *Handler {
-	return &Handler{lb: lb, collector: collector, modelReplacements: modelReplacements}
+func NewHandler(lb *LoadBalancer, collector *stats.Collector, rewriter *Rewriter, defaultModels []string, retry config.RetryConfig, prices *pricing.Table) *Handler {
+	h := &Handler{lb: lb, collector: collector, defaultModels: defaultModels, retry: retry, prices: prices}
+	h.rewriter.Store(rewriter)
+	return h
 }
 
+// SetRewriter replaces the model rewrite rules. It is safe to call while
+// serving; requests already rewritten keep their model.
+func (h *Handler) SetRewriter(r *Rewriter) {
+	h.rewriter.Store(r)
+}
+
+// SetAuditRecorder enables payload capture for the users and keys rec selects.
+// It must be called before the handler serves requests.
+func (h *Handler) SetAuditRecorder(rec *audit.Recorder) {
+	h.audit = rec
+}
+
+// ctxRequestedModel holds the model a request asked for before rewriting.
+const ctxRequestedModel = "proxy_requested_model"
+
 // forward is the shared proxy logic for both OpenAI and Anthropic style endpoints.
 func (h *Handler) forward(c *gin.Context, upstreamPath string) {
-	backend := h.lb.Pick()
-	if backend == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no available backend"})
-		return
-	}
-
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "read request body failed"})
@@ -50,212 +73,236 @@ func (h *Handler) forward(c *gin.Context, upstreamPath string) {
 		reqModel = reqJSON.Model
 	}
 
-	// Apply model replacements: if request model contains a configured pattern, replace it
-	for pattern, replacement := range h.modelReplacements {
-		if strings.Contains(reqModel, pattern) {
-			oldToken := `"model":"` + reqModel + `"`
-			newToken := `"model":"` + replacement + `"`
-			body = bytes.Replace(body, []byte(oldToken), []byte(newToken), 1)
-			// also handle space after colon: "model": "value"
-			oldTokenSpace := `"model": "` + reqModel + `"`
-			newTokenSpace := `"model": "` + replacement + `"`
-			body = bytes.Replace(body, []byte(oldTokenSpace), []byte(newTokenSpace), 1)
-			reqModel = replacement
-			break
-		}
+	keyInfo, _ := c.Get(middleware.CtxKeyInfo)
+	info, _ := keyInfo.(*auth.KeyInfo)
+
+	// Captures include requests that are rejected below.
+	if rec := h.audit.Begin(c, info, reqModel, body); rec != nil {
+		defer func() { rec.Finish(c.GetString("proxy_backend"), reqModel) }()
 	}
 
-	targetURL := strings.TrimRight(backend.URL, "/") + upstreamPath
-	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, targetURL, bytes.NewReader(body))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "build request failed"})
+	// Entitlements are checked against the model the client asked for, before rewriting.
+	if info != nil && reqModel != "" && !info.AllowsModel(reqModel, h.defaultModels) {
+		middleware.AbortWithAPIError(c, http.StatusForbidden, "permission_error", fmt.Sprintf(
+			"model %q is not enabled for your account; request access by submitting a model application in the gateway console (POST /api/applications)",
+			reqModel))
 		return
 	}
 
-	// Copy headers, replace Authorization
-	for k, vv := range c.Request.Header {
-		k = http.CanonicalHeaderKey(k)
-		if k == "Authorization" || k == "X-Api-Key" {
-			continue
-		}
-		for _, v := range vv {
-			req.Header.Add(k, v)
+	// Record the requested model for usage logs, then rewrite it.
+	c.Set(ctxRequestedModel, reqModel)
+	_, span := startSpan(c.Request.Context(), "model.rewrite", semconv.GenAIRequestModel(reqModel))
+	model := h.rewriter.Load().Rewrite(reqModel, info)
+	span.SetAttributes(attrModel.String(model))
+	span.End()
+	if model != reqModel {
+		if body, err = setModel(body, model); err != nil {
+			middleware.AbortWithAPIError(c, http.StatusBadRequest, "invalid_request_error", "request body is not a JSON object")
+			return
 		}
+		reqModel = model
 	}
-	req.Header.Set("Authorization", "Bearer "+backend.APIKey)
-	req.Header.Set("x-api-key", backend.APIKey)
-	req.Header.Set("Content-Type", "application/json")
 
 	start := time.Now()
-	resp, err := backend.Client().Do(req)
-	if err != nil {
-		backend.RecordError()
-		logger.Errorf("backend %s error: %v", backend.Name, err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "upstream request failed"})
+	upReq := newUpstreamRequest(upstreamPath, body)
+	resp, backend, tr := h.roundTrip(c, upReq, reqModel)
+	if resp == nil {
 		return
 	}
 	defer resp.Body.Close()
-	backend.RecordSuccess()
 
 	// Expose backend name for the request logger
 	c.Set("proxy_backend", backend.Name)
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(
+		semconv.GenAIRequestModel(c.GetString(ctxRequestedModel)), attrModel.String(reqModel), attrBackend.String(backend.Name))
 
 	// Copy response headers
 	for k, vv := range resp.Header {
+		// A translated body has a different length.
+		if tr != translateNone && http.CanonicalHeaderKey(k) == "Content-Length" {
+			continue
+		}
+		// The client gets the gateway's request ID; the backend's is in Request-Id or logged.
+		if http.CanonicalHeaderKey(k) == middleware.RequestIDHeader {
+			continue
+		}
+		if isUpstreamRateLimitHeader(c, k) {
+			continue
+		}
 		for _, v := range vv {
 			c.Header(k, v)
 		}
 	}
-	c.Status(resp.StatusCode)
 
-	keyInfo, _ := c.Get(middleware.CtxKeyInfo)
+	if tr != translateNone {
+		h.writeTranslated(c, resp, tr, upReq, backend.Name, reqModel, start)
+		return
+	}
+	c.Status(resp.StatusCode)
 
 	// Stream or buffer
-	isStream := strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream")
-	if isStream {
-		h.streamResponse(c, resp, backend.Name, reqModel, keyInfo, resp.StatusCode, start)
+	if isEventStream(resp) {
+		h.streamResponse(c, resp, backend.Name, reqModel, resp.StatusCode, start)
 	} else {
-		h.bufferResponse(c, resp, backend.Name, reqModel, keyInfo, resp.StatusCode, start)
+		h.bufferResponse(c, resp, backend.Name, reqModel, resp.StatusCode, start)
+	}
+}
+
+// isUpstreamRateLimitHeader reports whether the upstream header k must not
+// reach the client because it describes rate limits. The backend's
+// anthropic-ratelimit-* headers are about the gateway's shared upstream key,
+// and would overwrite the per-user and per-key limits set by
+// middleware.APIRateLimit; a backend retry-after is kept unless the gateway
+// has set its own.
+func isUpstreamRateLimitHeader(c *gin.Context, k string) bool {
+	k = strings.ToLower(k)
+	if strings.HasPrefix(k, "anthropic-ratelimit-") {
+		return true
 	}
+	return k == "retry-after" && c.Writer.Header().Get(k) != ""
+}
+
+// isEventStream reports whether resp is a server-sent event stream.
+func isEventStream(resp *http.Response) bool {
+	return strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream")
 }
 
-func (h *Handler) streamResponse(c *gin.Context, resp *http.Response, backendName, model string, keyInfo interface{}, statusCode int, start time.Time) {
+// streamResponse relays an event stream to the client as it arrives. Usage is
+// extracted on the fly, so memory stays constant however long the stream is.
+// If the client goes away, reading stops, the upstream request is cancelled and
+// the tokens seen so far are recorded as a client-aborted request.
+func (h *Handler) streamResponse(c *gin.Context, resp *http.Response, backendName, model string, statusCode int, start time.Time) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("X-Accel-Buffering", "no")
 
+	_, span := startSpan(c.Request.Context(), "response.stream", attrBackend.String(backendName))
 	flusher, canFlush := c.Writer.(http.Flusher)
-	var accumulated []byte
+	body := &firstByteReader{r: resp.Body, start: start}
+	var tap usageTap
+	var readErr, writeErr error
 	buf := make([]byte, 4096)
 	for {
-		n, err := resp.Body.Read(buf)
+		var n int
+		n, readErr = body.Read(buf)
 		if n > 0 {
-			accumulated = append(accumulated, buf[:n]...)
-			c.Writer.Write(buf[:n])
+			tap.Write(buf[:n])
+			if _, writeErr = c.Writer.Write(buf[:n]); writeErr != nil {
+				break
+			}
 			if canFlush {
 				flusher.Flush()
 			}
 		}
-		if err != nil {
+		if readErr != nil {
 			break
 		}
 	}
+	tap.Flush()
+
+	aborted := writeErr != nil || readErr != io.EOF && clientGone(c)
+	if aborted {
+		// Cancel the upstream request rather than letting the backend finish generating.
+		resp.Body.Close()
+		logger.Infof("client aborted stream from %s after %s", backendName, time.Since(start).Round(time.Millisecond))
+	}
+	endStreamSpan(span, start, body.ttfb, body.n, aborted)
+	h.emitUsage(c, backendName, model, statusCode, tap.Usage(), time.Since(start), aborted, body.ttfb)
+}
 
-	in, out := parseStreamTokens(accumulated)
-	h.emitUsage(keyInfo, backendName, model, statusCode, in, out, time.Since(start))
+// firstByteReader records how long after start the first bytes were read from
+// r, and how many bytes were read.
+type firstByteReader struct {
+	r     io.Reader
+	start time.Time
+	ttfb  time.Duration
+	n     int64
 }
 
-func (h *Handler) bufferResponse(c *gin.Context, resp *http.Response, backendName, model string, keyInfo interface{}, statusCode int, start time.Time) {
+func (f *firstByteReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 && f.ttfb == 0 {
+		f.ttfb = time.Since(f.start)
+	}
+	f.n += int64(n)
+	return n, err
+}
+
+func (h *Handler) bufferResponse(c *gin.Context, resp *http.Response, backendName, model string, statusCode int, start time.Time) {
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logger.Errorf("read response body: %v", err)
+		h.emitUsage(c, backendName, model, statusCode, tokenUsage{}, time.Since(start), clientGone(c), 0)
 		return
 	}
 	c.Writer.Write(respBody)
 
-	in, out := parseBodyTokens(respBody)
-	h.emitUsage(keyInfo, backendName, model, statusCode, in, out, time.Since(start))
-}
-
-// parseBodyTokens extracts token counts from a non-streaming JSON response.
-// Handles both OpenAI and Anthropic response formats.
-func parseBodyTokens(body []byte) (input, output int) {
-	var r struct {
-		Usage struct {
-			// OpenAI format
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			// Anthropic format
-			InputTokens  int `json:"input_tokens"`
-			OutputTokens int `json:"output_tokens"`
-		} `json:"usage"`
-	}
-	if err := json.Unmarshal(body, &r); err != nil {
-		return 0, 0
-	}
-	input = r.Usage.PromptTokens + r.Usage.InputTokens
-	output = r.Usage.CompletionTokens + r.Usage.OutputTokens
-	return
-}
-
-// parseStreamTokens scans SSE lines for usage data in the final chunk.
-func parseStreamTokens(data []byte) (input, output int) {
-	// Look for usage in SSE data lines
-	lines := bytes.Split(data, []byte("\n"))
-	for _, line := range lines {
-		line = bytes.TrimSpace(line)
-		if !bytes.HasPrefix(line, []byte("data:")) {
-			continue
-		}
-		payload := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
-		if len(payload) == 0 || bytes.Equal(payload, []byte("[DONE]")) {
-			continue
-		}
-		in, out := parseBodyTokens(payload)
-		if in > 0 || out > 0 {
-			input = in
-			output = out
-		}
-	}
-	return
+	h.emitUsage(c, backendName, model, statusCode, parseBodyUsage(respBody), time.Since(start), false, 0)
 }
 
-// costUSD estimates cost based on token counts and model.
-// Uses approximate pricing; adjust as needed.
-func costUSD(model string, inputTokens, outputTokens int) float64 {
-	// Default to claude-3-5-sonnet pricing as fallback
-	inputPrice := 3.0   // per 1M tokens
-	outputPrice := 15.0 // per 1M tokens
-
-	m := strings.ToLower(model)
-	switch {
-	case strings.Contains(m, "claude-opus-4") || strings.Contains(m, "opus-4"):
-		inputPrice, outputPrice = 15.0, 75.0
-	case strings.Contains(m, "claude-sonnet-4") || strings.Contains(m, "sonnet-4"):
-		inputPrice, outputPrice = 3.0, 15.0
-	case strings.Contains(m, "claude-haiku-3-5") || strings.Contains(m, "haiku-3-5"):
-		inputPrice, outputPrice = 0.8, 4.0
-	case strings.Contains(m, "claude-haiku"):
-		inputPrice, outputPrice = 0.25, 1.25
-	case strings.Contains(m, "claude-opus"):
-		inputPrice, outputPrice = 15.0, 75.0
-	case strings.Contains(m, "claude-sonnet"):
-		inputPrice, outputPrice = 3.0, 15.0
-	case strings.Contains(m, "gpt-4o"):
-		inputPrice, outputPrice = 2.5, 10.0
-	case strings.Contains(m, "gpt-4"):
-		inputPrice, outputPrice = 30.0, 60.0
-	case strings.Contains(m, "gpt-3.5"):
-		inputPrice, outputPrice = 0.5, 1.5
-	}
-
-	return (float64(inputTokens)*inputPrice + float64(outputTokens)*outputPrice) / 1_000_000
+// clientGone reports whether the client has disconnected.
+func clientGone(c *gin.Context) bool {
+	return c.Request.Context().Err() != nil
 }
 
-func (h *Handler) emitUsage(keyInfo interface{}, backendName, model string, statusCode, inputTokens, outputTokens int, latency time.Duration) {
-	if h.collector == nil || keyInfo == nil {
+func (h *Handler) emitUsage(c *gin.Context, backendName, model string, statusCode int, usage tokenUsage, latency time.Duration, clientAborted bool, ttfb time.Duration) {
+	if h.collector == nil {
 		return
 	}
+	keyInfo, _ := c.Get(middleware.CtxKeyInfo)
 	info, ok := keyInfo.(*auth.KeyInfo)
 	if !ok {
 		return
 	}
+	tokens := []attribute.KeyValue{
+		semconv.GenAIUsageInputTokens(usage.Input),
+		semconv.GenAIUsageOutputTokens(usage.Output),
+		attrCacheWrite.Int(usage.CacheCreation),
+		attrCacheRead.Int(usage.CacheRead),
+	}
+	_, span := startSpan(c.Request.Context(), "usage.emit", append(tokens,
+		attrModel.String(model), attrBackend.String(backendName), semconv.HTTPResponseStatusCode(statusCode))...)
+	defer span.End()
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(tokens...)
 
-	total := inputTokens + outputTokens
 	h.collector.Emit(stats.Record{
-		UserID:       info.UserID,
-		APIKeyID:     info.KeyID,
-		Model:        model,
-		Backend:      backendName,
-		InputTokens:  inputTokens,
-		OutputTokens: outputTokens,
-		TotalTokens:  total,
-		CostUSD:      costUSD(model, inputTokens, outputTokens),
-		StatusCode:   statusCode,
-		Latency:      latency,
+		UserID:              info.UserID,
+		APIKeyID:            info.KeyID,
+		Model:               model,
+		RequestedModel:      c.GetString(ctxRequestedModel),
+		Backend:             backendName,
+		InputTokens:         usage.Input,
+		OutputTokens:        usage.Output,
+		CacheCreationTokens: usage.CacheCreation,
+		CacheReadTokens:     usage.CacheRead,
+		CacheWrite1hTokens:  usage.CacheCreation1h,
+		TotalTokens:         usage.Total(),
+		CostUSD:             h.prices.Cost(model, time.Now(), usage.Input, usage.Output, usage.CacheCreation, usage.CacheCreation1h, usage.CacheRead),
+		StatusCode:          statusCode,
+		Latency:             latency,
+		ClientAborted:       clientAborted,
+		TimeToFirstByte:     ttfb,
+		RequestID:           c.GetString(middleware.CtxRequestID),
+		UpstreamRequestID:   c.GetString(middleware.CtxUpstreamRequestID),
+		ClientIP:            c.ClientIP(),
+		UserAgent:           truncate(c.Request.UserAgent(), maxUserAgentLen),
 	})
 }
 
+// maxUserAgentLen bounds the user agent stored with each usage record.
+const maxUserAgentLen = 256
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
 // ChatCompletions handles POST /v1/chat/completions (OpenAI style).
 func (h *Handler) ChatCompletions(c *gin.Context) {
 	h.forward(c, "/v1/chat/completions")
@@ -266,12 +313,13 @@ func (h *Handler) Messages(c *gin.Context) {
 	h.forward(c, "/v1/messages")
 }
 
-// Models handles GET /v1/models.
-func (h *Handler) Models(c *gin.Context) {
-	h.forward(c, "/v1/models")
-}
-
-// Passthrough forwards any other /v1/* path to the upstream backend.
+// Passthrough forwards any other /v1/* path to the upstream backend. Model
+// listing is answered by the gateway itself (see Models).
 func (h *Handler) Passthrough(c *gin.Context) {
-	h.forward(c, "/v1"+c.Param("path"))
+	p := c.Param("path")
+	if c.Request.Method == http.MethodGet && (p == "/models" || strings.HasPrefix(p, "/models/")) {
+		h.Models(c)
+		return
+	}
+	h.forward(c, "/v1"+p)
 }
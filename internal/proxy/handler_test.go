@@ -0,0 +1,242 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/ratelimit"
+)
+
+func newTestRouter(h *proxy.Handler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Any("/v1/*path", h.Passthrough)
+	return r
+}
+
+func TestHandler_RetriesOnDifferentBackend(t *testing.T) {
+	var failing, healthy atomic.Int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthy.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":2}}`))
+	}))
+	defer good.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "bad", URL: bad.URL, APIKey: "k", Weight: 1, Enabled: true},
+		{Name: "good", URL: good.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{})
+	h := proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 2, Timeout: time.Minute}, nil)
+	r := newTestRouter(h)
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200 after failover, got %d", i+1, w.Code)
+		}
+	}
+	if healthy.Load() != 5 {
+		t.Fatalf("expected 5 requests on healthy backend, got %d", healthy.Load())
+	}
+}
+
+func TestHandler_ReturnsLastResponseWhenAttemptsExhausted(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "only", URL: upstream.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{})
+	h := proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 3, Timeout: time.Minute}, nil)
+	r := newTestRouter(h)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected upstream 429 to be passed through, got %d", w.Code)
+	}
+}
+
+func TestHandler_RetriesOverloadedStream(t *testing.T) {
+	overloaded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"))
+	}))
+	defer overloaded.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+	}))
+	defer good.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "overloaded", URL: overloaded.URL, APIKey: "k", Weight: 1, Enabled: true},
+		{Name: "good", URL: good.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{})
+	h := proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 2, Timeout: time.Minute}, nil)
+	r := newTestRouter(h)
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m","stream":true}`))
+		r.ServeHTTP(w, req)
+		if strings.Contains(w.Body.String(), "overloaded_error") {
+			t.Fatalf("request %d: overloaded stream reached the client", i+1)
+		}
+		if !strings.Contains(w.Body.String(), "message_stop") {
+			t.Fatalf("request %d: expected stream from healthy backend, got %q", i+1, w.Body.String())
+		}
+	}
+}
+
+func TestHandler_ClientErrorsDoNotOpenCircuit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "only", URL: upstream.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{FailureThreshold: 2})
+	h := proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 1, Timeout: time.Minute}, nil)
+	r := newTestRouter(h)
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("request %d: expected upstream 400, got %d", i+1, w.Code)
+		}
+	}
+	if h := lb.Backends()[0].Health(); h.State != proxy.StateClosed || h.Errors != 0 {
+		t.Fatalf("expected client errors to be ignored, got %+v", h)
+	}
+}
+
+func TestHandler_SaturatedBackendSpillsOver(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+		w.Header().Set("X-Backend", "primary")
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "secondary")
+	}))
+	defer secondary.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "primary", URL: primary.URL, APIKey: "k", Weight: 1, Enabled: true, Priority: 1, MaxInFlight: 1},
+		{Name: "secondary", URL: secondary.URL, APIKey: "k", Weight: 1, Enabled: true, Priority: 2},
+	}, config.HealthCheckConfig{})
+	lb.SetStrategy(proxy.StrategyPriority)
+	h := proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 1, Timeout: time.Minute}, nil)
+	r := newTestRouter(h)
+	send := func() string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+		r.ServeHTTP(w, req)
+		return w.Header().Get("X-Backend")
+	}
+
+	done := make(chan string)
+	go func() { done <- send() }()
+	<-started
+	if got := send(); got != "secondary" {
+		t.Fatalf("expected request to spill over while primary is saturated, got %q", got)
+	}
+	close(release)
+	if got := <-done; got != "primary" {
+		t.Fatalf("expected first request on primary, got %q", got)
+	}
+	if got := send(); got != "primary" {
+		t.Fatalf("expected primary once its request finished, got %q", got)
+	}
+}
+
+func TestHandler_UnservedModelReturnsNotFound(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not reach the backend")
+	}))
+	defer upstream.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "a", URL: upstream.URL, APIKey: "k", Weight: 1, Enabled: true, Models: []string{"claude-*"}},
+	}, config.HealthCheckConfig{})
+	h := proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 1, Timeout: time.Minute}, nil)
+	r := newTestRouter(h)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"gpt-4o"}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "not_found_error") {
+		t.Fatalf("expected 404 not_found_error, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_KeepsGatewayRateLimitHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("anthropic-ratelimit-requests-limit", "4000")
+		w.Header().Set("anthropic-ratelimit-requests-remaining", "3999")
+		w.Header().Set("anthropic-ratelimit-tokens-limit", "400000")
+		w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "only", URL: upstream.URL, APIKey: "k", Weight: 1, Enabled: true},
+	}, config.HealthCheckConfig{})
+	h := proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 1, Timeout: time.Minute}, nil)
+	limiter := ratelimit.NewLimiter(ratelimit.Limits{}, ratelimit.Limits{RequestsPerMinute: 5})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(middleware.CtxKeyInfo, &auth.KeyInfo{KeyID: 1, UserID: 1})
+	})
+	r.Use(middleware.APIRateLimit(limiter))
+	r.Any("/v1/*path", h.Passthrough)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"m"}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("anthropic-ratelimit-requests-limit"); got != "5" {
+		t.Fatalf("expected the gateway's requests limit 5, got %q", got)
+	}
+	if got := w.Header().Get("anthropic-ratelimit-requests-remaining"); got != "4" {
+		t.Fatalf("expected the gateway's remaining requests 4, got %q", got)
+	}
+	if got := w.Header().Get("anthropic-ratelimit-tokens-limit"); got != "" {
+		t.Fatalf("expected the backend's token limit to be dropped, got %q", got)
+	}
+}
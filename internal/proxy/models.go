@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+)
+
+// modelPageSize is the page size requested from GET /v1/models. Anthropic
+// defaults to 20 and caps at 1000; OpenAI-compatible servers ignore it.
+const modelPageSize = 1000
+
+// ModelInfo describes a model listed by a backend.
+type ModelInfo struct {
+	ID          string
+	DisplayName string
+	CreatedAt   time.Time // zero if the backend did not say
+	OwnedBy     string
+}
+
+// DiscoveredModels returns the model IDs the backend listed, sorted, or nil
+// if they have not been discovered.
+func (b *Backend) DiscoveredModels() []string {
+	b.modelsMu.RLock()
+	defer b.modelsMu.RUnlock()
+	if b.discovered == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(b.discovered))
+	for id := range b.discovered {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// models returns the discovered models, or nil if they have not been discovered.
+func (b *Backend) models() []ModelInfo {
+	b.modelsMu.RLock()
+	defer b.modelsMu.RUnlock()
+	if b.discovered == nil {
+		return nil
+	}
+	models := make([]ModelInfo, 0, len(b.discovered))
+	for _, m := range b.discovered {
+		models = append(models, m)
+	}
+	return models
+}
+
+func (b *Backend) setModels(models []ModelInfo) {
+	if models == nil {
+		return
+	}
+	set := make(map[string]ModelInfo, len(models))
+	for _, m := range models {
+		set[m.ID] = m
+	}
+	b.modelsMu.Lock()
+	changed := len(set) != len(b.discovered)
+	for id := range set {
+		if _, ok := b.discovered[id]; !ok {
+			changed = true
+		}
+	}
+	b.discovered = set
+	b.modelsMu.Unlock()
+	if changed {
+		logger.Infof("backend %s serves %d models", b.Name, len(set))
+	}
+}
+
+// Models returns the models served by backends whose circuit is not open,
+// merged by ID: each backend's discovered models plus the exact names in its
+// allowlist.
+func (lb *LoadBalancer) Models() []ModelInfo {
+	byID := make(map[string]ModelInfo)
+	add := func(m ModelInfo) {
+		prev, ok := byID[m.ID]
+		if !ok {
+			byID[m.ID] = m
+			return
+		}
+		// Backends list the same model with different detail; keep what is known.
+		if prev.DisplayName == "" {
+			prev.DisplayName = m.DisplayName
+		}
+		if prev.CreatedAt.IsZero() {
+			prev.CreatedAt = m.CreatedAt
+		}
+		if prev.OwnedBy == "" {
+			prev.OwnedBy = m.OwnedBy
+		}
+		byID[m.ID] = prev
+	}
+	for _, b := range lb.Backends() {
+		if b.breaker.snapshot().State == StateOpen {
+			continue
+		}
+		for _, m := range b.models() {
+			if b.Serves(m.ID) {
+				add(m)
+			}
+		}
+		for _, p := range b.Models {
+			if !strings.ContainsAny(p, `*?[\`) {
+				add(ModelInfo{ID: p})
+			}
+		}
+	}
+	models := make([]ModelInfo, 0, len(byID))
+	for _, m := range byID {
+		models = append(models, m)
+	}
+	return models
+}
+
+// isSnapshotOf reports whether id is a dated snapshot of alias, e.g.
+// claude-sonnet-4-5-20250929 for claude-sonnet-4-5 or claude-3-5-haiku-latest.
+// Anthropic lists only snapshots but accepts these aliases.
+func isSnapshotOf(id, alias string) bool {
+	alias = strings.TrimSuffix(alias, "-latest")
+	date, ok := strings.CutPrefix(id, alias+"-")
+	if !ok || len(date) != 8 {
+		return false
+	}
+	for _, r := range date {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// modelLoop refreshes every backend's model list each refresh interval.
+func (lb *LoadBalancer) modelLoop() {
+	ticker := time.NewTicker(lb.health.ModelRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		lb.RefreshModels()
+	}
+}
+
+// RefreshModels fetches every backend's model list in parallel. A backend
+// whose list cannot be fetched keeps the models it had.
+func (lb *LoadBalancer) RefreshModels() {
+	var wg sync.WaitGroup
+	for _, b := range lb.Backends() {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			lb.refreshModels(b)
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (lb *LoadBalancer) refreshModels(b *Backend) {
+	timeout := lb.health.ProbeTimeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	models, err := fetchModels(ctx, b)
+	if err != nil {
+		logger.Warnf("backend %s: discover models: %v", b.Name, err)
+		return
+	}
+	b.setModels(models)
+}
+
+// fetchModels lists the backend's models with GET /v1/models, following
+// Anthropic-style pagination. The Anthropic and OpenAI list formats share the
+// data[].id field; the rest is read from whichever format the backend uses.
+func fetchModels(ctx context.Context, b *Backend) ([]ModelInfo, error) {
+	models := []ModelInfo{}
+	afterID := ""
+	for {
+		u := fmt.Sprintf("%s/v1/models?limit=%d", strings.TrimRight(b.URL, "/"), modelPageSize)
+		if afterID != "" {
+			u += "&after_id=" + url.QueryEscape(afterID)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		setBackendAuth(req, b)
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Data []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"display_name"` // Anthropic
+				CreatedAt   string `json:"created_at"`   // Anthropic, RFC 3339
+				Created     int64  `json:"created"`      // OpenAI, Unix seconds
+				OwnedBy     string `json:"owned_by"`     // OpenAI
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET /v1/models returned HTTP %d", resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode model list: %w", err)
+		}
+		for _, d := range page.Data {
+			if d.ID == "" {
+				continue
+			}
+			m := ModelInfo{ID: d.ID, DisplayName: d.DisplayName, OwnedBy: d.OwnedBy}
+			if t, err := time.Parse(time.RFC3339, d.CreatedAt); err == nil {
+				m.CreatedAt = t
+			} else if d.Created > 0 {
+				m.CreatedAt = time.Unix(d.Created, 0)
+			}
+			models = append(models, m)
+		}
+		if !page.HasMore || page.LastID == "" || page.LastID == afterID {
+			return models, nil
+		}
+		afterID = page.LastID
+	}
+}
@@ -0,0 +1,645 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// defaultMaxTokens is sent to Anthropic when an OpenAI request sets no limit,
+// since max_tokens is mandatory in the Messages API.
+const defaultMaxTokens = 4096
+
+// Wire types for the OpenAI Chat Completions API.
+
+type openAIChatRequest struct {
+	Model               string          `json:"model"`
+	Messages            []openAIMessage `json:"messages"`
+	MaxTokens           *int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"`
+	Stop                json.RawMessage `json:"stop,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	Stream              bool            `json:"stream,omitempty"`
+	StreamOptions       *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	Tools             []openAITool    `json:"tools,omitempty"`
+	ToolChoice        json.RawMessage `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	User              string          `json:"user,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content"`
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// openAIContentPart is one element of an array-form message content.
+type openAIContentPart struct {
+	Type     string `json:"type"` // text | image_url
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details,omitempty"`
+}
+
+type openAIResponseMessage struct {
+	Role      string           `json:"role"`
+	Content   *string          `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int                   `json:"index"`
+	Message      openAIResponseMessage `json:"message"`
+	FinishReason string                `json:"finish_reason"`
+}
+
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIDelta struct {
+	Role      string           `json:"role,omitempty"`
+	Content   *string          `json:"content,omitempty"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIChunkChoice struct {
+	Index        int         `json:"index"`
+	Delta        openAIDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type openAIChunk struct {
+	ID      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []openAIChunkChoice `json:"choices"`
+	Usage   *openAIUsage        `json:"usage,omitempty"`
+}
+
+// openAIToAnthropicRequest converts a Chat Completions request body into a
+// Messages API request body. It also reports whether the client asked for a
+// usage chunk at the end of the stream.
+func openAIToAnthropicRequest(body []byte) ([]byte, bool, error) {
+	var req openAIChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false, fmt.Errorf("parse chat completions request: %w", err)
+	}
+
+	out := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   defaultMaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+	}
+	if req.MaxCompletionTokens != nil {
+		out.MaxTokens = *req.MaxCompletionTokens
+	} else if req.MaxTokens != nil {
+		out.MaxTokens = *req.MaxTokens
+	}
+	// OpenAI accepts temperatures up to 2, Anthropic up to 1.
+	if out.Temperature != nil && *out.Temperature > 1 {
+		one := 1.0
+		out.Temperature = &one
+	}
+	if req.User != "" {
+		out.Metadata = &anthropicMetadata{UserID: req.User}
+	}
+
+	stop, err := parseStop(req.Stop)
+	if err != nil {
+		return nil, false, err
+	}
+	out.StopSequences = stop
+
+	var system []string
+	for i, m := range req.Messages {
+		switch m.Role {
+		case "system", "developer":
+			text, err := openAIContentText(m.Content)
+			if err != nil {
+				return nil, false, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			system = append(system, text)
+		case "user":
+			blocks, err := openAIUserBlocks(m.Content)
+			if err != nil {
+				return nil, false, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			out.Messages = appendAnthropicMessage(out.Messages, "user", blocks)
+		case "assistant":
+			blocks, err := openAIAssistantBlocks(m)
+			if err != nil {
+				return nil, false, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			out.Messages = appendAnthropicMessage(out.Messages, "assistant", blocks)
+		case "tool", "function":
+			text, err := openAIContentText(m.Content)
+			if err != nil {
+				return nil, false, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			content, _ := json.Marshal(text)
+			out.Messages = appendAnthropicMessage(out.Messages, "user", []anthropicBlock{{
+				Type:      "tool_result",
+				ToolUseID: m.ToolCallID,
+				Content:   content,
+			}})
+		default:
+			return nil, false, fmt.Errorf("messages[%d]: unsupported role %q", i, m.Role)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, t := range req.Tools {
+		if t.Type != "" && t.Type != "function" {
+			continue
+		}
+		schema := t.Function.Parameters
+		if len(schema) == 0 || string(schema) == "null" {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: schema,
+		})
+	}
+	if out.ToolChoice, err = openAIToolChoice(req.ToolChoice, req.ParallelToolCalls); err != nil {
+		return nil, false, err
+	}
+
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	data, err := json.Marshal(out)
+	return data, includeUsage, err
+}
+
+// appendAnthropicMessage adds blocks as a message with role, merging into the
+// previous message when it has the same role so turns keep alternating.
+func appendAnthropicMessage(msgs []anthropicMessage, role string, blocks []anthropicBlock) []anthropicMessage {
+	if len(blocks) == 0 {
+		return msgs
+	}
+	if n := len(msgs); n > 0 && msgs[n-1].Role == role {
+		msgs[n-1].Content = append(msgs[n-1].Content, blocks...)
+		return msgs
+	}
+	return append(msgs, anthropicMessage{Role: role, Content: blocks})
+}
+
+// parseStop accepts the OpenAI stop parameter as a string or an array of strings.
+func parseStop(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, fmt.Errorf("stop must be a string or an array of strings")
+	}
+	return many, nil
+}
+
+// openAIContentParts normalizes message content, which may be a plain string or
+// an array of parts.
+func openAIContentParts(raw json.RawMessage) ([]openAIContentPart, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []openAIContentPart{{Type: "text", Text: text}}, nil
+	}
+	var parts []openAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("content must be a string or an array of content parts")
+	}
+	return parts, nil
+}
+
+// openAIContentText concatenates the text parts of a message content.
+func openAIContentText(raw json.RawMessage) (string, error) {
+	parts, err := openAIContentParts(raw)
+	if err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func openAIUserBlocks(raw json.RawMessage) ([]anthropicBlock, error) {
+	parts, err := openAIContentParts(raw)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []anthropicBlock
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			if p.Text != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: p.Text})
+			}
+		case "image_url":
+			if p.ImageURL == nil {
+				continue
+			}
+			src, err := imageSource(p.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: src})
+		}
+	}
+	return blocks, nil
+}
+
+// imageSource converts an OpenAI image URL, either a data: URL or a remote URL,
+// into an Anthropic image source.
+func imageSource(url string) (*anthropicImageSource, error) {
+	if !strings.HasPrefix(url, "data:") {
+		return &anthropicImageSource{Type: "url", URL: url}, nil
+	}
+	// data:<media type>;base64,<data>
+	meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, fmt.Errorf("image data URLs must be base64 encoded")
+	}
+	return &anthropicImageSource{
+		Type:      "base64",
+		MediaType: strings.TrimSuffix(meta, ";base64"),
+		Data:      data,
+	}, nil
+}
+
+func openAIAssistantBlocks(m openAIMessage) ([]anthropicBlock, error) {
+	text, err := openAIContentText(m.Content)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []anthropicBlock
+	if text != "" {
+		blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+	}
+	for _, tc := range m.ToolCalls {
+		input := json.RawMessage(tc.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage(`{}`)
+		}
+		blocks = append(blocks, anthropicBlock{
+			Type:  "tool_use",
+			ID:    tc.ID,
+			Name:  tc.Function.Name,
+			Input: input,
+		})
+	}
+	return blocks, nil
+}
+
+// openAIToolChoice maps tool_choice ("none" | "auto" | "required" | {function}) and
+// parallel_tool_calls onto the Anthropic tool_choice object.
+func openAIToolChoice(raw json.RawMessage, parallel *bool) (*anthropicToolChoice, error) {
+	var choice *anthropicToolChoice
+	if len(raw) > 0 && string(raw) != "null" {
+		var mode string
+		if err := json.Unmarshal(raw, &mode); err == nil {
+			switch mode {
+			case "none":
+				choice = &anthropicToolChoice{Type: "none"}
+			case "auto":
+				choice = &anthropicToolChoice{Type: "auto"}
+			case "required":
+				choice = &anthropicToolChoice{Type: "any"}
+			default:
+				return nil, fmt.Errorf("unsupported tool_choice %q", mode)
+			}
+		} else {
+			var named struct {
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			}
+			if err := json.Unmarshal(raw, &named); err != nil || named.Function.Name == "" {
+				return nil, fmt.Errorf("tool_choice must be a string or a function selector")
+			}
+			choice = &anthropicToolChoice{Type: "tool", Name: named.Function.Name}
+		}
+	}
+	if parallel != nil && !*parallel {
+		if choice == nil {
+			choice = &anthropicToolChoice{Type: "auto"}
+		}
+		if choice.Type != "none" {
+			choice.DisableParallelToolUse = true
+		}
+	}
+	return choice, nil
+}
+
+// openAIFinishReason maps an Anthropic stop_reason onto an OpenAI finish_reason.
+func openAIFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+func openAIUsageFrom(u anthropicUsage) *openAIUsage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	usage := &openAIUsage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+	}
+	if u.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &struct {
+			CachedTokens int `json:"cached_tokens"`
+		}{CachedTokens: u.CacheReadInputTokens}
+	}
+	return usage
+}
+
+// openAICompletionID derives a chat completion ID from an Anthropic message ID.
+func openAICompletionID(messageID string) string {
+	return "chatcmpl-" + strings.TrimPrefix(messageID, "msg_")
+}
+
+// anthropicToOpenAIResponse converts a non-streaming Messages API response body
+// into a Chat Completions response body. Error bodies are converted into the
+// OpenAI error shape.
+func anthropicToOpenAIResponse(body []byte, statusCode int) ([]byte, error) {
+	if statusCode >= 400 {
+		return anthropicToOpenAIError(body), nil
+	}
+
+	var resp anthropicResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse messages response: %w", err)
+	}
+
+	msg := openAIResponseMessage{Role: "assistant"}
+	var texts []string
+	for _, b := range resp.Content {
+		switch b.Type {
+		case "text":
+			texts = append(texts, b.Text)
+		case "tool_use":
+			tc := openAIToolCall{ID: b.ID, Type: "function"}
+			tc.Function.Name = b.Name
+			tc.Function.Arguments = compactJSON(b.Input)
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+	}
+	if len(texts) > 0 || len(msg.ToolCalls) == 0 {
+		text := strings.Join(texts, "")
+		msg.Content = &text
+	}
+
+	return json.Marshal(openAIChatResponse{
+		ID:      openAICompletionID(resp.ID),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   resp.Model,
+		Choices: []openAIChoice{{
+			Index:        0,
+			Message:      msg,
+			FinishReason: openAIFinishReason(resp.StopReason),
+		}},
+		Usage: openAIUsageFrom(resp.Usage),
+	})
+}
+
+// compactJSON renders a tool input as a compact JSON string, "{}" when absent.
+func compactJSON(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if len(raw) == 0 || json.Compact(&buf, raw) != nil {
+		return "{}"
+	}
+	return buf.String()
+}
+
+// anthropicToOpenAIError converts an Anthropic error body into the OpenAI error shape.
+func anthropicToOpenAIError(body []byte) []byte {
+	var e anthropicError
+	msg, typ := strings.TrimSpace(string(body)), "api_error"
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		msg, typ = e.Error.Message, e.Error.Type
+	}
+	out, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": msg,
+			"type":    typ,
+			"code":    typ,
+		},
+	})
+	return out
+}
+
+// openAIStreamTranslator turns an Anthropic Messages event stream into
+// chat.completion.chunk events.
+type openAIStreamTranslator struct {
+	w            *sseWriter
+	includeUsage bool
+
+	id        string
+	model     string
+	created   int64
+	usage     anthropicUsage
+	toolIndex map[int]int // Anthropic content block index -> OpenAI tool call index
+}
+
+func newOpenAIStreamTranslator(w io.Writer, includeUsage bool) *openAIStreamTranslator {
+	return &openAIStreamTranslator{
+		w:            newSSEWriter(w),
+		includeUsage: includeUsage,
+		created:      time.Now().Unix(),
+		toolIndex:    make(map[int]int),
+	}
+}
+
+// Usage returns the token usage seen so far.
+func (t *openAIStreamTranslator) Usage() anthropicUsage { return t.usage }
+
+// Translate consumes every event from r and writes the translated stream.
+func (t *openAIStreamTranslator) Translate(r io.Reader) error {
+	sr := newSSEReader(r)
+	for {
+		ev, err := sr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		done, err := t.handle(ev.Data)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// handle translates one Anthropic event. It reports true once the stream is complete.
+func (t *openAIStreamTranslator) handle(data []byte) (bool, error) {
+	var ev anthropicStreamEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return false, nil
+	}
+
+	switch ev.Type {
+	case "message_start":
+		if ev.Message != nil {
+			t.id = openAICompletionID(ev.Message.ID)
+			t.model = ev.Message.Model
+			t.usage = ev.Message.Usage
+		}
+		empty := ""
+		return false, t.writeChunk(openAIDelta{Role: "assistant", Content: &empty}, nil)
+
+	case "content_block_start":
+		if ev.ContentBlock == nil || ev.ContentBlock.Type != "tool_use" {
+			return false, nil
+		}
+		idx := len(t.toolIndex)
+		t.toolIndex[ev.Index] = idx
+		tc := openAIToolCall{Index: &idx, ID: ev.ContentBlock.ID, Type: "function"}
+		tc.Function.Name = ev.ContentBlock.Name
+		return false, t.writeChunk(openAIDelta{ToolCalls: []openAIToolCall{tc}}, nil)
+
+	case "content_block_delta":
+		if ev.Delta == nil {
+			return false, nil
+		}
+		switch ev.Delta.Type {
+		case "text_delta":
+			text := ev.Delta.Text
+			return false, t.writeChunk(openAIDelta{Content: &text}, nil)
+		case "input_json_delta":
+			idx, ok := t.toolIndex[ev.Index]
+			if !ok {
+				return false, nil
+			}
+			tc := openAIToolCall{Index: &idx}
+			tc.Function.Arguments = ev.Delta.PartialJSON
+			return false, t.writeChunk(openAIDelta{ToolCalls: []openAIToolCall{tc}}, nil)
+		}
+		return false, nil
+
+	case "message_delta":
+		if ev.Usage != nil {
+			t.mergeUsage(*ev.Usage)
+		}
+		if ev.Delta == nil || ev.Delta.StopReason == "" {
+			return false, nil
+		}
+		reason := openAIFinishReason(ev.Delta.StopReason)
+		return false, t.writeChunk(openAIDelta{}, &reason)
+
+	case "message_stop":
+		if t.includeUsage {
+			chunk := t.chunk()
+			chunk.Choices = []openAIChunkChoice{}
+			chunk.Usage = openAIUsageFrom(t.usage)
+			if err := t.w.WriteEvent("", chunk); err != nil {
+				return true, err
+			}
+		}
+		return true, t.w.WriteRaw("", []byte("[DONE]"))
+
+	case "error":
+		if ev.Error == nil {
+			return true, nil
+		}
+		body, _ := json.Marshal(map[string]interface{}{"type": "error", "error": ev.Error})
+		return true, t.w.WriteRaw("", anthropicToOpenAIError(body))
+	}
+	return false, nil
+}
+
+// mergeUsage folds a message_delta usage into the totals. message_delta carries
+// cumulative counts, and fields it omits keep their message_start values.
+func (t *openAIStreamTranslator) mergeUsage(u anthropicUsage) {
+	if u.InputTokens > 0 {
+		t.usage.InputTokens = u.InputTokens
+	}
+	if u.CacheCreationInputTokens > 0 {
+		t.usage.CacheCreationInputTokens = u.CacheCreationInputTokens
+		t.usage.CacheCreation = u.CacheCreation
+	}
+	if u.CacheReadInputTokens > 0 {
+		t.usage.CacheReadInputTokens = u.CacheReadInputTokens
+	}
+	if u.OutputTokens > 0 {
+		t.usage.OutputTokens = u.OutputTokens
+	}
+}
+
+func (t *openAIStreamTranslator) chunk() openAIChunk {
+	return openAIChunk{
+		ID:      t.id,
+		Object:  "chat.completion.chunk",
+		Created: t.created,
+		Model:   t.model,
+	}
+}
+
+func (t *openAIStreamTranslator) writeChunk(delta openAIDelta, finishReason *string) error {
+	chunk := t.chunk()
+	chunk.Choices = []openAIChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}}
+	return t.w.WriteEvent("", chunk)
+}
@@ -0,0 +1,518 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// anthropicToOpenAIRequest converts a Messages API request body into a Chat
+// Completions request body for an OpenAI-compatible backend.
+func anthropicToOpenAIRequest(body []byte) ([]byte, error) {
+	var req anthropicInboundRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("parse messages request: %w", err)
+	}
+
+	out := openAIChatRequest{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+	}
+	if req.MaxTokens > 0 {
+		maxTokens := req.MaxTokens
+		out.MaxTokens = &maxTokens
+	}
+	if len(req.StopSequences) > 0 {
+		out.Stop, _ = json.Marshal(req.StopSequences)
+	}
+	if req.Stream {
+		out.StreamOptions = &struct {
+			IncludeUsage bool `json:"include_usage"`
+		}{IncludeUsage: true}
+	}
+	if req.Metadata != nil {
+		out.User = req.Metadata.UserID
+	}
+
+	system, err := anthropicText(req.System)
+	if err != nil {
+		return nil, fmt.Errorf("system: %w", err)
+	}
+	if system != "" {
+		out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: jsonString(system)})
+	}
+
+	for i, m := range req.Messages {
+		blocks, err := parseAnthropicBlocks(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		var msgs []openAIMessage
+		switch m.Role {
+		case "user":
+			msgs, err = anthropicUserMessages(blocks)
+		case "assistant":
+			msgs = []openAIMessage{anthropicAssistantMessage(blocks)}
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, m.Role)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		out.Messages = append(out.Messages, msgs...)
+	}
+
+	for _, t := range req.Tools {
+		// Server tools (web search, code execution, ...) only exist on Anthropic.
+		if t.Type != "" && t.Type != "custom" {
+			continue
+		}
+		var ot openAITool
+		ot.Type = "function"
+		ot.Function.Name = t.Name
+		ot.Function.Description = t.Description
+		ot.Function.Parameters = t.InputSchema
+		out.Tools = append(out.Tools, ot)
+	}
+	if len(out.Tools) > 0 && req.ToolChoice != nil {
+		out.ToolChoice, out.ParallelToolCalls = anthropicToolChoiceToOpenAI(req.ToolChoice)
+	}
+
+	return json.Marshal(out)
+}
+
+// anthropicUserMessages converts a user turn. tool_result blocks become tool
+// messages, which must directly follow the assistant's tool calls, so they are
+// emitted before the remaining user content.
+func anthropicUserMessages(blocks []anthropicBlock) ([]openAIMessage, error) {
+	var msgs []openAIMessage
+	var parts []openAIContentPart
+	hasImage := false
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			parts = append(parts, openAIContentPart{Type: "text", Text: b.Text})
+		case "image":
+			if b.Source == nil {
+				return nil, fmt.Errorf("image block without source")
+			}
+			url := b.Source.URL
+			if b.Source.Type == "base64" {
+				url = "data:" + b.Source.MediaType + ";base64," + b.Source.Data
+			}
+			part := openAIContentPart{Type: "image_url"}
+			part.ImageURL = &struct {
+				URL string `json:"url"`
+			}{URL: url}
+			parts = append(parts, part)
+			hasImage = true
+		case "tool_result":
+			text, err := anthropicText(b.Content)
+			if err != nil {
+				return nil, fmt.Errorf("tool_result: %w", err)
+			}
+			msgs = append(msgs, openAIMessage{Role: "tool", ToolCallID: b.ToolUseID, Content: jsonString(text)})
+		}
+	}
+	if len(parts) == 0 {
+		return msgs, nil
+	}
+
+	msg := openAIMessage{Role: "user"}
+	if hasImage {
+		msg.Content, _ = json.Marshal(parts)
+	} else {
+		// Plain string content is accepted by every OpenAI-compatible server.
+		texts := make([]string, len(parts))
+		for i, p := range parts {
+			texts[i] = p.Text
+		}
+		msg.Content = jsonString(strings.Join(texts, "\n"))
+	}
+	return append(msgs, msg), nil
+}
+
+// anthropicAssistantMessage converts an assistant turn; tool_use blocks become tool calls.
+func anthropicAssistantMessage(blocks []anthropicBlock) openAIMessage {
+	msg := openAIMessage{Role: "assistant"}
+	var texts []string
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			texts = append(texts, b.Text)
+		case "tool_use":
+			tc := openAIToolCall{ID: b.ID, Type: "function"}
+			tc.Function.Name = b.Name
+			tc.Function.Arguments = compactJSON(b.Input)
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+	}
+	if len(texts) > 0 || len(msg.ToolCalls) == 0 {
+		msg.Content = jsonString(strings.Join(texts, ""))
+	} else {
+		msg.Content = json.RawMessage("null")
+	}
+	return msg
+}
+
+// anthropicToolChoiceToOpenAI maps an Anthropic tool_choice onto the OpenAI
+// tool_choice and parallel_tool_calls fields.
+func anthropicToolChoiceToOpenAI(tc *anthropicToolChoice) (json.RawMessage, *bool) {
+	var choice json.RawMessage
+	switch tc.Type {
+	case "any":
+		choice = jsonString("required")
+	case "none":
+		choice = jsonString("none")
+	case "tool":
+		choice, _ = json.Marshal(map[string]interface{}{
+			"type":     "function",
+			"function": map[string]string{"name": tc.Name},
+		})
+	default:
+		choice = jsonString("auto")
+	}
+	if tc.DisableParallelToolUse {
+		parallel := false
+		return choice, &parallel
+	}
+	return choice, nil
+}
+
+func jsonString(s string) json.RawMessage {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// anthropicStopReason maps an OpenAI finish_reason onto an Anthropic stop_reason.
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// anthropicUsageFrom converts OpenAI usage. Anthropic reports cached prompt
+// tokens separately from input_tokens.
+func anthropicUsageFrom(u *openAIUsage) anthropicUsage {
+	if u == nil {
+		return anthropicUsage{}
+	}
+	out := anthropicUsage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens}
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		out.CacheReadInputTokens = u.PromptTokensDetails.CachedTokens
+		out.InputTokens -= u.PromptTokensDetails.CachedTokens
+	}
+	return out
+}
+
+// anthropicMessageID derives a Messages API id from a chat completion id.
+func anthropicMessageID(completionID string) string {
+	return "msg_" + strings.TrimPrefix(completionID, "chatcmpl-")
+}
+
+// anthropicToolInput returns tool call arguments as a JSON object, "{}" when
+// the backend produced nothing parseable.
+func anthropicToolInput(arguments string) json.RawMessage {
+	var buf bytes.Buffer
+	if json.Compact(&buf, []byte(arguments)) != nil || buf.Len() == 0 {
+		return json.RawMessage("{}")
+	}
+	return buf.Bytes()
+}
+
+// openAIToAnthropicResponse converts a non-streaming Chat Completions response
+// body into a Messages API response body. Error bodies are converted into the
+// Anthropic error shape.
+func openAIToAnthropicResponse(body []byte, statusCode int) ([]byte, error) {
+	if statusCode >= 400 {
+		return openAIToAnthropicError(body, statusCode), nil
+	}
+
+	var resp openAIChatResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse chat completions response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("chat completions response has no choices")
+	}
+
+	choice := resp.Choices[0]
+	content := []map[string]interface{}{}
+	if choice.Message.Content != nil && *choice.Message.Content != "" {
+		content = append(content, map[string]interface{}{"type": "text", "text": *choice.Message.Content})
+	}
+	for i, tc := range choice.Message.ToolCalls {
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    toolUseID(tc.ID, i),
+			"name":  tc.Function.Name,
+			"input": anthropicToolInput(tc.Function.Arguments),
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":            anthropicMessageID(resp.ID),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         resp.Model,
+		"content":       content,
+		"stop_reason":   anthropicStopReason(choice.FinishReason),
+		"stop_sequence": nil,
+		"usage":         anthropicUsageFrom(resp.Usage),
+	})
+}
+
+// toolUseID returns the backend's tool call id, or a generated one when it sent none.
+func toolUseID(id string, index int) string {
+	if id != "" {
+		return id
+	}
+	return fmt.Sprintf("toolu_%d", index)
+}
+
+// openAIToAnthropicError converts an OpenAI error body into the Anthropic error
+// shape. Besides {"error":{...}}, the flat {"message":...} form used by vLLM is understood.
+func openAIToAnthropicError(body []byte, statusCode int) []byte {
+	var e struct {
+		Message string `json:"message"`
+		Error   *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &e) == nil {
+		if e.Error != nil && e.Error.Message != "" {
+			msg = e.Error.Message
+		} else if e.Message != "" {
+			msg = e.Message
+		}
+	}
+	out, _ := json.Marshal(map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    anthropicErrorType(statusCode),
+			"message": msg,
+		},
+	})
+	return out
+}
+
+// anthropicStreamTranslator turns a chat.completion.chunk stream into the
+// Messages event sequence: message_start, content_block_start/delta/stop per
+// text or tool_use block, message_delta and message_stop.
+type anthropicStreamTranslator struct {
+	w     *sseWriter
+	model string
+
+	started    bool
+	blockOpen  bool
+	blockIndex int         // index of the open block, or of the next one when none is open
+	toolBlocks map[int]int // OpenAI tool call index -> Anthropic content block index
+	stopReason string
+	usage      anthropicUsage
+}
+
+func newAnthropicStreamTranslator(w io.Writer, model string) *anthropicStreamTranslator {
+	return &anthropicStreamTranslator{
+		w:          newSSEWriter(w),
+		model:      model,
+		toolBlocks: make(map[int]int),
+	}
+}
+
+// Usage returns the token usage seen so far.
+func (t *anthropicStreamTranslator) Usage() anthropicUsage { return t.usage }
+
+// Translate consumes every chunk from r and writes the translated stream. The
+// closing events are written when the backend ends the stream, with or without
+// a [DONE] marker.
+func (t *anthropicStreamTranslator) Translate(r io.Reader) error {
+	sr := newSSEReader(r)
+	for {
+		ev, err := sr.Next()
+		if err == io.EOF {
+			return t.finish()
+		}
+		if err != nil {
+			return err
+		}
+		if string(bytes.TrimSpace(ev.Data)) == "[DONE]" {
+			return t.finish()
+		}
+		done, err := t.handle(ev.Data)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// handle translates one chunk. It reports true if the stream ended with an error.
+func (t *anthropicStreamTranslator) handle(data []byte) (bool, error) {
+	var chunk struct {
+		openAIChunk
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return false, nil
+	}
+	if chunk.Error != nil {
+		return true, t.w.WriteEvent("error", map[string]interface{}{
+			"type":  "error",
+			"error": map[string]string{"type": "api_error", "message": chunk.Error.Message},
+		})
+	}
+
+	if err := t.start(chunk.ID, chunk.Model); err != nil {
+		return false, err
+	}
+	if chunk.Usage != nil {
+		t.usage = anthropicUsageFrom(chunk.Usage)
+	}
+	if len(chunk.Choices) == 0 {
+		return false, nil
+	}
+
+	choice := chunk.Choices[0]
+	if choice.Delta.Content != nil && *choice.Delta.Content != "" {
+		if err := t.openBlock(map[string]interface{}{"type": "text", "text": ""}, -1); err != nil {
+			return false, err
+		}
+		if err := t.writeDelta(map[string]interface{}{"type": "text_delta", "text": *choice.Delta.Content}); err != nil {
+			return false, err
+		}
+	}
+	for _, tc := range choice.Delta.ToolCalls {
+		idx := 0
+		if tc.Index != nil {
+			idx = *tc.Index
+		}
+		if _, ok := t.toolBlocks[idx]; !ok {
+			err := t.openBlock(map[string]interface{}{
+				"type":  "tool_use",
+				"id":    toolUseID(tc.ID, idx),
+				"name":  tc.Function.Name,
+				"input": map[string]interface{}{},
+			}, idx)
+			if err != nil {
+				return false, err
+			}
+		}
+		// Arguments of a tool call whose block was already closed cannot be sent.
+		if tc.Function.Arguments == "" || !t.blockOpen || t.toolBlocks[idx] != t.blockIndex {
+			continue
+		}
+		if err := t.writeDelta(map[string]interface{}{"type": "input_json_delta", "partial_json": tc.Function.Arguments}); err != nil {
+			return false, err
+		}
+	}
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		t.stopReason = anthropicStopReason(*choice.FinishReason)
+	}
+	return false, nil
+}
+
+// start writes message_start before the first translated event.
+func (t *anthropicStreamTranslator) start(id, model string) error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+	if model == "" {
+		model = t.model
+	}
+	return t.w.WriteEvent("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            anthropicMessageID(id),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         anthropicUsage{},
+		},
+	})
+}
+
+// openBlock closes the open block and starts a new one. toolIndex is the OpenAI
+// tool call index for tool_use blocks, -1 for text. A text block is reused while
+// it is still open.
+func (t *anthropicStreamTranslator) openBlock(block map[string]interface{}, toolIndex int) error {
+	if toolIndex < 0 && t.blockOpen && t.blockIsText() {
+		return nil
+	}
+	if err := t.closeBlock(); err != nil {
+		return err
+	}
+	if toolIndex >= 0 {
+		t.toolBlocks[toolIndex] = t.blockIndex
+	}
+	t.blockOpen = true
+	return t.w.WriteEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         t.blockIndex,
+		"content_block": block,
+	})
+}
+
+func (t *anthropicStreamTranslator) blockIsText() bool {
+	for _, idx := range t.toolBlocks {
+		if idx == t.blockIndex {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *anthropicStreamTranslator) closeBlock() error {
+	if !t.blockOpen {
+		return nil
+	}
+	t.blockOpen = false
+	index := t.blockIndex
+	t.blockIndex++
+	return t.w.WriteEvent("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+}
+
+func (t *anthropicStreamTranslator) writeDelta(delta map[string]interface{}) error {
+	return t.w.WriteEvent("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": delta,
+	})
+}
+
+// finish writes the closing events with the final stop reason and usage.
+func (t *anthropicStreamTranslator) finish() error {
+	if err := t.start("", ""); err != nil {
+		return err
+	}
+	if err := t.closeBlock(); err != nil {
+		return err
+	}
+	stopReason := t.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	err := t.w.WriteEvent("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": t.usage,
+	})
+	if err != nil {
+		return err
+	}
+	return t.w.WriteEvent("message_stop", map[string]interface{}{"type": "message_stop"})
+}
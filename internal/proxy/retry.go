@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+)
+
+// maxPeekBytes bounds how much of a streaming response is inspected for an
+// early error event before it is handed to the client.
+const maxPeekBytes = 16 * 1024
+
+// roundTrip sends the request upstream, failing over to a different backend on
+// connection errors, 5xx, 429 and overloaded responses until an attempt succeeds,
+// the attempt budget or deadline is spent, or no untried backend is left. Nothing
+// is written to the client before the returned response is chosen, so retries are
+// invisible to it.
+//
+// On success the caller owns resp.Body. If resp is nil an error has already been
+// written to the client.
+func (h *Handler) roundTrip(c *gin.Context, upReq *upstreamRequest, model string) (*http.Response, *Backend, translation) {
+	maxAttempts := h.retry.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	deadline := time.Now().Add(h.retry.Timeout)
+
+	// Attempts are child spans of one upstream span.
+	ctx, span := startSpan(c.Request.Context(), "upstream", attrModel.String(model))
+	defer span.End()
+
+	backend := h.pick(ctx, model, nil)
+	if backend == nil {
+		span.SetStatus(codes.Error, "no backend")
+		if !h.lb.HasModel(model) {
+			middleware.AbortWithAPIError(c, http.StatusNotFound, "not_found_error", fmt.Sprintf("model %q is not served by any backend", model))
+			return nil, nil, translateNone
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "no available backend"})
+		return nil, nil, translateNone
+	}
+	tried := make(map[*Backend]bool)
+
+	for attempt := 1; ; attempt++ {
+		tried[backend.Backend] = true
+		path, body, tr, err := upReq.prepare(backend.Backend)
+		if err != nil {
+			backend.release()
+			middleware.AbortWithAPIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return nil, nil, tr
+		}
+		attemptStart := time.Now()
+		attemptCtx, attemptSpan := startSpan(ctx, "upstream.attempt", attrBackend.String(backend.Name), attrAttempt.Int(attempt))
+		resp, err := h.sendOnce(attemptCtx, c, backend.Backend, path, body, tr)
+		if resp != nil {
+			c.Set(middleware.CtxUpstreamRequestID, upstreamRequestID(resp.Header))
+		} else {
+			c.Set(middleware.CtxUpstreamRequestID, "")
+		}
+
+		retryable := err != nil || isRetryableResponse(resp)
+		o := classify(c.Request.Context(), resp, err, retryable)
+		backend.recordOutcome(o, failureReason(resp, err))
+		if o == outcomeSuccess {
+			backend.observeLatency(time.Since(attemptStart))
+		}
+		endAttemptSpan(attemptSpan, resp, err, retryable)
+
+		var next *Lease
+		if retryable && attempt < maxAttempts && time.Now().Before(deadline) && c.Request.Context().Err() == nil {
+			next = h.pick(ctx, model, tried)
+		}
+
+		if next == nil {
+			span.SetAttributes(attrBackend.String(backend.Name), attribute.Int("gateway.attempts", attempt))
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				logger.Errorf("backend %s error: %v", backend.Name, err)
+				h.emitUsage(c, backend.Name, model, http.StatusBadGateway, tokenUsage{}, time.Since(attemptStart), clientGone(c), 0)
+				c.JSON(http.StatusBadGateway, gin.H{"error": "upstream request failed"})
+				return nil, nil, tr
+			}
+			return resp, backend.Backend, tr
+		}
+
+		// Record the failed attempt against its backend, then try the next one.
+		status := http.StatusBadGateway
+		if err != nil {
+			logger.Warnf("backend %s attempt %d failed, retrying on %s: %v", backend.Name, attempt, next.Name, err)
+		} else {
+			status = resp.StatusCode
+			logger.Warnf("backend %s attempt %d returned HTTP %d, retrying on %s", backend.Name, attempt, status, next.Name)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxPeekBytes))
+			resp.Body.Close()
+		}
+		h.emitUsage(c, backend.Name, model, status, tokenUsage{}, time.Since(attemptStart), false, 0)
+		backend = next
+	}
+}
+
+// pick chooses a backend for model that is not in tried, tracing the choice.
+func (h *Handler) pick(ctx context.Context, model string, tried map[*Backend]bool) *Lease {
+	_, span := startSpan(ctx, "backend.pick", attribute.Int("gateway.excluded", len(tried)))
+	defer span.End()
+	b := h.lb.PickExcluding(model, tried)
+	if b != nil {
+		span.SetAttributes(attrBackend.String(b.Name))
+	}
+	return b
+}
+
+// endAttemptSpan records the outcome of an upstream attempt on its span.
+func endAttemptSpan(span trace.Span, resp *http.Response, err error, retryable bool) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case retryable:
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		span.SetStatus(codes.Error, failureReason(resp, nil))
+	default:
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	}
+	span.End()
+}
+
+// sendOnce performs a single upstream request against backend. ctx must derive
+// from the client request's context; the trace context it carries is sent
+// upstream. The request is cancelled when the client disconnects or the
+// response body is closed, and counts as in flight on the backend until then.
+func (h *Handler) sendOnce(ctx context.Context, c *gin.Context, backend *Backend, upstreamPath string, body []byte, tr translation) (*http.Response, error) {
+	targetURL := strings.TrimRight(backend.URL, "/") + upstreamPath
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, targetURL, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	backend.inFlight.Add(1)
+	done := func() {
+		cancel()
+		backend.inFlight.Add(-1)
+	}
+
+	// Copy headers, replace Authorization
+	for k, vv := range c.Request.Header {
+		k = http.CanonicalHeaderKey(k)
+		if k == "Authorization" || k == "X-Api-Key" {
+			continue
+		}
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+backend.APIKey)
+	req.Header.Set("x-api-key", backend.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	if id := c.GetString(middleware.CtxRequestID); id != "" {
+		req.Header.Set(middleware.RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	prepareTranslatedHeaders(req, tr)
+
+	resp, err := backend.Client().Do(req)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+// upstreamRequestID returns the request ID a backend sent: request-id from
+// Anthropic, x-request-id from OpenAI-compatible servers.
+func upstreamRequestID(h http.Header) string {
+	if id := h.Get("Request-Id"); id != "" {
+		return id
+	}
+	return h.Get("X-Request-Id")
+}
+
+// failureReason describes a failed attempt for the circuit breaker.
+func failureReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("HTTP %d", resp.StatusCode)
+}
+
+// cancelOnClose cancels the upstream request context when the body is closed,
+// so an unfinished response stops the backend instead of being drained. done
+// runs once, however many times the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// isRetryableResponse reports whether resp indicates a backend-side failure that
+// another backend may not share: 5xx, 429, or an Anthropic overloaded_error sent
+// as the first event of an otherwise successful stream.
+func isRetryableResponse(resp *http.Response) bool {
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.StatusCode == http.StatusOK && isEventStream(resp) {
+		return peekStreamOverloaded(resp)
+	}
+	return false
+}
+
+// peekStreamOverloaded reads the first SSE event of resp and reports whether it is
+// an overloaded_error. The consumed bytes are put back in front of resp.Body.
+func peekStreamOverloaded(resp *http.Response) bool {
+	br := bufio.NewReader(resp.Body)
+	var first bytes.Buffer
+	for first.Len() < maxPeekBytes {
+		line, err := br.ReadBytes('\n')
+		first.Write(line)
+		if err != nil || len(bytes.TrimSpace(line)) == 0 && first.Len() > len(line) {
+			break
+		}
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(first.Bytes()), br), resp.Body}
+
+	return bytes.Contains(first.Bytes(), []byte(`"overloaded_error"`))
+}
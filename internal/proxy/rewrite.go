@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+)
+
+// matchContains is the substring match used by the legacy model_replacements map.
+const matchContains = "contains"
+
+// Rewriter maps the model a client requests to the model sent upstream.
+// A nil Rewriter leaves every model unchanged.
+type Rewriter struct {
+	rules []rewriteRule // in evaluation order
+}
+
+type rewriteRule struct {
+	match   string
+	pattern string
+	re      *regexp.Regexp
+	target  string
+	users   []string
+	keys    []int64
+}
+
+// NewRewriter compiles the model_rewrites rules. Rules scoped to API keys are
+// tried first, then rules scoped to users, then unscoped rules, each in config
+// order. The legacy model_replacements entries follow as substring rules,
+// longest pattern first so overlapping patterns resolve the same way every time.
+func NewRewriter(rules []config.ModelRewrite, replacements map[string]string) (*Rewriter, error) {
+	var byKey, byUser, global []rewriteRule
+	for i, r := range rules {
+		rule := rewriteRule{match: r.Match, pattern: r.Pattern, target: r.Target, users: r.Users, keys: r.APIKeys}
+		switch r.Match {
+		case "", config.MatchExact:
+			rule.match = config.MatchExact
+		case config.MatchPrefix, config.MatchGlob:
+		case config.MatchRegex:
+			re, err := regexp.Compile(`^(?:` + r.Pattern + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("model_rewrites[%d]: %w", i, err)
+			}
+			rule.re = re
+		default:
+			return nil, fmt.Errorf("model_rewrites[%d]: unknown match %q", i, r.Match)
+		}
+		switch {
+		case len(r.APIKeys) > 0:
+			byKey = append(byKey, rule)
+		case len(r.Users) > 0:
+			byUser = append(byUser, rule)
+		default:
+			global = append(global, rule)
+		}
+	}
+
+	patterns := make([]string, 0, len(replacements))
+	for p := range replacements {
+		patterns = append(patterns, p)
+	}
+	slices.SortFunc(patterns, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+	for _, p := range patterns {
+		global = append(global, rewriteRule{match: matchContains, pattern: p, target: replacements[p]})
+	}
+
+	return &Rewriter{rules: slices.Concat(byKey, byUser, global)}, nil
+}
+
+// Rewrite returns the model to send upstream for model requested with key.
+// key may be nil, in which case only unscoped rules apply.
+func (r *Rewriter) Rewrite(model string, key *auth.KeyInfo) string {
+	if r == nil || model == "" {
+		return model
+	}
+	for _, rule := range r.rules {
+		if !rule.appliesTo(key) {
+			continue
+		}
+		if target, ok := rule.apply(model); ok {
+			return target
+		}
+	}
+	return model
+}
+
+// aliases returns the model names that exact and substring rules applicable
+// to key map to another model, with their targets. Other rule types match
+// open-ended sets of names and cannot be listed.
+func (r *Rewriter) aliases(key *auth.KeyInfo) map[string]string {
+	aliases := make(map[string]string)
+	if r == nil {
+		return aliases
+	}
+	for _, rule := range r.rules {
+		if rule.match != config.MatchExact && rule.match != matchContains || !rule.appliesTo(key) {
+			continue
+		}
+		// The first applicable rule for a name wins, as in Rewrite.
+		if _, ok := aliases[rule.pattern]; !ok && r.Rewrite(rule.pattern, key) == rule.target {
+			aliases[rule.pattern] = rule.target
+		}
+	}
+	return aliases
+}
+
+func (rule rewriteRule) appliesTo(key *auth.KeyInfo) bool {
+	if len(rule.keys) > 0 {
+		return key != nil && slices.Contains(rule.keys, key.KeyID)
+	}
+	if len(rule.users) > 0 {
+		return key != nil && slices.Contains(rule.users, key.Itcode)
+	}
+	return true
+}
+
+func (rule rewriteRule) apply(model string) (string, bool) {
+	switch rule.match {
+	case config.MatchExact:
+		return rule.target, model == rule.pattern
+	case config.MatchPrefix:
+		return rule.target, strings.HasPrefix(model, rule.pattern)
+	case config.MatchGlob:
+		ok, _ := path.Match(rule.pattern, model)
+		return rule.target, ok
+	case config.MatchRegex:
+		m := rule.re.FindStringSubmatchIndex(model)
+		if m == nil {
+			return "", false
+		}
+		return string(rule.re.ExpandString(nil, rule.target, model, m)), true
+	case matchContains:
+		return rule.target, strings.Contains(model, rule.pattern)
+	}
+	return "", false
+}
+
+// setModel returns body with its top-level "model" field set to model. The rest
+// of the document is kept as sent, apart from key order and insignificant
+// whitespace.
+func setModel(body []byte, model string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	fields["model"], _ = json.Marshal(model)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
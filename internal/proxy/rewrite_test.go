@@ -0,0 +1,91 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+)
+
+func TestRewriter_RuleOrder(t *testing.T) {
+	rw, err := proxy.NewRewriter([]config.ModelRewrite{
+		{Match: config.MatchRegex, Pattern: `claude-3-5-(sonnet|haiku)(-\d+)?`, Target: "claude-$1-4-5"},
+		{Match: config.MatchGlob, Pattern: "gpt-4*", Target: "claude-sonnet-4-5"},
+		{Match: config.MatchPrefix, Pattern: "claude-3-5-", Target: "claude-haiku-4-5", Users: []string{"alice"}},
+		{Match: config.MatchExact, Pattern: "claude-3-5-sonnet", Target: "claude-opus-4-1", APIKeys: []int64{7}},
+	}, map[string]string{"sonnet": "claude-sonnet-4-0", "3-5-sonnet": "claude-sonnet-3-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &auth.KeyInfo{KeyID: 1, Itcode: "alice"}
+	aliceKey7 := &auth.KeyInfo{KeyID: 7, Itcode: "alice"}
+
+	cases := []struct {
+		model string
+		key   *auth.KeyInfo
+		want  string
+	}{
+		{"claude-3-5-sonnet-20241022", nil, "claude-sonnet-4-5"}, // regex with a group
+		{"claude-3-5-sonnet", aliceKey7, "claude-opus-4-1"},      // key rule before user rule
+		{"claude-3-5-sonnet", alice, "claude-haiku-4-5"},         // user rule before global rules
+		{"gpt-4o", alice, "claude-sonnet-4-5"},                   // glob
+		{"claude-3-5-sonnet-latest", nil, "claude-sonnet-3-7"},   // longest legacy pattern wins
+		{"my-sonnet", nil, "claude-sonnet-4-0"},                  // legacy substring
+		{"claude-opus-4-1", alice, "claude-opus-4-1"},            // no match
+	}
+	for _, tc := range cases {
+		if got := rw.Rewrite(tc.model, tc.key); got != tc.want {
+			t.Errorf("Rewrite(%q, %v) = %q, want %q", tc.model, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestHandler_RewritesOnlyTheModelField(t *testing.T) {
+	var upstreamBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	rw, err := proxy.NewRewriter([]config.ModelRewrite{
+		{Match: config.MatchExact, Pattern: "sonnet", Target: "claude-sonnet-4-5"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, rec := newRewritingRouter(t, upstream, "anthropic", rw)
+
+	// Unusual whitespace, and the same token inside message content.
+	body := `{ "model" :  "sonnet", "max_tokens": 10,
+		"messages": [{"role": "user", "content": "echo {\"model\":\"sonnet\"} <b>"}]}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var sent struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(upstreamBody, &sent); err != nil {
+		t.Fatalf("upstream body is not JSON: %v", err)
+	}
+	if sent.Model != "claude-sonnet-4-5" || sent.Messages[0].Content != `echo {"model":"sonnet"} <b>` {
+		t.Fatalf("unexpected upstream body: %s", upstreamBody)
+	}
+	if got := rec.last(t); got.Model != "claude-sonnet-4-5" || got.RequestedModel != "sonnet" {
+		t.Fatalf("expected effective and requested models in usage, got %q and %q", got.Model, got.RequestedModel)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// sseEvent is a single server-sent event.
+type sseEvent struct {
+	Event string
+	Data  []byte
+}
+
+// sseReader splits a text/event-stream body into events. Only the current event
+// is held in memory.
+type sseReader struct {
+	r *bufio.Reader
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{r: bufio.NewReaderSize(r, 16*1024)}
+}
+
+// Next returns the next event with a non-empty data field. It returns io.EOF
+// when the stream ends.
+func (s *sseReader) Next() (*sseEvent, error) {
+	ev := &sseEvent{}
+	for {
+		line, err := s.r.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+
+		switch {
+		case len(line) == 0:
+			if len(ev.Data) > 0 {
+				return ev, nil
+			}
+			ev.Event = ""
+		case line[0] == ':':
+			// comment / keep-alive
+		case bytes.HasPrefix(line, []byte("event:")):
+			ev.Event = string(bytes.TrimSpace(line[len("event:"):]))
+		case bytes.HasPrefix(line, []byte("data:")):
+			data := bytes.TrimPrefix(line[len("data:"):], []byte(" "))
+			if len(ev.Data) > 0 {
+				ev.Data = append(ev.Data, '\n')
+			}
+			ev.Data = append(ev.Data, data...)
+		}
+
+		if err != nil {
+			if len(ev.Data) > 0 {
+				return ev, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// sseWriter writes events to the client, flushing after each one.
+type sseWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func newSSEWriter(w io.Writer) *sseWriter {
+	f, _ := w.(http.Flusher)
+	return &sseWriter{w: w, flusher: f}
+}
+
+// WriteEvent writes an event with an optional name and a JSON-encoded data field.
+func (s *sseWriter) WriteEvent(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.WriteRaw(event, data)
+}
+
+// WriteRaw writes an event whose data field is sent verbatim.
+func (s *sseWriter) WriteRaw(event string, data []byte) error {
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(event)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Load balancing strategies accepted in config.LoadBalancerConfig.Strategy.
+const (
+	StrategyWeightedRandom = "weighted_random"
+	StrategyLeastInFlight  = "least_in_flight"
+	StrategyPeakEWMA       = "peak_ewma"
+	StrategyP2C            = "p2c"
+	StrategyPriority       = "priority"
+)
+
+// ewmaDecay is the time constant of each backend's latency average: a sample
+// from ewmaDecay ago weighs 1/e as much as one taken now.
+const ewmaDecay = 10 * time.Second
+
+// strategy chooses a backend from a non-empty list of available backends.
+type strategy interface {
+	choose(candidates []*Backend) *Backend
+	// score is the backend's current load as the strategy sees it; lower is better.
+	score(b *Backend) float64
+}
+
+// ValidateStrategy returns an error unless name is a load balancing strategy.
+func ValidateStrategy(name string) error {
+	_, err := newStrategy(name)
+	return err
+}
+
+func newStrategy(name string) (strategy, error) {
+	switch name {
+	case "", StrategyWeightedRandom:
+		return weightedRandom{}, nil
+	case StrategyLeastInFlight:
+		return leastInFlight{}, nil
+	case StrategyPeakEWMA:
+		return peakEWMA{}, nil
+	case StrategyP2C:
+		return powerOfTwo{}, nil
+	case StrategyPriority:
+		return priorityTiers{}, nil
+	}
+	return nil, fmt.Errorf("unknown load balancing strategy %q", name)
+}
+
+// weightedRandom picks backends at random in proportion to their weight.
+type weightedRandom struct{}
+
+func (weightedRandom) choose(candidates []*Backend) *Backend { return weightedPick(candidates) }
+func (weightedRandom) score(*Backend) float64                { return 0 }
+
+func weightedPick(candidates []*Backend) *Backend {
+	total := 0
+	for _, b := range candidates {
+		total += b.Weight
+	}
+	r := rand.Intn(total)
+	for _, b := range candidates {
+		r -= b.Weight
+		if r < 0 {
+			return b
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// leastInFlight picks the backend with the fewest running requests per unit of weight.
+type leastInFlight struct{}
+
+func (s leastInFlight) choose(candidates []*Backend) *Backend { return lowestScore(s, candidates) }
+func (leastInFlight) score(b *Backend) float64 {
+	return float64(b.InFlight()) / float64(b.Weight)
+}
+
+// peakEWMA picks the backend with the lowest expected wait: its latency
+// average, which jumps straight up to slow samples and decays slowly, times
+// the requests it is already running.
+type peakEWMA struct{}
+
+func (s peakEWMA) choose(candidates []*Backend) *Backend { return lowestScore(s, candidates) }
+func (peakEWMA) score(b *Backend) float64 {
+	return b.LatencyEWMA().Seconds() * float64(b.InFlight()+1) / float64(b.Weight)
+}
+
+// powerOfTwo compares two backends drawn at random by weight and picks the one
+// with the lower peak-EWMA score, which avoids herding onto a single backend.
+type powerOfTwo struct{}
+
+func (powerOfTwo) choose(candidates []*Backend) *Backend {
+	a := weightedPick(candidates)
+	if len(candidates) == 1 {
+		return a
+	}
+	rest := make([]*Backend, 0, len(candidates)-1)
+	for _, b := range candidates {
+		if b != a {
+			rest = append(rest, b)
+		}
+	}
+	b := weightedPick(rest)
+	var score peakEWMA
+	if score.score(b) < score.score(a) {
+		return b
+	}
+	return a
+}
+func (powerOfTwo) score(b *Backend) float64 { return peakEWMA{}.score(b) }
+
+// priorityTiers uses the lowest priority tier with an available backend,
+// choosing by weight within it. Unhealthy and saturated backends are filtered
+// out before a strategy runs, so traffic spills over to the next tier only
+// when every backend in the current one is down or full.
+type priorityTiers struct{}
+
+func (priorityTiers) choose(candidates []*Backend) *Backend {
+	top := candidates[0].Priority
+	for _, b := range candidates {
+		top = min(top, b.Priority)
+	}
+	var tier []*Backend
+	for _, b := range candidates {
+		if b.Priority == top {
+			tier = append(tier, b)
+		}
+	}
+	return weightedPick(tier)
+}
+func (priorityTiers) score(b *Backend) float64 { return float64(b.Priority) }
+
+// lowestScore returns the candidate with the lowest score, breaking ties by weight.
+func lowestScore(s strategy, candidates []*Backend) *Backend {
+	best := math.Inf(1)
+	var ties []*Backend
+	for _, b := range candidates {
+		switch score := s.score(b); {
+		case score < best:
+			best = score
+			ties = append(ties[:0], b)
+		case score == best:
+			ties = append(ties, b)
+		}
+	}
+	return weightedPick(ties)
+}
+
+// latency tracks a peak-sensitive exponentially weighted moving average.
+type latency struct {
+	value time.Duration
+	at    time.Time
+}
+
+func (l *latency) observe(d time.Duration, now time.Time) {
+	if l.at.IsZero() || d > l.value {
+		l.value = d
+	} else {
+		w := math.Exp(-now.Sub(l.at).Seconds() / ewmaDecay.Seconds())
+		l.value = time.Duration(float64(l.value)*w + float64(d)*(1-w))
+	}
+	l.at = now
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of spans started in this package.
+const tracerName = "github.com/wjzhangq/claude-gateway/internal/proxy"
+
+// Span attributes that have no OpenTelemetry semantic convention.
+const (
+	attrBackend     = attribute.Key("gateway.backend")
+	attrModel       = attribute.Key("gateway.model") // model sent upstream, after rewriting
+	attrAttempt     = attribute.Key("gateway.attempt")
+	attrTTFT        = attribute.Key("gateway.time_to_first_token_ms")
+	attrAborted     = attribute.Key("gateway.client_aborted")
+	attrCacheWrite  = attribute.Key("gateway.usage.cache_creation_tokens")
+	attrCacheRead   = attribute.Key("gateway.usage.cache_read_tokens")
+	attrStreamBytes = attribute.Key("gateway.stream_bytes")
+)
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endStreamSpan finishes the span of a relayed stream, marking when its first
+// bytes arrived.
+func endStreamSpan(span trace.Span, start time.Time, ttfb time.Duration, bytes int64, aborted bool) {
+	if ttfb > 0 {
+		span.AddEvent("first_token", trace.WithTimestamp(start.Add(ttfb)))
+		span.SetAttributes(attrTTFT.Int64(ttfb.Milliseconds()))
+	}
+	span.SetAttributes(attrStreamBytes.Int64(bytes), attrAborted.Bool(aborted))
+	span.End()
+}
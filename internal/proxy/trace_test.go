@@ -0,0 +1,92 @@
+package proxy_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/stats"
+	"github.com/wjzhangq/claude-gateway/internal/tracing"
+)
+
+func TestHandler_TracesRequestAndContinuesIncomingTrace(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	tracing.SetPropagator()
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	var upstreamParent string
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get("Traceparent")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":5}}}\n\n" +
+			"data: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":7}}\n\n"))
+	}))
+	defer good.Close()
+
+	database, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	collector := stats.NewCollector(database, config.UsageLogConfig{BufferSize: 16, BatchSize: 1})
+	defer collector.Close(context.Background())
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "bad", URL: bad.URL, APIKey: "k", Weight: 1, Enabled: true, Priority: 1},
+		{Name: "good", URL: good.URL, APIKey: "k", Weight: 1, Enabled: true, Priority: 2},
+	}, config.HealthCheckConfig{})
+	lb.SetStrategy(proxy.StrategyPriority)
+	h := proxy.NewHandler(lb, collector, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 2, Timeout: time.Minute}, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(tracing.Middleware())
+	r.Use(func(c *gin.Context) {
+		c.Set(middleware.CtxKeyInfo, &auth.KeyInfo{KeyID: 1, UserID: 1, Itcode: "alice"})
+	})
+	r.Any("/v1/*path", h.Passthrough)
+
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true}`))
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	count := make(map[string]int)
+	for _, s := range spans.Ended() {
+		if got := s.SpanContext().TraceID().String(); got != traceID {
+			t.Fatalf("span %s: expected incoming trace %s to continue, got %s", s.Name(), traceID, got)
+		}
+		count[s.Name()]++
+	}
+	for name, n := range map[string]int{
+		"POST /v1/*path": 1, "model.rewrite": 1, "backend.pick": 2, "upstream": 1,
+		"upstream.attempt": 2, "response.stream": 1, "usage.emit": 2,
+	} {
+		if count[name] != n {
+			t.Fatalf("expected %d %q spans, got %d (all: %v)", n, name, count[name], count)
+		}
+	}
+	if !strings.Contains(upstreamParent, traceID) {
+		t.Fatalf("expected trace context to be propagated upstream, got %q", upstreamParent)
+	}
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/internal/logger"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+)
+
+// Backend protocols accepted in config.BackendAPI.Protocol.
+const (
+	ProtocolAnthropic = "anthropic"
+	ProtocolOpenAI    = "openai"
+)
+
+// anthropicVersion is sent upstream when a translated request has no
+// anthropic-version header of its own.
+const anthropicVersion = "2023-06-01"
+
+// translation identifies how a request is adapted between the client's
+// protocol and the protocol spoken by the selected backend.
+type translation int
+
+const (
+	translateNone              translation = iota
+	translateOpenAIToAnthropic             // OpenAI chat client, Anthropic backend
+	translateAnthropicToOpenAI             // Anthropic messages client, OpenAI backend
+)
+
+// upstreamRequest is a client request prepared for forwarding. Translated bodies
+// are built on first use and reused across retry attempts.
+type upstreamRequest struct {
+	path         string
+	body         []byte
+	translated   map[translation][]byte
+	includeUsage bool // OpenAI stream_options.include_usage
+}
+
+func newUpstreamRequest(path string, body []byte) *upstreamRequest {
+	return &upstreamRequest{path: path, body: body, translated: make(map[translation][]byte)}
+}
+
+// translationFor returns the translation needed to send the request to b.
+func (r *upstreamRequest) translationFor(b *Backend) translation {
+	if r.path == "/v1/chat/completions" && b.Protocol == ProtocolAnthropic {
+		return translateOpenAIToAnthropic
+	}
+	if r.path == "/v1/messages" && b.Protocol == ProtocolOpenAI {
+		return translateAnthropicToOpenAI
+	}
+	return translateNone
+}
+
+// prepare returns the upstream path and body to send to b, and the translation applied.
+func (r *upstreamRequest) prepare(b *Backend) (string, []byte, translation, error) {
+	tr := r.translationFor(b)
+	switch tr {
+	case translateOpenAIToAnthropic:
+		body, ok := r.translated[tr]
+		if !ok {
+			var err error
+			body, r.includeUsage, err = openAIToAnthropicRequest(r.body)
+			if err != nil {
+				return "", nil, tr, err
+			}
+			r.translated[tr] = body
+		}
+		return "/v1/messages", body, tr, nil
+	case translateAnthropicToOpenAI:
+		body, ok := r.translated[tr]
+		if !ok {
+			var err error
+			body, err = anthropicToOpenAIRequest(r.body)
+			if err != nil {
+				return "", nil, tr, err
+			}
+			r.translated[tr] = body
+		}
+		return "/v1/chat/completions", body, tr, nil
+	}
+	return r.path, r.body, translateNone, nil
+}
+
+// prepareTranslatedHeaders adjusts upstream headers for a translated request.
+func prepareTranslatedHeaders(req *http.Request, tr translation) {
+	if tr == translateNone {
+		return
+	}
+	// Let the transport negotiate compression so the body can be parsed and rewritten.
+	req.Header.Del("Accept-Encoding")
+	switch tr {
+	case translateOpenAIToAnthropic:
+		if req.Header.Get("anthropic-version") == "" {
+			req.Header.Set("anthropic-version", anthropicVersion)
+		}
+	case translateAnthropicToOpenAI:
+		for k := range req.Header {
+			if strings.HasPrefix(k, "Anthropic-") {
+				req.Header.Del(k)
+			}
+		}
+	}
+}
+
+// writeTranslated writes an upstream response to the client in the client's protocol.
+func (h *Handler) writeTranslated(c *gin.Context, resp *http.Response, tr translation, req *upstreamRequest, backendName, model string, start time.Time) {
+	switch tr {
+	case translateOpenAIToAnthropic:
+		if isEventStream(resp) && resp.StatusCode < 400 {
+			h.streamTranslated(c, resp, newOpenAIStreamTranslator(c.Writer, req.includeUsage), backendName, model, start)
+			return
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			logger.Errorf("read response body: %v", err)
+			h.emitUsage(c, backendName, model, resp.StatusCode, tokenUsage{}, time.Since(start), clientGone(c), 0)
+			return
+		}
+		out, err := anthropicToOpenAIResponse(body, resp.StatusCode)
+		if err != nil {
+			logger.Errorf("translate response from %s: %v", backendName, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": gin.H{"message": "invalid upstream response", "type": "api_error"}})
+			return
+		}
+		c.Data(resp.StatusCode, "application/json", out)
+		h.emitUsage(c, backendName, model, resp.StatusCode, parseBodyUsage(body), time.Since(start), false, 0)
+
+	case translateAnthropicToOpenAI:
+		if isEventStream(resp) && resp.StatusCode < 400 {
+			h.streamTranslated(c, resp, newAnthropicStreamTranslator(c.Writer, model), backendName, model, start)
+			return
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			logger.Errorf("read response body: %v", err)
+			h.emitUsage(c, backendName, model, resp.StatusCode, tokenUsage{}, time.Since(start), clientGone(c), 0)
+			return
+		}
+		out, err := openAIToAnthropicResponse(body, resp.StatusCode)
+		if err != nil {
+			logger.Errorf("translate response from %s: %v", backendName, err)
+			middleware.AbortWithAPIError(c, http.StatusBadGateway, "api_error", "invalid upstream response")
+			return
+		}
+		c.Data(resp.StatusCode, "application/json", out)
+		h.emitUsage(c, backendName, model, resp.StatusCode, parseBodyUsage(body), time.Since(start), false, 0)
+	}
+}
+
+// streamTranslator converts an upstream event stream into the client's protocol.
+type streamTranslator interface {
+	Translate(r io.Reader) error
+	Usage() anthropicUsage
+}
+
+// streamTranslated relays resp through t, recording usage like streamResponse.
+func (h *Handler) streamTranslated(c *gin.Context, resp *http.Response, t streamTranslator, backendName, model string, start time.Time) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(resp.StatusCode)
+
+	_, span := startSpan(c.Request.Context(), "response.stream", attrBackend.String(backendName))
+	body := &firstByteReader{r: resp.Body, start: start}
+	err := t.Translate(body)
+	aborted := err != nil && clientGone(c)
+	if aborted {
+		resp.Body.Close()
+		logger.Infof("client aborted stream from %s after %s", backendName, time.Since(start).Round(time.Millisecond))
+	} else if err != nil {
+		logger.Warnf("translate stream from %s: %v", backendName, err)
+	}
+	endStreamSpan(span, start, body.ttfb, body.n, aborted)
+	h.emitUsage(c, backendName, model, resp.StatusCode, usageFromAnthropic(t.Usage()), time.Since(start), aborted, body.ttfb)
+}
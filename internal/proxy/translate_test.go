@@ -0,0 +1,404 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+)
+
+// newSingleBackendHandler returns a handler whose only backend is upstream.
+func newSingleBackendHandler(upstream *httptest.Server, protocol string) *proxy.Handler {
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "upstream", URL: upstream.URL, APIKey: "k", Weight: 1, Enabled: true, Protocol: protocol},
+	}, config.HealthCheckConfig{})
+	return proxy.NewHandler(lb, nil, nil, []string{"*"}, config.RetryConfig{MaxAttempts: 1, Timeout: time.Minute}, nil)
+}
+
+func TestChatCompletions_TranslatedToAnthropic(t *testing.T) {
+	var got map[string]interface{}
+	var gotPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": "msg_123", "type": "message", "role": "assistant", "model": "claude-sonnet-4",
+			"content": [
+				{"type": "text", "text": "Checking the weather."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			],
+			"stop_reason": "tool_use",
+			"usage": {"input_tokens": 10, "output_tokens": 5}
+		}`))
+	}))
+	defer upstream.Close()
+
+	r := newTestRouter(newSingleBackendHandler(upstream, "anthropic"))
+	reqBody := `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 256,
+		"stop": "END",
+		"temperature": 1.5,
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "Weather?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Rome\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+		],
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+		"tool_choice": "required"
+	}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(reqBody))
+	r.ServeHTTP(w, req)
+
+	if gotPath != "/v1/messages" {
+		t.Fatalf("expected upstream path /v1/messages, got %s", gotPath)
+	}
+	if got["system"] != "Be brief." || got["max_tokens"].(float64) != 256 || got["temperature"].(float64) != 1 {
+		t.Fatalf("unexpected translated request: %v", got)
+	}
+	if stops := got["stop_sequences"].([]interface{}); len(stops) != 1 || stops[0] != "END" {
+		t.Fatalf("unexpected stop_sequences: %v", got["stop_sequences"])
+	}
+	if tc := got["tool_choice"].(map[string]interface{}); tc["type"] != "any" {
+		t.Fatalf("unexpected tool_choice: %v", tc)
+	}
+	msgs := got["messages"].([]interface{})
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 alternating messages, got %d: %v", len(msgs), msgs)
+	}
+	image := msgs[0].(map[string]interface{})["content"].([]interface{})[1].(map[string]interface{})
+	if src := image["source"].(map[string]interface{}); src["media_type"] != "image/png" || src["data"] != "AAAA" {
+		t.Fatalf("unexpected image block: %v", image)
+	}
+	toolUse := msgs[1].(map[string]interface{})["content"].([]interface{})[0].(map[string]interface{})
+	if toolUse["type"] != "tool_use" || toolUse["input"].(map[string]interface{})["city"] != "Rome" {
+		t.Fatalf("unexpected tool_use block: %v", toolUse)
+	}
+	toolResult := msgs[2].(map[string]interface{})["content"].([]interface{})[0].(map[string]interface{})
+	if toolResult["type"] != "tool_result" || toolResult["tool_use_id"] != "call_1" {
+		t.Fatalf("unexpected tool_result block: %v", toolResult)
+	}
+
+	var resp struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Message struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					ID       string `json:"id"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v: %s", err, w.Body.String())
+	}
+	if resp.Object != "chat.completion" || len(resp.Choices) != 1 {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+	choice := resp.Choices[0]
+	if choice.FinishReason != "tool_calls" || choice.Message.Content != "Checking the weather." {
+		t.Fatalf("unexpected choice: %+v", choice)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Fatalf("unexpected tool calls: %+v", choice.Message.ToolCalls)
+	}
+	if resp.Usage.PromptTokens != 10 || resp.Usage.CompletionTokens != 5 || resp.Usage.TotalTokens != 15 {
+		t.Fatalf("unexpected usage: %+v", resp.Usage)
+	}
+}
+
+func TestChatCompletions_StreamTranslatedToAnthropic(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(strings.Join([]string{
+			`event: message_start`,
+			`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":12,"output_tokens":1}}}`,
+			``,
+			`event: content_block_start`,
+			`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+			``,
+			`event: message_delta`,
+			`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":7}}`,
+			``,
+			`event: message_stop`,
+			`data: {"type":"message_stop"}`,
+			``,
+		}, "\n") + "\n"))
+	}))
+	defer upstream.Close()
+
+	r := newTestRouter(newSingleBackendHandler(upstream, "anthropic"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(
+		`{"model":"claude-sonnet-4","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`))
+	r.ServeHTTP(w, req)
+
+	var text, finish string
+	var usage map[string]interface{}
+	var done bool
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk struct {
+			Object  string `json:"object"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage map[string]interface{} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decode chunk %q: %v", data, err)
+		}
+		if chunk.Object != "chat.completion.chunk" {
+			t.Fatalf("unexpected object %q", chunk.Object)
+		}
+		for _, c := range chunk.Choices {
+			text += c.Delta.Content
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if text != "Hello" || finish != "stop" || !done {
+		t.Fatalf("unexpected stream: text=%q finish=%q done=%v\n%s", text, finish, done, w.Body.String())
+	}
+	if usage["prompt_tokens"].(float64) != 12 || usage["completion_tokens"].(float64) != 7 {
+		t.Fatalf("unexpected usage chunk: %v", usage)
+	}
+}
+
+func TestChatCompletions_PassthroughForOpenAIBackend(t *testing.T) {
+	var gotPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"chat.completion"}`))
+	}))
+	defer upstream.Close()
+
+	r := newTestRouter(newSingleBackendHandler(upstream, "openai"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	r.ServeHTTP(w, req)
+
+	if gotPath != "/v1/chat/completions" || w.Body.String() != `{"object":"chat.completion"}` {
+		t.Fatalf("expected untranslated passthrough, got path %s body %s", gotPath, w.Body.String())
+	}
+}
+
+func TestMessages_TranslatedToOpenAI(t *testing.T) {
+	var got map[string]interface{}
+	var gotPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": "chatcmpl-abc", "object": "chat.completion", "model": "qwen",
+			"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {
+				"role": "assistant", "content": "Checking.",
+				"tool_calls": [{"id": "call_9", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Oslo\"}"}}]
+			}}],
+			"usage": {"prompt_tokens": 20, "completion_tokens": 4, "total_tokens": 24}
+		}`))
+	}))
+	defer upstream.Close()
+
+	r := newTestRouter(newSingleBackendHandler(upstream, "openai"))
+	reqBody := `{
+		"model": "qwen",
+		"max_tokens": 128,
+		"system": [{"type": "text", "text": "Be brief."}],
+		"stop_sequences": ["END"],
+		"messages": [
+			{"role": "user", "content": "Weather?"},
+			{"role": "assistant", "content": [
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Rome"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "sunny"}]},
+				{"type": "text", "text": "And Oslo?"}
+			]}
+		],
+		"tools": [
+			{"name": "get_weather", "input_schema": {"type": "object"}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"tool_choice": {"type": "any"}
+	}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(reqBody))
+	r.ServeHTTP(w, req)
+
+	if gotPath != "/v1/chat/completions" {
+		t.Fatalf("expected upstream path /v1/chat/completions, got %s", gotPath)
+	}
+	if got["max_tokens"].(float64) != 128 || got["tool_choice"] != "required" || len(got["tools"].([]interface{})) != 1 {
+		t.Fatalf("unexpected translated request: %v", got)
+	}
+	msgs := got["messages"].([]interface{})
+	roles := make([]string, len(msgs))
+	for i, m := range msgs {
+		roles[i] = m.(map[string]interface{})["role"].(string)
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,tool,user" {
+		t.Fatalf("unexpected message roles %v: %v", roles, msgs)
+	}
+	call := msgs[2].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})
+	if call["function"].(map[string]interface{})["arguments"] != `{"city":"Rome"}` {
+		t.Fatalf("unexpected tool call: %v", call)
+	}
+	if tool := msgs[3].(map[string]interface{}); tool["tool_call_id"] != "toolu_1" || tool["content"] != "sunny" {
+		t.Fatalf("unexpected tool message: %v", tool)
+	}
+
+	var resp struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Type  string                 `json:"type"`
+			Text  string                 `json:"text"`
+			ID    string                 `json:"id"`
+			Input map[string]interface{} `json:"input"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v: %s", err, w.Body.String())
+	}
+	if resp.ID != "msg_abc" || resp.Type != "message" || resp.StopReason != "tool_use" || len(resp.Content) != 2 {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+	if resp.Content[0].Text != "Checking." || resp.Content[1].ID != "call_9" || resp.Content[1].Input["city"] != "Oslo" {
+		t.Fatalf("unexpected content: %+v", resp.Content)
+	}
+	if resp.Usage.InputTokens != 20 || resp.Usage.OutputTokens != 4 {
+		t.Fatalf("unexpected usage: %+v", resp.Usage)
+	}
+}
+
+func TestMessages_StreamTranslatedToOpenAI(t *testing.T) {
+	var got map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(strings.Join([]string{
+			`data: {"id":"chatcmpl-1","model":"qwen","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+			``,
+			`data: {"id":"chatcmpl-1","model":"qwen","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+			``,
+			`data: {"id":"chatcmpl-1","model":"qwen","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":""}}]}}]}`,
+			``,
+			`data: {"id":"chatcmpl-1","model":"qwen","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"a\":1}"}}]}}]}`,
+			``,
+			`data: {"id":"chatcmpl-1","model":"qwen","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			``,
+			`data: {"id":"chatcmpl-1","model":"qwen","choices":[],"usage":{"prompt_tokens":9,"completion_tokens":3,"total_tokens":12}}`,
+			``,
+			`data: [DONE]`,
+			``,
+		}, "\n") + "\n"))
+	}))
+	defer upstream.Close()
+
+	r := newTestRouter(newSingleBackendHandler(upstream, "openai"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"qwen","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	r.ServeHTTP(w, req)
+
+	if opts, _ := got["stream_options"].(map[string]interface{}); opts["include_usage"] != true {
+		t.Fatalf("expected stream_options.include_usage, got %v", got)
+	}
+
+	var events []string
+	var text, partialJSON, stopReason string
+	var outputTokens float64
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+			continue
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var ev struct {
+			Delta struct {
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage struct {
+				OutputTokens float64 `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+			t.Fatalf("decode event %q: %v", line, err)
+		}
+		text += ev.Delta.Text
+		partialJSON += ev.Delta.PartialJSON
+		if ev.Delta.StopReason != "" {
+			stopReason = ev.Delta.StopReason
+			outputTokens = ev.Usage.OutputTokens
+		}
+	}
+
+	want := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(events, ",") != want {
+		t.Fatalf("unexpected event sequence:\n%s", w.Body.String())
+	}
+	if text != "Hello" || partialJSON != `{"a":1}` || stopReason != "tool_use" || outputTokens != 3 {
+		t.Fatalf("unexpected stream: text=%q json=%q stop=%q out=%v", text, partialJSON, stopReason, outputTokens)
+	}
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// tokenUsage is the token accounting of one request. Input excludes cached
+// prompt tokens, which are counted in CacheCreation and CacheRead instead.
+type tokenUsage struct {
+	Input         int
+	Output        int
+	CacheCreation int
+	CacheRead     int
+	// CacheCreation1h is the part of CacheCreation written to the 1-hour
+	// cache, which is billed at a higher rate.
+	CacheCreation1h int
+}
+
+// Total returns every token processed by the request, cached or not.
+func (u tokenUsage) Total() int {
+	return u.Input + u.Output + u.CacheCreation + u.CacheRead
+}
+
+// usagePayload is a usage object in either the Anthropic or the OpenAI format.
+type usagePayload struct {
+	// Anthropic format
+	InputTokens              int                     `json:"input_tokens"`
+	OutputTokens             int                     `json:"output_tokens"`
+	CacheCreationInputTokens int                     `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int                     `json:"cache_read_input_tokens"`
+	CacheCreation            *anthropicCacheCreation `json:"cache_creation"`
+	// OpenAI format; prompt_tokens includes cached tokens.
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+func (p *usagePayload) tokens() tokenUsage {
+	u := tokenUsage{
+		Input:         p.InputTokens + p.PromptTokens,
+		Output:        p.OutputTokens + p.CompletionTokens,
+		CacheCreation: p.CacheCreationInputTokens,
+		CacheRead:     p.CacheReadInputTokens,
+	}
+	if p.CacheCreation != nil {
+		u.CacheCreation1h = p.CacheCreation.Ephemeral1hInputTokens
+	}
+	if p.PromptTokensDetails != nil {
+		u.CacheRead += p.PromptTokensDetails.CachedTokens
+		u.Input -= p.PromptTokensDetails.CachedTokens
+	}
+	return u
+}
+
+// parseBodyUsage extracts token usage from a non-streaming JSON response.
+// Handles both OpenAI and Anthropic response formats.
+func parseBodyUsage(body []byte) tokenUsage {
+	var r struct {
+		Usage usagePayload `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return tokenUsage{}
+	}
+	return r.Usage.tokens()
+}
+
+// streamUsage accumulates token usage from the data payloads of an SSE stream.
+//
+// Anthropic reports input and cache tokens in message_start and cumulative
+// output tokens in message_delta, which may repeat some of the earlier fields;
+// the two are merged. OpenAI streams report the full usage in a final chunk.
+type streamUsage struct {
+	usage tokenUsage
+}
+
+// Observe inspects one SSE data payload.
+func (s *streamUsage) Observe(data []byte) {
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var ev struct {
+		Type    string `json:"type"`
+		Message *struct {
+			Usage *usagePayload `json:"usage"`
+		} `json:"message"`
+		Usage *usagePayload `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return
+	}
+
+	switch {
+	case ev.Type == "message_start" && ev.Message != nil && ev.Message.Usage != nil:
+		s.usage = ev.Message.Usage.tokens()
+	case ev.Type == "message_delta" && ev.Usage != nil:
+		s.merge(ev.Usage.tokens())
+	case ev.Usage != nil:
+		if u := ev.Usage.tokens(); u.Total() > 0 {
+			s.usage = u
+		}
+	}
+}
+
+// merge folds cumulative counts into the totals, keeping fields u omits.
+func (s *streamUsage) merge(u tokenUsage) {
+	if u.Input > 0 {
+		s.usage.Input = u.Input
+	}
+	if u.Output > 0 {
+		s.usage.Output = u.Output
+	}
+	if u.CacheCreation > 0 {
+		s.usage.CacheCreation = u.CacheCreation
+		s.usage.CacheCreation1h = u.CacheCreation1h
+	}
+	if u.CacheRead > 0 {
+		s.usage.CacheRead = u.CacheRead
+	}
+}
+
+// Usage returns the usage observed so far.
+func (s *streamUsage) Usage() tokenUsage { return s.usage }
+
+// maxUsageLineBytes bounds the SSE line kept by usageTap. Usage is reported in
+// small control events, so longer lines (large content deltas) are skipped.
+const maxUsageLineBytes = 64 * 1024
+
+// usageTap is an io.Writer that is fed a raw SSE stream as it passes through
+// and extracts usage from its data lines. Only the current line is buffered.
+type usageTap struct {
+	streamUsage
+	line     []byte
+	overflow bool // the current line exceeded maxUsageLineBytes
+}
+
+func (t *usageTap) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.append(p)
+			break
+		}
+		t.append(p[:i])
+		if !t.overflow {
+			t.observeLine(t.line)
+		}
+		t.line, t.overflow = t.line[:0], false
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (t *usageTap) append(p []byte) {
+	if t.overflow {
+		return
+	}
+	if len(t.line)+len(p) > maxUsageLineBytes {
+		t.overflow = true
+		t.line = t.line[:0]
+		return
+	}
+	t.line = append(t.line, p...)
+}
+
+// Flush observes a final line that was not newline-terminated.
+func (t *usageTap) Flush() {
+	if !t.overflow {
+		t.observeLine(t.line)
+	}
+	t.line, t.overflow = t.line[:0], false
+}
+
+func (t *usageTap) observeLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if bytes.HasPrefix(line, []byte("data:")) {
+		t.Observe(bytes.TrimSpace(line[len("data:"):]))
+	}
+}
+
+// usageFromAnthropic converts Anthropic wire usage.
+func usageFromAnthropic(u anthropicUsage) tokenUsage {
+	t := tokenUsage{
+		Input:         u.InputTokens,
+		Output:        u.OutputTokens,
+		CacheCreation: u.CacheCreationInputTokens,
+		CacheRead:     u.CacheReadInputTokens,
+	}
+	if u.CacheCreation != nil {
+		t.CacheCreation1h = u.CacheCreation.Ephemeral1hInputTokens
+	}
+	return t
+}
@@ -0,0 +1,265 @@
+package proxy_test
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/auth"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/middleware"
+	"github.com/wjzhangq/claude-gateway/internal/pricing"
+	"github.com/wjzhangq/claude-gateway/internal/proxy"
+	"github.com/wjzhangq/claude-gateway/internal/stats"
+)
+
+// usageRecorder captures the usage records emitted by a handler.
+type usageRecorder struct {
+	mu      sync.Mutex
+	records []stats.Record
+}
+
+func (u *usageRecorder) last(t *testing.T) stats.Record {
+	t.Helper()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.records) == 0 {
+		t.Fatal("no usage record emitted")
+	}
+	return u.records[len(u.records)-1]
+}
+
+// newRecordingRouter routes /v1/* to a single upstream as an authenticated key,
+// recording every emitted usage record.
+func newRecordingRouter(t *testing.T, upstream *httptest.Server, protocol string) (*gin.Engine, *usageRecorder) {
+	t.Helper()
+	return newRewritingRouter(t, upstream, protocol, nil)
+}
+
+// newRewritingRouter is newRecordingRouter with model rewriting.
+func newRewritingRouter(t *testing.T, upstream *httptest.Server, protocol string, rewriter *proxy.Rewriter) (*gin.Engine, *usageRecorder) {
+	t.Helper()
+	database, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	rec := &usageRecorder{}
+	collector := stats.NewCollector(database, config.UsageLogConfig{BufferSize: 16, BatchSize: 1, FlushInterval: 10 * time.Millisecond})
+	t.Cleanup(func() { collector.Close(context.Background()) })
+	collector.Subscribe(func(r stats.Record) {
+		rec.mu.Lock()
+		rec.records = append(rec.records, r)
+		rec.mu.Unlock()
+	})
+
+	lb := proxy.NewLoadBalancer([]config.BackendAPI{
+		{Name: "upstream", URL: upstream.URL, APIKey: "k", Weight: 1, Enabled: true, Protocol: protocol},
+	}, config.HealthCheckConfig{})
+	prices := pricing.NewTable()
+	if err := prices.Load(pricing.Defaults()); err != nil {
+		t.Fatalf("load prices: %v", err)
+	}
+	h := proxy.NewHandler(lb, collector, rewriter, []string{"*"}, config.RetryConfig{MaxAttempts: 1, Timeout: time.Minute}, prices)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.RequestID())
+	r.Use(func(c *gin.Context) {
+		c.Set(middleware.CtxKeyInfo, &auth.KeyInfo{KeyID: 1, UserID: 1, Itcode: "alice"})
+	})
+	r.Any("/v1/*path", h.Passthrough)
+	return r, rec
+}
+
+func TestStreamUsage_MergesAnthropicEvents(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(strings.Join([]string{
+			`event: message_start`,
+			`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":100,"cache_creation_input_tokens":2000,"cache_read_input_tokens":50000,"output_tokens":1}}}`,
+			``,
+			`event: content_block_delta`,
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+			``,
+			`event: message_delta`,
+			`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":300}}`,
+			``,
+			`event: message_stop`,
+			`data: {"type":"message_stop"}`,
+			``,
+		}, "\n") + "\n"))
+	}))
+	defer upstream.Close()
+
+	r, rec := newRecordingRouter(t, upstream, "anthropic")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4","stream":true}`))
+	r.ServeHTTP(w, req)
+
+	got := rec.last(t)
+	if got.InputTokens != 100 || got.OutputTokens != 300 || got.CacheCreationTokens != 2000 || got.CacheReadTokens != 50000 {
+		t.Fatalf("unexpected usage record: %+v", got)
+	}
+	if got.TotalTokens != 52400 {
+		t.Fatalf("expected total 52400, got %d", got.TotalTokens)
+	}
+	// Sonnet: $3/M input, $15/M output, $3.75/M cache writes, $0.30/M cache reads.
+	want := (100*3.0 + 2000*3.75 + 50000*0.3 + 300*15.0) / 1_000_000
+	if math.Abs(got.CostUSD-want) > 1e-9 {
+		t.Fatalf("expected cost %v, got %v", want, got.CostUSD)
+	}
+}
+
+func TestBufferedUsage_BillsOneHourCacheWrites(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":10,"output_tokens":20,"cache_creation_input_tokens":3000,` +
+			`"cache_creation":{"ephemeral_5m_input_tokens":1000,"ephemeral_1h_input_tokens":2000}}}`))
+	}))
+	defer upstream.Close()
+
+	r, rec := newRecordingRouter(t, upstream, "anthropic")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4"}`))
+	r.ServeHTTP(w, req)
+
+	got := rec.last(t)
+	if got.CacheCreationTokens != 3000 || got.CacheWrite1hTokens != 2000 {
+		t.Fatalf("unexpected usage record: %+v", got)
+	}
+	// Sonnet: 5-minute cache writes $3.75/M, 1-hour writes twice the $3/M input price.
+	want := (10*3.0 + 20*15.0 + 1000*3.75 + 2000*6.0) / 1_000_000
+	if math.Abs(got.CostUSD-want) > 1e-9 {
+		t.Fatalf("expected cost %v, got %v", want, got.CostUSD)
+	}
+}
+
+func TestBufferedUsage_SplitsOpenAICachedTokens(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"prompt_tokens":1000,"completion_tokens":10,"prompt_tokens_details":{"cached_tokens":800}}}`))
+	}))
+	defer upstream.Close()
+
+	r, rec := newRecordingRouter(t, upstream, "openai")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	r.ServeHTTP(w, req)
+
+	got := rec.last(t)
+	if got.InputTokens != 200 || got.CacheReadTokens != 800 || got.OutputTokens != 10 || got.TotalTokens != 1010 {
+		t.Fatalf("unexpected usage record: %+v", got)
+	}
+}
+
+func TestStreamResponse_ClientAbortCancelsUpstream(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		w.Header().Set("Content-Type", "text/event-stream")
+		first := "event: message_start\n" +
+			`data: {"type":"message_start","message":{"usage":{"input_tokens":42,"output_tokens":1}}}` + "\n\n"
+		// Deliver the event in small pieces so usage must be parsed across reads.
+		for i := 0; i < len(first); i += 7 {
+			end := min(i+7, len(first))
+			w.Write([]byte(first[i:end]))
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			t.Error("upstream request was not cancelled after the client disconnected")
+		}
+	}))
+	defer upstream.Close()
+
+	r, rec := newRecordingRouter(t, upstream, "anthropic")
+	gateway := httptest.NewServer(r)
+	defer gateway.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, gateway.URL+"/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4","stream":true}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if line != "event: message_start\n" {
+		t.Fatalf("unexpected first line %q", line)
+	}
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream handler did not finish")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec.mu.Lock()
+		n := len(rec.records)
+		rec.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := rec.last(t)
+	if !got.ClientAborted || got.InputTokens != 42 {
+		t.Fatalf("expected aborted record with 42 input tokens, got %+v", got)
+	}
+}
+
+func TestHandler_CorrelatesRequestIDs(t *testing.T) {
+	var sent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get("X-Request-Id")
+		w.Header().Set("Request-Id", "req_upstream")
+		w.Header().Set("X-Request-Id", "upstream-generated")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+	r, rec := newRecordingRouter(t, upstream, "anthropic")
+
+	send := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5"}`))
+		req.Header.Set("X-Request-Id", id)
+		req.Header.Set("User-Agent", "test-client/1.0")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("ticket-42")
+	if got := w.Header().Get("X-Request-Id"); got != "ticket-42" || sent != "ticket-42" {
+		t.Fatalf("expected the client's request ID to be kept and forwarded, got response %q upstream %q", got, sent)
+	}
+	if got := w.Header().Get("Request-Id"); got != "req_upstream" {
+		t.Fatalf("expected the backend's request-id to reach the client, got %q", got)
+	}
+	u := rec.last(t)
+	if u.RequestID != "ticket-42" || u.UpstreamRequestID != "req_upstream" || u.UserAgent != "test-client/1.0" {
+		t.Fatalf("unexpected correlation fields: %+v", u)
+	}
+
+	// An unusable client ID is replaced with a generated one.
+	w = send("bad id\twith spaces")
+	if got := w.Header().Get("X-Request-Id"); got == "" || strings.ContainsAny(got, " \t") || got != sent {
+		t.Fatalf("expected a generated request ID, got response %q upstream %q", got, sent)
+	}
+}
@@ -0,0 +1,154 @@
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Reset periods accepted by NewTracker.
+const (
+	PeriodMonthly = "monthly"
+	PeriodWeekly  = "weekly"
+	PeriodNever   = "never"
+)
+
+// Status describes a user's quota position in the current period.
+type Status struct {
+	Limit     int64      `json:"limit"` // 0 = unlimited
+	Used      int64      `json:"used"`
+	Remaining int64      `json:"remaining"` // -1 when unlimited
+	Exceeded  bool       `json:"exceeded"`
+	ResetAt   *time.Time `json:"reset_at"` // nil when the period never resets
+}
+
+// Tracker keeps per-user token consumption for the current quota period in memory.
+// It is seeded from the database at startup and kept current from usage records.
+type Tracker struct {
+	mu          sync.Mutex
+	period      string
+	periodStart time.Time
+	used        map[int64]int64
+}
+
+// NewTracker creates a Tracker for the given reset period (monthly | weekly | never).
+func NewTracker(period string) (*Tracker, error) {
+	switch period {
+	case PeriodMonthly, PeriodWeekly, PeriodNever:
+	default:
+		return nil, fmt.Errorf("unknown quota reset period %q", period)
+	}
+	t := &Tracker{
+		period: period,
+		used:   make(map[int64]int64),
+	}
+	t.periodStart = t.startOf(time.Now())
+	return t, nil
+}
+
+// PeriodStart returns the start of the current quota period.
+// The zero time is returned for the "never" period.
+func (t *Tracker) PeriodStart() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.periodStart
+}
+
+// Seed replaces the in-memory counters with usage already consumed in the current period.
+func (t *Tracker) Seed(used map[int64]int64) {
+	m := make(map[int64]int64, len(used))
+	for id, n := range used {
+		m[id] = n
+	}
+	t.mu.Lock()
+	t.used = m
+	t.mu.Unlock()
+}
+
+// Add records tokens consumed by a user.
+func (t *Tracker) Add(userID, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.rollover()
+	t.used[userID] += tokens
+	t.mu.Unlock()
+}
+
+// Used returns the tokens consumed by a user in the current period.
+func (t *Tracker) Used(userID int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.used[userID]
+}
+
+// Exceeded reports whether a user with the given limit has used up their quota.
+// A limit of 0 means unlimited.
+func (t *Tracker) Exceeded(userID, limit int64) bool {
+	if limit <= 0 {
+		return false
+	}
+	return t.Used(userID) >= limit
+}
+
+// Status returns the quota position of a user with the given limit.
+func (t *Tracker) Status(userID, limit int64) Status {
+	t.mu.Lock()
+	t.rollover()
+	used := t.used[userID]
+	start := t.periodStart
+	t.mu.Unlock()
+
+	s := Status{Limit: limit, Used: used, Remaining: -1}
+	if limit > 0 {
+		s.Remaining = limit - used
+		if s.Remaining < 0 {
+			s.Remaining = 0
+		}
+		s.Exceeded = used >= limit
+	}
+	if reset := t.nextStart(start); !reset.IsZero() {
+		s.ResetAt = &reset
+	}
+	return s
+}
+
+// rollover clears the counters when the current period has ended. Caller holds mu.
+func (t *Tracker) rollover() {
+	start := t.startOf(time.Now())
+	if start.Equal(t.periodStart) {
+		return
+	}
+	t.periodStart = start
+	t.used = make(map[int64]int64)
+}
+
+// startOf returns the beginning of the period containing now.
+func (t *Tracker) startOf(now time.Time) time.Time {
+	y, m, d := now.Date()
+	switch t.period {
+	case PeriodMonthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	case PeriodWeekly:
+		// Weeks start on Monday.
+		offset := (int(now.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, now.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// nextStart returns the beginning of the period after the one starting at start.
+func (t *Tracker) nextStart(start time.Time) time.Time {
+	switch t.period {
+	case PeriodMonthly:
+		return start.AddDate(0, 1, 0)
+	case PeriodWeekly:
+		return start.AddDate(0, 0, 7)
+	default:
+		return time.Time{}
+	}
+}
@@ -0,0 +1,67 @@
+package quota_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/quota"
+)
+
+func TestTracker_AddAndExceeded(t *testing.T) {
+	tr, err := quota.NewTracker(quota.PeriodMonthly)
+	if err != nil {
+		t.Fatalf("new tracker: %v", err)
+	}
+
+	tr.Seed(map[int64]int64{1: 900})
+	if tr.Exceeded(1, 1000) {
+		t.Fatal("expected quota not exceeded at 900/1000")
+	}
+
+	tr.Add(1, 100)
+	if !tr.Exceeded(1, 1000) {
+		t.Fatal("expected quota exceeded at 1000/1000")
+	}
+
+	// A limit of 0 means unlimited.
+	if tr.Exceeded(1, 0) {
+		t.Fatal("expected unlimited quota never to be exceeded")
+	}
+}
+
+func TestTracker_Status(t *testing.T) {
+	tr, _ := quota.NewTracker(quota.PeriodWeekly)
+	tr.Add(7, 250)
+
+	st := tr.Status(7, 1000)
+	if st.Used != 250 || st.Remaining != 750 || st.Exceeded {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if st.ResetAt == nil || !st.ResetAt.After(time.Now()) {
+		t.Fatalf("expected reset time in the future, got %v", st.ResetAt)
+	}
+	if st.ResetAt.Weekday() != time.Monday {
+		t.Fatalf("expected weekly reset on Monday, got %s", st.ResetAt.Weekday())
+	}
+
+	st = tr.Status(7, 0)
+	if st.Remaining != -1 {
+		t.Fatalf("expected remaining -1 for unlimited, got %d", st.Remaining)
+	}
+}
+
+func TestTracker_NeverResets(t *testing.T) {
+	tr, _ := quota.NewTracker(quota.PeriodNever)
+	if !tr.PeriodStart().IsZero() {
+		t.Fatal("expected zero period start for never-resetting quota")
+	}
+	if st := tr.Status(1, 10); st.ResetAt != nil {
+		t.Fatalf("expected no reset time, got %v", st.ResetAt)
+	}
+}
+
+func TestNewTracker_InvalidPeriod(t *testing.T) {
+	if _, err := quota.NewTracker("daily"); err == nil {
+		t.Fatal("expected error for unknown period")
+	}
+}
@@ -0,0 +1,17 @@
+package ratelimit
+
+import "time"
+
+// SetClock makes l read the time from now.
+func (l *Limiter) SetClock(now func() time.Time) {
+	l.mu.Lock()
+	l.now = now
+	l.mu.Unlock()
+}
+
+// Tracked returns the number of subjects l keeps state for.
+func (l *Limiter) Tracked() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.states)
+}
@@ -0,0 +1,320 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/model"
+)
+
+// Scopes a limit can apply to.
+const (
+	ScopeUser = "user"
+	ScopeKey  = "key"
+)
+
+// Limits caps the traffic of one user or API key. Zero fields are unlimited.
+type Limits struct {
+	RequestsPerMinute     int64
+	InputTokensPerMinute  int64
+	OutputTokensPerMinute int64
+	MaxConcurrent         int64
+}
+
+func (l Limits) unlimited() bool {
+	return l == Limits{}
+}
+
+// Subject identifies a user or an API key.
+type Subject struct {
+	Scope string
+	ID    int64
+}
+
+func (s Subject) String() string {
+	if s.Scope == ScopeKey {
+		return "API key"
+	}
+	return "user"
+}
+
+// Window is the state of one rate-limited dimension, as reported to clients.
+type Window struct {
+	Limit     int64 // 0 when no limit applies
+	Remaining int64
+	Reset     time.Time // when the bucket will be full again
+}
+
+// Decision is the outcome of Acquire. Windows report the most constrained
+// subject for each dimension.
+type Decision struct {
+	Allowed      bool
+	Reason       string        // set when not allowed
+	RetryAfter   time.Duration // set when not allowed
+	Requests     Window
+	InputTokens  Window
+	OutputTokens Window
+}
+
+// Limiter enforces per-user and per-key request, token and concurrency limits.
+//
+// Requests and tokens use token buckets that refill continuously at the
+// per-minute rate. Token counts are only known once a response completes, so
+// token buckets are debited afterwards and may go negative; new requests are
+// admitted only while the bucket is positive.
+type Limiter struct {
+	mu        sync.Mutex
+	defaults  map[string]Limits
+	overrides map[Subject]Limits
+	states    map[Subject]*state
+	pruned    time.Time // when idle states were last dropped
+	now       func() time.Time
+}
+
+// pruneInterval is how often Acquire drops the state of idle subjects, so
+// the limiter does not keep one for every key that was ever used.
+const pruneInterval = time.Minute
+
+type state struct {
+	requests bucket
+	input    bucket
+	output   bucket
+	inFlight int64
+}
+
+// bucket holds a level that refills by capacity every minute, up to capacity.
+type bucket struct {
+	level   float64
+	updated time.Time
+	primed  bool
+}
+
+// NewLimiter creates a Limiter applying userDefaults and keyDefaults to subjects
+// without an override.
+func NewLimiter(userDefaults, keyDefaults Limits) *Limiter {
+	return &Limiter{
+		defaults:  map[string]Limits{ScopeUser: userDefaults, ScopeKey: keyDefaults},
+		overrides: make(map[Subject]Limits),
+		states:    make(map[Subject]*state),
+		now:       time.Now,
+	}
+}
+
+// SetDefaults replaces the limits of subjects without an override.
+func (l *Limiter) SetDefaults(userDefaults, keyDefaults Limits) {
+	l.mu.Lock()
+	l.defaults = map[string]Limits{ScopeUser: userDefaults, ScopeKey: keyDefaults}
+	l.mu.Unlock()
+}
+
+// SetOverrides replaces the per-subject limits, typically loaded from the rate_limits table.
+func (l *Limiter) SetOverrides(rows []*model.RateLimit) {
+	m := make(map[Subject]Limits, len(rows))
+	for _, r := range rows {
+		m[Subject{Scope: r.Scope, ID: r.SubjectID}] = Limits{
+			RequestsPerMinute:     r.RequestsPerMinute,
+			InputTokensPerMinute:  r.InputTokensPerMinute,
+			OutputTokensPerMinute: r.OutputTokensPerMinute,
+			MaxConcurrent:         r.MaxConcurrent,
+		}
+	}
+	l.mu.Lock()
+	l.overrides = m
+	l.mu.Unlock()
+}
+
+// Limits returns the limits in force for s.
+func (l *Limiter) Limits(s Subject) Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limitsLocked(s)
+}
+
+func (l *Limiter) limitsLocked(s Subject) Limits {
+	if lim, ok := l.overrides[s]; ok {
+		return lim
+	}
+	return l.defaults[s.Scope]
+}
+
+// Acquire admits a request on behalf of all subjects, or none of them. When
+// allowed, release must be called once the request has finished.
+func (l *Limiter) Acquire(subjects ...Subject) (d Decision, release func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.pruneLocked(now)
+
+	type admitted struct {
+		st  *state
+		lim Limits
+	}
+	var checked []admitted
+	d.Allowed = true
+	for _, s := range subjects {
+		lim := l.limitsLocked(s)
+		if lim.unlimited() {
+			continue
+		}
+		st := l.states[s]
+		if st == nil {
+			st = &state{}
+			l.states[s] = st
+		}
+		st.requests.refill(lim.RequestsPerMinute, now)
+		st.input.refill(lim.InputTokensPerMinute, now)
+		st.output.refill(lim.OutputTokensPerMinute, now)
+
+		if d.Allowed {
+			if reason, wait := st.check(lim, s); reason != "" {
+				d.Allowed, d.Reason, d.RetryAfter = false, reason, wait
+			}
+		}
+		tighten(&d.Requests, &st.requests, lim.RequestsPerMinute, now)
+		tighten(&d.InputTokens, &st.input, lim.InputTokensPerMinute, now)
+		tighten(&d.OutputTokens, &st.output, lim.OutputTokensPerMinute, now)
+		checked = append(checked, admitted{st, lim})
+	}
+	if !d.Allowed {
+		return d, nil
+	}
+
+	for _, a := range checked {
+		if a.lim.RequestsPerMinute > 0 {
+			a.st.requests.level--
+		}
+		a.st.inFlight++
+	}
+	if d.Requests.Limit > 0 && d.Requests.Remaining > 0 {
+		d.Requests.Remaining--
+	}
+
+	var once sync.Once
+	return d, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			for _, a := range checked {
+				a.st.inFlight--
+			}
+			l.mu.Unlock()
+		})
+	}
+}
+
+// pruneLocked drops the states that are idle, at most once per pruneInterval.
+func (l *Limiter) pruneLocked(now time.Time) {
+	if now.Sub(l.pruned) < pruneInterval {
+		return
+	}
+	l.pruned = now
+	for s, st := range l.states {
+		if st.idle(l.limitsLocked(s), now) {
+			delete(l.states, s)
+		}
+	}
+}
+
+// idle reports whether st has no requests in flight and full buckets, which
+// makes it the same as the state a new subject starts with.
+func (st *state) idle(lim Limits, now time.Time) bool {
+	return st.inFlight == 0 &&
+		st.requests.full(lim.RequestsPerMinute, now) &&
+		st.input.full(lim.InputTokensPerMinute, now) &&
+		st.output.full(lim.OutputTokensPerMinute, now)
+}
+
+// check returns why st cannot admit another request and how long to wait, or
+// an empty reason if it can.
+func (st *state) check(lim Limits, s Subject) (string, time.Duration) {
+	switch {
+	case lim.MaxConcurrent > 0 && st.inFlight >= lim.MaxConcurrent:
+		return fmt.Sprintf("%s has reached its limit of %d concurrent requests", s, lim.MaxConcurrent), time.Second
+	case lim.RequestsPerMinute > 0 && st.requests.level < 1:
+		return fmt.Sprintf("%s has exceeded its rate limit of %d requests per minute", s, lim.RequestsPerMinute),
+			st.requests.wait(1, lim.RequestsPerMinute)
+	case lim.InputTokensPerMinute > 0 && st.input.level <= 0:
+		return fmt.Sprintf("%s has exceeded its rate limit of %d input tokens per minute", s, lim.InputTokensPerMinute),
+			st.input.wait(1, lim.InputTokensPerMinute)
+	case lim.OutputTokensPerMinute > 0 && st.output.level <= 0:
+		return fmt.Sprintf("%s has exceeded its rate limit of %d output tokens per minute", s, lim.OutputTokensPerMinute),
+			st.output.wait(1, lim.OutputTokensPerMinute)
+	}
+	return "", 0
+}
+
+// Record debits tokens consumed by a completed request from every subject's buckets.
+func (l *Limiter) Record(inputTokens, outputTokens int64, subjects ...Subject) {
+	if inputTokens <= 0 && outputTokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for _, s := range subjects {
+		st := l.states[s]
+		if st == nil {
+			continue
+		}
+		lim := l.limitsLocked(s)
+		if lim.InputTokensPerMinute > 0 {
+			st.input.refill(lim.InputTokensPerMinute, now)
+			st.input.level -= float64(inputTokens)
+		}
+		if lim.OutputTokensPerMinute > 0 {
+			st.output.refill(lim.OutputTokensPerMinute, now)
+			st.output.level -= float64(outputTokens)
+		}
+	}
+}
+
+// refill tops the bucket up for the time elapsed since the last update. A new
+// bucket starts full; a bucket whose capacity shrank is clamped to it.
+func (b *bucket) refill(capacity int64, now time.Time) {
+	if capacity <= 0 {
+		return
+	}
+	c := float64(capacity)
+	if !b.primed {
+		b.level, b.updated, b.primed = c, now, true
+		return
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.level += elapsed.Minutes() * c
+	}
+	b.level = math.Min(b.level, c)
+	b.updated = now
+}
+
+// full reports whether the bucket is at capacity once refilled.
+func (b *bucket) full(capacity int64, now time.Time) bool {
+	if capacity <= 0 || !b.primed {
+		return true
+	}
+	b.refill(capacity, now)
+	return b.level >= float64(capacity)
+}
+
+// wait returns how long until the bucket reaches level target.
+func (b *bucket) wait(target float64, capacity int64) time.Duration {
+	missing := target - b.level
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / float64(capacity) * float64(time.Minute))
+}
+
+// tighten replaces w with the state of b if b is more constrained.
+func tighten(w *Window, b *bucket, capacity int64, now time.Time) {
+	if capacity <= 0 {
+		return
+	}
+	remaining := int64(math.Max(0, math.Floor(b.level)))
+	if w.Limit > 0 && (remaining > w.Remaining || remaining == w.Remaining && capacity >= w.Limit) {
+		return
+	}
+	w.Limit = capacity
+	w.Remaining = remaining
+	w.Reset = now.Add(b.wait(float64(capacity), capacity))
+}
@@ -0,0 +1,118 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/internal/model"
+	"github.com/wjzhangq/claude-gateway/internal/ratelimit"
+)
+
+var (
+	user = ratelimit.Subject{Scope: ratelimit.ScopeUser, ID: 1}
+	key  = ratelimit.Subject{Scope: ratelimit.ScopeKey, ID: 10}
+)
+
+func TestLimiter_RequestsPerMinute(t *testing.T) {
+	l := ratelimit.NewLimiter(ratelimit.Limits{}, ratelimit.Limits{RequestsPerMinute: 2})
+
+	for i := 0; i < 2; i++ {
+		d, release := l.Acquire(user, key)
+		if !d.Allowed {
+			t.Fatalf("request %d: expected allowed, got %q", i+1, d.Reason)
+		}
+		release()
+		if d.Requests.Limit != 2 || d.Requests.Remaining != int64(1-i) {
+			t.Fatalf("request %d: unexpected window %+v", i+1, d.Requests)
+		}
+	}
+
+	d, release := l.Acquire(user, key)
+	if d.Allowed || release != nil {
+		t.Fatal("expected third request to be rejected")
+	}
+	// One request refills every 30s.
+	if d.RetryAfter < 29*time.Second || d.RetryAfter > 30*time.Second {
+		t.Fatalf("unexpected retry after %s", d.RetryAfter)
+	}
+
+	// Another key of the same user is not affected.
+	if d, _ := l.Acquire(user, ratelimit.Subject{Scope: ratelimit.ScopeKey, ID: 11}); !d.Allowed {
+		t.Fatalf("expected other key to be allowed, got %q", d.Reason)
+	}
+}
+
+func TestLimiter_TokensAreDebitedAfterTheFact(t *testing.T) {
+	l := ratelimit.NewLimiter(ratelimit.Limits{OutputTokensPerMinute: 600}, ratelimit.Limits{})
+
+	d, release := l.Acquire(user, key)
+	if !d.Allowed {
+		t.Fatalf("expected allowed, got %q", d.Reason)
+	}
+	release()
+	l.Record(0, 900, user, key)
+
+	d, _ = l.Acquire(user, key)
+	if d.Allowed {
+		t.Fatal("expected rejection while the output token bucket is in debt")
+	}
+	// 300 tokens of debt at 10 tokens per second.
+	if d.RetryAfter < 30*time.Second || d.RetryAfter > 31*time.Second {
+		t.Fatalf("unexpected retry after %s", d.RetryAfter)
+	}
+	if d.OutputTokens.Limit != 600 || d.OutputTokens.Remaining != 0 {
+		t.Fatalf("unexpected output window %+v", d.OutputTokens)
+	}
+}
+
+func TestLimiter_MaxConcurrent(t *testing.T) {
+	l := ratelimit.NewLimiter(ratelimit.Limits{}, ratelimit.Limits{})
+	l.SetOverrides([]*model.RateLimit{{Scope: ratelimit.ScopeUser, SubjectID: 1, MaxConcurrent: 1}})
+
+	d, release := l.Acquire(user, key)
+	if !d.Allowed {
+		t.Fatalf("expected allowed, got %q", d.Reason)
+	}
+	if d, _ := l.Acquire(user, key); d.Allowed {
+		t.Fatal("expected second concurrent request to be rejected")
+	}
+	release()
+	release() // releasing twice is harmless
+	if d, _ := l.Acquire(user, key); !d.Allowed {
+		t.Fatalf("expected allowed after release, got %q", d.Reason)
+	}
+
+	// Other users keep the unlimited default.
+	other := ratelimit.Subject{Scope: ratelimit.ScopeUser, ID: 2}
+	if d, _ := l.Acquire(other); !d.Allowed {
+		t.Fatalf("expected other user to be allowed, got %q", d.Reason)
+	}
+}
+
+func TestLimiter_PrunesIdleSubjects(t *testing.T) {
+	l := ratelimit.NewLimiter(ratelimit.Limits{}, ratelimit.Limits{RequestsPerMinute: 60})
+	now := time.Now()
+	l.SetClock(func() time.Time { return now })
+
+	busy := ratelimit.Subject{Scope: ratelimit.ScopeKey, ID: 11}
+	_, release := l.Acquire(key)
+	release()
+	_, hold := l.Acquire(busy)
+	if got := l.Tracked(); got != 2 {
+		t.Fatalf("expected 2 tracked subjects, got %d", got)
+	}
+
+	// Both buckets have refilled, but busy still has a request in flight.
+	now = now.Add(2 * time.Minute)
+	l.Acquire(user)
+	if got := l.Tracked(); got != 1 {
+		t.Fatalf("expected only the subject with a request in flight to be kept, got %d", got)
+	}
+
+	hold()
+	now = now.Add(2 * time.Minute)
+	l.Acquire(user)
+	if got := l.Tracked(); got != 0 {
+		t.Fatalf("expected idle subjects to be dropped, got %d", got)
+	}
+}
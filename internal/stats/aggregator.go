@@ -11,10 +11,12 @@ import (
 type Aggregator struct {
 	db       *db.DB
 	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
 }
 
 func NewAggregator(database *db.DB, interval time.Duration) *Aggregator {
-	return &Aggregator{db: database, interval: interval}
+	return &Aggregator{db: database, interval: interval, stop: make(chan struct{}), done: make(chan struct{})}
 }
 
 // Start launches the aggregation loop in the background.
@@ -22,13 +24,27 @@ func (a *Aggregator) Start() {
 	go a.loop()
 }
 
+// Stop ends the aggregation loop after a final run, so usage written during
+// shutdown is reflected in daily_stats. It must be called at most once, after Start.
+func (a *Aggregator) Stop() {
+	close(a.stop)
+	<-a.done
+}
+
 func (a *Aggregator) loop() {
+	defer close(a.done)
 	// Run once immediately on startup, then on interval.
 	a.run()
 	ticker := time.NewTicker(a.interval)
 	defer ticker.Stop()
-	for range ticker.C {
-		a.run()
+	for {
+		select {
+		case <-ticker.C:
+			a.run()
+		case <-a.stop:
+			a.run()
+			return
+		}
 	}
 }
 
@@ -1,8 +1,20 @@
 package stats
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/wjzhangq/claude-gateway/config"
 	"github.com/wjzhangq/claude-gateway/internal/db"
 	"github.com/wjzhangq/claude-gateway/internal/logger"
 	"github.com/wjzhangq/claude-gateway/internal/model"
@@ -10,59 +22,419 @@ import (
 
 // Record holds the data for a single API call to be persisted.
 type Record struct {
-	UserID       int64
-	APIKeyID     int64
-	Model        string
-	Backend      string
-	InputTokens  int
-	OutputTokens int
-	TotalTokens  int
-	CostUSD      float64
-	StatusCode   int
-	Latency      time.Duration
-}
-
-// Collector receives usage records asynchronously and batch-writes them to the DB.
+	UserID   int64
+	APIKeyID int64
+	Model    string
+	// RequestedModel is the model the client asked for; Model is what was sent
+	// upstream after rewriting.
+	RequestedModel string
+	Backend        string
+	InputTokens    int
+	OutputTokens   int
+	// Prompt-cache writes and reads; not included in InputTokens.
+	// CacheWrite1hTokens is the part of CacheCreationTokens written to the
+	// 1-hour cache.
+	CacheCreationTokens int
+	CacheReadTokens     int
+	CacheWrite1hTokens  int
+	TotalTokens         int
+	CostUSD             float64
+	StatusCode          int
+	Latency             time.Duration
+	// ClientAborted is set when the client disconnected before the response completed.
+	ClientAborted bool
+	// RequestID is the gateway's request ID; UpstreamRequestID is the one the
+	// backend returned, if any.
+	RequestID         string
+	UpstreamRequestID string
+	ClientIP          string
+	UserAgent         string
+	// CreatedAt is when the request finished; Emit sets it when zero.
+	CreatedAt time.Time
+	// TimeToFirstByte is how long a streamed response took to deliver its first
+	// bytes; zero for non-streamed responses. It is not persisted.
+	TimeToFirstByte time.Duration `json:"-"`
+}
+
+// CollectorStats is a snapshot of the collector's counters.
+type CollectorStats struct {
+	Queued            int     `json:"queued"`  // records waiting in memory
+	Written           int64   `json:"written"` // records written to the database
+	Spilled           int64   `json:"spilled"` // records written to the spill file
+	Dropped           int64   `json:"dropped"` // records lost; only on spill file failures, corrupt spill lines or after Close
+	Batches           int64   `json:"batches"` // database transactions committed
+	LastBatchLatency  float64 `json:"last_batch_latency_ms"`
+	TotalBatchLatency float64 `json:"total_batch_latency_ms"`
+}
+
+// Collector receives usage records asynchronously and writes them to the DB in
+// batched transactions.
+//
+// When the in-memory queue is full, or a batch cannot be written, records are
+// appended to a spill file on disk instead of being dropped. The spill file is
+// replayed into the database at startup and once the queue has room again.
+// Replay is at-least-once: a crash in the middle of a replay may duplicate rows.
 type Collector struct {
-	ch chan Record
-	db *db.DB
+	ch            chan Record
+	db            *db.DB
+	subscribers   []func(Record)
+	batchSize     int
+	flushInterval time.Duration
+	mu            sync.RWMutex // guards closed against concurrent Emit
+	closed        bool
+	done          chan struct{}
+
+	spillPath    string
+	spillMu      sync.Mutex
+	spillFile    *os.File
+	spillPending atomic.Bool
+
+	written      atomic.Int64
+	spilled      atomic.Int64
+	dropped      atomic.Int64
+	batches      atomic.Int64
+	lastBatchNs  atomic.Int64
+	totalBatchNs atomic.Int64
 }
 
 // NewCollector creates a Collector with a buffered channel and starts the worker.
-func NewCollector(database *db.DB, bufSize int) *Collector {
+func NewCollector(database *db.DB, cfg config.UsageLogConfig) *Collector {
 	c := &Collector{
-		ch: make(chan Record, bufSize),
-		db: database,
+		ch:            make(chan Record, max(cfg.BufferSize, 1)),
+		db:            database,
+		batchSize:     max(cfg.BatchSize, 1),
+		flushInterval: cfg.FlushInterval,
+		spillPath:     cfg.SpillPath,
+		done:          make(chan struct{}),
+	}
+	if c.flushInterval <= 0 {
+		c.flushInterval = time.Second
+	}
+	if c.spillPath != "" {
+		for _, p := range []string{c.spillPath, c.replayPath()} {
+			if _, err := os.Stat(p); err == nil {
+				c.spillPending.Store(true)
+			}
+		}
 	}
 	go c.worker()
 	return c
 }
 
-// Emit sends a record to the collector. Drops silently if the channel is full.
+// Subscribe registers fn to be called synchronously for every emitted record,
+// before it is queued for persistence. It must be called before the first Emit.
+func (c *Collector) Subscribe(fn func(Record)) {
+	c.subscribers = append(c.subscribers, fn)
+}
+
+// Emit queues a record for persistence without blocking. If the queue is full
+// the record is spilled to disk.
 func (c *Collector) Emit(r Record) {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	for _, fn := range c.subscribers {
+		fn(r)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		c.dropped.Add(1)
+		logger.Warn("stats collector closed, dropping record")
+		return
+	}
 	select {
 	case c.ch <- r:
 	default:
-		logger.Warn("stats collector channel full, dropping record")
+		c.spill([]Record{r})
+	}
+}
+
+// Stats returns the current counters.
+func (c *Collector) Stats() CollectorStats {
+	return CollectorStats{
+		Queued:            len(c.ch),
+		Written:           c.written.Load(),
+		Spilled:           c.spilled.Load(),
+		Dropped:           c.dropped.Load(),
+		Batches:           c.batches.Load(),
+		LastBatchLatency:  float64(c.lastBatchNs.Load()) / 1e6,
+		TotalBatchLatency: float64(c.totalBatchNs.Load()) / 1e6,
+	}
+}
+
+// Close stops accepting records and waits until every queued record has been
+// written, or ctx is done.
+func (c *Collector) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.ch)
+	}
+	c.mu.Unlock()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("stats collector: %d records not written: %w", len(c.ch), ctx.Err())
 	}
 }
 
 func (c *Collector) worker() {
-	for r := range c.ch {
-		log := &model.UsageLog{
-			UserID:       r.UserID,
-			APIKeyID:     r.APIKeyID,
-			Model:        r.Model,
-			Backend:      r.Backend,
-			InputTokens:  r.InputTokens,
-			OutputTokens: r.OutputTokens,
-			TotalTokens:  r.TotalTokens,
-			CostUSD:      r.CostUSD,
-			StatusCode:   r.StatusCode,
-			Latency:      r.Latency.Milliseconds(),
-		}
-		if err := c.db.InsertUsageLog(log); err != nil {
-			logger.Errorf("insert usage log: %v", err)
+	defer close(c.done)
+	defer c.closeSpill()
+
+	c.replaySpill()
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, c.batchSize)
+	for {
+		select {
+		case r, ok := <-c.ch:
+			if !ok {
+				c.flush(batch)
+				c.replaySpill()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= c.batchSize {
+				c.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			c.flush(batch)
+			batch = batch[:0]
+			// Replay spilled records only once the queue has drained below half.
+			if c.spillPending.Load() && len(c.ch) < cap(c.ch)/2 {
+				c.replaySpill()
+			}
+		}
+	}
+}
+
+// flush writes batch in one transaction. A batch that cannot be written is
+// spilled so it can be retried later.
+func (c *Collector) flush(batch []Record) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := c.write(batch); err != nil {
+		logger.Errorf("write %d usage logs: %v", len(batch), err)
+		c.spill(batch)
+	}
+}
+
+func (c *Collector) write(batch []Record) error {
+	logs := make([]*model.UsageLog, len(batch))
+	for i, r := range batch {
+		logs[i] = &model.UsageLog{
+			UserID:              r.UserID,
+			APIKeyID:            r.APIKeyID,
+			Model:               r.Model,
+			RequestedModel:      r.RequestedModel,
+			Backend:             r.Backend,
+			InputTokens:         r.InputTokens,
+			OutputTokens:        r.OutputTokens,
+			CacheCreationTokens: r.CacheCreationTokens,
+			CacheReadTokens:     r.CacheReadTokens,
+			CacheWrite1hTokens:  r.CacheWrite1hTokens,
+			TotalTokens:         r.TotalTokens,
+			CostUSD:             r.CostUSD,
+			StatusCode:          r.StatusCode,
+			Latency:             r.Latency.Milliseconds(),
+			ClientAborted:       r.ClientAborted,
+			RequestID:           r.RequestID,
+			UpstreamRequestID:   r.UpstreamRequestID,
+			ClientIP:            r.ClientIP,
+			UserAgent:           r.UserAgent,
+			CreatedAt:           r.CreatedAt,
+		}
+	}
+	start := time.Now()
+	if err := c.db.InsertUsageLogs(logs); err != nil {
+		return err
+	}
+	elapsed := time.Since(start).Nanoseconds()
+	c.lastBatchNs.Store(elapsed)
+	c.totalBatchNs.Add(elapsed)
+	c.batches.Add(1)
+	c.written.Add(int64(len(batch)))
+	return nil
+}
+
+// spill appends records to the spill file. Records are only dropped if no
+// spill file is configured or it cannot be written.
+func (c *Collector) spill(records []Record) {
+	c.spillMu.Lock()
+	defer c.spillMu.Unlock()
+
+	if err := c.appendSpill(records); err != nil {
+		c.dropped.Add(int64(len(records)))
+		logger.Errorf("stats collector: dropping %d usage records: %v", len(records), err)
+		return
+	}
+	c.spilled.Add(int64(len(records)))
+	c.spillPending.Store(true)
+}
+
+// appendSpill writes records as JSON lines. Caller holds spillMu.
+func (c *Collector) appendSpill(records []Record) error {
+	if c.spillPath == "" {
+		return errors.New("queue full and no spill file configured")
+	}
+	if c.spillFile == nil {
+		if err := os.MkdirAll(filepath.Dir(c.spillPath), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(c.spillPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		c.spillFile = f
+	}
+	var buf []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	_, err := c.spillFile.Write(buf)
+	return err
+}
+
+func (c *Collector) replayPath() string {
+	return c.spillPath + ".replay"
+}
+
+// replaySpill moves the spill file aside and writes its records to the
+// database. Records that still cannot be written are spilled again. If the
+// file cannot be read to the end, the unread part is kept for the next replay.
+func (c *Collector) replaySpill() {
+	if c.spillPath == "" || !c.spillPending.Load() {
+		return
+	}
+
+	// A leftover replay file means the previous replay did not finish; resume it first.
+	if _, err := os.Stat(c.replayPath()); errors.Is(err, os.ErrNotExist) {
+		c.spillMu.Lock()
+		c.closeSpillLocked()
+		err := os.Rename(c.spillPath, c.replayPath())
+		c.spillPending.Store(false)
+		c.spillMu.Unlock()
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			logger.Errorf("stats collector: move spill file: %v", err)
+			c.spillPending.Store(true)
+			return
+		}
+	}
+
+	f, err := os.Open(c.replayPath())
+	if err != nil {
+		logger.Errorf("stats collector: open spill file: %v", err)
+		c.spillPending.Store(true)
+		return
+	}
+	defer f.Close()
+
+	var replayed int
+	var read int64 // bytes of the complete lines read so far
+	batch := make([]Record, 0, c.batchSize)
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			c.flush(batch)
+			replayed += len(batch)
+			logger.Errorf("stats collector: read spill file after %d records: %v", replayed, err)
+			c.keepUnreplayed(read)
+			return
+		}
+		read += int64(len(line))
+		if len(bytes.TrimSpace(line)) > 0 {
+			var r Record
+			if err := json.Unmarshal(line, &r); err != nil {
+				logger.Errorf("stats collector: dropping corrupt spill line: %v", err)
+				c.dropped.Add(1)
+			} else {
+				batch = append(batch, r)
+			}
 		}
+		if len(batch) >= c.batchSize || err == io.EOF {
+			c.flush(batch)
+			replayed += len(batch)
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if err := os.Remove(c.replayPath()); err != nil {
+		logger.Errorf("stats collector: remove spill file: %v", err)
+	}
+	logger.Infof("stats collector: replayed %d spilled usage records", replayed)
+}
+
+// keepUnreplayed cuts the first offset bytes, already replayed, from the
+// replay file and leaves the rest to be replayed later. If that fails the
+// whole file is kept, and its replayed records will be written again.
+func (c *Collector) keepUnreplayed(offset int64) {
+	c.spillPending.Store(true)
+	if offset == 0 {
+		return
+	}
+	err := func() error {
+		src, err := os.Open(c.replayPath())
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		tmp := c.replayPath() + ".tmp"
+		dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		if err == nil {
+			err = dst.Sync()
+		}
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp, c.replayPath())
+		}
+		if err != nil {
+			os.Remove(tmp)
+		}
+		return err
+	}()
+	if err != nil {
+		logger.Errorf("stats collector: keep unreplayed spill records: %v; the whole file will be replayed again", err)
+	}
+}
+
+func (c *Collector) closeSpill() {
+	c.spillMu.Lock()
+	c.closeSpillLocked()
+	c.spillMu.Unlock()
+}
+
+func (c *Collector) closeSpillLocked() {
+	if c.spillFile == nil {
+		return
+	}
+	if err := c.spillFile.Sync(); err != nil {
+		logger.Errorf("stats collector: sync spill file: %v", err)
 	}
+	c.spillFile.Close()
+	c.spillFile = nil
 }
@@ -0,0 +1,134 @@
+package stats_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wjzhangq/claude-gateway/config"
+	"github.com/wjzhangq/claude-gateway/internal/db"
+	"github.com/wjzhangq/claude-gateway/internal/stats"
+)
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.Init(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	// Usage listings join on users; records in these tests belong to user 1.
+	if err := database.EnsureAdmin("tester"); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return database
+}
+
+func countUsageLogs(t *testing.T, database *db.DB) int {
+	t.Helper()
+	_, total, err := database.ListUsageLogs(0, "", "", "", "", 1, 1)
+	if err != nil {
+		t.Fatalf("list usage logs: %v", err)
+	}
+	return total
+}
+
+func TestCollector_BatchesAndSpillsWithoutDropping(t *testing.T) {
+	database := newTestDB(t)
+	c := stats.NewCollector(database, config.UsageLogConfig{
+		BufferSize:    2,
+		BatchSize:     50,
+		FlushInterval: time.Hour,
+		SpillPath:     filepath.Join(t.TempDir(), "spill.jsonl"),
+	})
+
+	const n = 500
+	for i := 0; i < n; i++ {
+		c.Emit(stats.Record{UserID: 1, APIKeyID: 1, Model: "claude-sonnet-4-5", InputTokens: 10, OutputTokens: 5, TotalTokens: 15, StatusCode: 200})
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	s := c.Stats()
+	if s.Dropped != 0 {
+		t.Errorf("expected no dropped records, got %d", s.Dropped)
+	}
+	if s.Written != n {
+		t.Errorf("expected %d written, got %d (spilled %d)", n, s.Written, s.Spilled)
+	}
+	if s.Batches >= n {
+		t.Errorf("expected records to be batched, got %d batches for %d records", s.Batches, n)
+	}
+	if got := countUsageLogs(t, database); got != n {
+		t.Errorf("expected %d rows, got %d", n, got)
+	}
+}
+
+func TestCollector_ReplaysSpillFileOnStart(t *testing.T) {
+	database := newTestDB(t)
+	spillPath := filepath.Join(t.TempDir(), "spill.jsonl")
+
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var data []byte
+	for i := 0; i < 3; i++ {
+		line, _ := json.Marshal(stats.Record{UserID: 1, APIKeyID: 1, Model: "m", StatusCode: 200, CreatedAt: created})
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(spillPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := stats.NewCollector(database, config.UsageLogConfig{BufferSize: 16, BatchSize: 10, SpillPath: spillPath})
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got := countUsageLogs(t, database); got != 3 {
+		t.Errorf("expected 3 replayed rows, got %d", got)
+	}
+	logs, _, err := database.ListUsageLogs(0, "", "", "", "", 1, 10)
+	if err != nil || len(logs) == 0 {
+		t.Fatalf("list usage logs: %v", err)
+	}
+	if !logs[0].CreatedAt.Equal(created) {
+		t.Errorf("expected original created_at %v, got %v", created, logs[0].CreatedAt)
+	}
+	for _, p := range []string{spillPath, spillPath + ".replay"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed after replay", p)
+		}
+	}
+}
+
+func TestCollector_ReplaysPastLongAndCorruptSpillLines(t *testing.T) {
+	database := newTestDB(t)
+	spillPath := filepath.Join(t.TempDir(), "spill.jsonl")
+
+	line, _ := json.Marshal(stats.Record{UserID: 1, APIKeyID: 1, Model: "m", StatusCode: 200})
+	var data []byte
+	data = append(append(data, line...), '\n')
+	data = append(append(data, bytes.Repeat([]byte("x"), 2<<20)...), '\n')
+	for i := 0; i < 2; i++ {
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(spillPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := stats.NewCollector(database, config.UsageLogConfig{BufferSize: 16, BatchSize: 10, SpillPath: spillPath})
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got := countUsageLogs(t, database); got != 3 {
+		t.Errorf("expected the records around the corrupt line to be replayed, got %d rows", got)
+	}
+	if got := c.Stats().Dropped; got != 1 {
+		t.Errorf("expected the corrupt line to count as dropped, got %d", got)
+	}
+}
@@ -11,6 +11,8 @@ import AdminUsersPage from './pages/AdminUsersPage'
 import AdminApplicationsPage from './pages/AdminApplicationsPage'
 import AdminUsagePage from './pages/AdminUsagePage'
 import AdminBackendsPage from './pages/AdminBackendsPage'
+import AdminBackendConfigPage from './pages/AdminBackendConfigPage'
+import AdminPricingPage from './pages/AdminPricingPage'
 
 export default function App() {
   return (
@@ -29,6 +31,8 @@ export default function App() {
                 <Route path="/admin/applications" element={<AdminApplicationsPage />} />
                 <Route path="/admin/usage" element={<AdminUsagePage />} />
                 <Route path="/admin/backends" element={<AdminBackendsPage />} />
+                <Route path="/admin/backends/manage" element={<AdminBackendConfigPage />} />
+                <Route path="/admin/pricing" element={<AdminPricingPage />} />
               </Route>
             </Route>
           </Route>
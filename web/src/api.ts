@@ -53,6 +53,11 @@ export const adminCreateUser = (data: Record<string, unknown>) =>
   api.post('/admin/api/users', data)
 export const adminUpdateUser = (id: number, data: Record<string, unknown>) =>
   api.put(`/admin/api/users/${id}`, data)
+export const adminListUserModels = (id: number) => api.get(`/admin/api/users/${id}/models`)
+export const adminGrantUserModel = (id: number, model: string) =>
+  api.post(`/admin/api/users/${id}/models`, { model })
+export const adminRevokeUserModel = (id: number, model: string) =>
+  api.delete(`/admin/api/users/${id}/models`, { params: { model } })
 
 // Admin - Usage
 export const adminGetUsage = (params?: Record<string, string | number>) =>
@@ -72,3 +77,19 @@ export const adminReviewApplication = (
 // Admin - Backends
 export const adminGetBackendStats = (params?: Record<string, string>) =>
   api.get('/admin/api/backends/stats', { params })
+export const adminListBackends = () => api.get('/admin/api/backends')
+export const adminCreateBackend = (data: Record<string, unknown>) =>
+  api.post('/admin/api/backends', data)
+export const adminUpdateBackend = (id: number, data: Record<string, unknown>) =>
+  api.put(`/admin/api/backends/${id}`, data)
+export const adminDeleteBackend = (id: number) => api.delete(`/admin/api/backends/${id}`)
+
+// Admin - Pricing
+export const adminListPrices = () => api.get('/admin/api/pricing')
+export const adminCreatePrice = (data: Record<string, unknown>) =>
+  api.post('/admin/api/pricing', data)
+export const adminUpdatePrice = (id: number, data: Record<string, unknown>) =>
+  api.put(`/admin/api/pricing/${id}`, data)
+export const adminDeletePrice = (id: number) => api.delete(`/admin/api/pricing/${id}`)
+export const adminRecomputeCosts = (start_date: string, end_date: string) =>
+  api.post('/admin/api/pricing/recompute', { start_date, end_date })
@@ -14,6 +14,8 @@ const adminNav = [
   { to: '/admin/applications', label: '审批管理' },
   { to: '/admin/usage', label: '使用统计' },
   { to: '/admin/backends', label: 'Backend 统计' },
+  { to: '/admin/backends/manage', label: '后端管理' },
+  { to: '/admin/pricing', label: '价格管理' },
 ]
 
 export default function Layout() {
@@ -69,6 +71,7 @@ export default function Layout() {
                   <NavLink
                     key={item.to}
                     to={item.to}
+                    end
                     className={({ isActive }) =>
                       `flex items-center px-3 py-2 rounded-lg text-sm font-medium transition-all ${
                         isActive
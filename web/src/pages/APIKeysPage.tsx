@@ -4,7 +4,7 @@ import { listKeys, createKey, disableKey, enableKey, deleteKey } from '../api'
 interface APIKey {
   id: number
   name: string
-  key: string
+  key_hint: string
   status: string
   created_at: string
   expires_at: string | null
@@ -33,7 +33,6 @@ export default function APIKeysPage() {
   const [creating, setCreating] = useState(false)
   const [newKey, setNewKey] = useState('')
   const [error, setError] = useState('')
-  const [revealedId, setRevealedId] = useState<number | null>(null)
   const [copied, setCopied] = useState<number | null>(null)
 
   const handleCopy = (id: number, key: string) => {
@@ -109,7 +108,7 @@ export default function APIKeysPage() {
                   onClick={() => handleCopy(-1, newKey)}
                   className="px-3 py-2 text-sm bg-red-50 text-red-600 border border-red-100 rounded-xl hover:bg-red-100 transition-colors whitespace-nowrap"
                 >
-                  复制
+                  {copied === -1 ? '✓ 已复制' : '复制'}
                 </button>
               </div>
               <button
@@ -168,13 +167,7 @@ export default function APIKeysPage() {
               keys.map((k) => (
                 <tr key={k.id} className="hover:bg-gray-50/50 transition-colors">
                   <td className="px-4 py-3.5 font-medium text-gray-800">{k.name}</td>
-                  <td className="px-4 py-3.5 font-mono text-xs text-gray-500">
-                    {revealedId === k.id ? (
-                      <span className="break-all">{k.key}</span>
-                    ) : (
-                      <span>{k.key.slice(0, 12)}...</span>
-                    )}
-                  </td>
+                  <td className="px-4 py-3.5 font-mono text-xs text-gray-500">{k.key_hint}</td>
                   <td className="px-4 py-3.5">
                     <span
                       className={`inline-flex items-center px-2 py-0.5 rounded-md text-xs font-medium ring-1 ${
@@ -196,18 +189,6 @@ export default function APIKeysPage() {
                   </td>
                   <td className="px-4 py-3.5">
                     <div className="flex items-center gap-3">
-                      <button
-                        onClick={() => setRevealedId(revealedId === k.id ? null : k.id)}
-                        className="text-xs text-gray-400 hover:text-gray-700 transition-colors"
-                      >
-                        {revealedId === k.id ? '隐藏' : '查看'}
-                      </button>
-                      <button
-                        onClick={() => handleCopy(k.id, k.key)}
-                        className="text-xs text-gray-400 hover:text-gray-700 transition-colors"
-                      >
-                        {copied === k.id ? '✓ 已复制' : '复制'}
-                      </button>
                       <button
                         onClick={() => handleToggle(k)}
                         className={`text-xs transition-colors ${
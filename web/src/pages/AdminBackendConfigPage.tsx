@@ -0,0 +1,363 @@
+import { useEffect, useState } from 'react'
+import { adminListBackends, adminCreateBackend, adminUpdateBackend, adminDeleteBackend } from '../api'
+
+interface Backend {
+  id: number
+  name: string
+  url: string
+  weight: number
+  priority: number
+  max_in_flight: number
+  enabled: boolean
+  protocol: string
+  models: string[]
+  has_api_key: boolean
+  from_config: boolean
+  discovered_models?: string[]
+  health?: {
+    state: 'closed' | 'open' | 'half_open'
+    since: string
+    consecutive_failures: number
+    errors: number
+    last_error?: string
+  }
+}
+
+const HEALTH_LABELS: Record<string, { label: string; className: string }> = {
+  closed: { label: '正常', className: 'bg-green-50 text-green-700 ring-green-100' },
+  half_open: { label: '试探中', className: 'bg-amber-50 text-amber-700 ring-amber-100' },
+  open: { label: '熔断', className: 'bg-red-50 text-red-700 ring-red-100' },
+}
+
+interface FormState {
+  name: string
+  url: string
+  api_key: string
+  weight: string
+  priority: string
+  max_in_flight: string
+  enabled: boolean
+  protocol: string
+  models: string
+}
+
+const EMPTY_FORM: FormState = {
+  name: '', url: '', api_key: '', weight: '1', priority: '1', max_in_flight: '0', enabled: true, protocol: 'anthropic', models: '',
+}
+
+const inputClass =
+  'w-full px-3.5 py-2.5 border border-gray-200 rounded-xl text-sm bg-gray-50 focus:bg-white focus:outline-none focus:ring-2 focus:ring-red-500/30 focus:border-red-400 transition-all'
+
+function errorMessage(e: unknown, fallback: string) {
+  const msg = (e as { response?: { data?: { error?: string } } })?.response?.data?.error
+  return msg || fallback
+}
+
+export default function AdminBackendConfigPage() {
+  const [backends, setBackends] = useState<Backend[]>([])
+  const [loading, setLoading] = useState(true)
+  const [editingId, setEditingId] = useState<number | null>(null)
+  const [showForm, setShowForm] = useState(false)
+  const [form, setForm] = useState<FormState>(EMPTY_FORM)
+  const [error, setError] = useState('')
+  const [saving, setSaving] = useState(false)
+
+  const load = () => {
+    setLoading(true)
+    adminListBackends()
+      .then((res) => setBackends(res.data.backends || []))
+      .finally(() => setLoading(false))
+  }
+
+  useEffect(() => { load() }, [])
+
+  const openCreate = () => {
+    setEditingId(null)
+    setForm(EMPTY_FORM)
+    setError('')
+    setShowForm(true)
+  }
+
+  const openEdit = (b: Backend) => {
+    setEditingId(b.id)
+    setForm({
+      name: b.name,
+      url: b.url,
+      api_key: '',
+      weight: String(b.weight),
+      priority: String(b.priority),
+      max_in_flight: String(b.max_in_flight),
+      enabled: b.enabled,
+      protocol: b.protocol,
+      models: (b.models || []).join(', '),
+    })
+    setError('')
+    setShowForm(true)
+  }
+
+  const handleSave = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!form.name || !form.url) { setError('请输入名称和地址'); return }
+    if (editingId === null && !form.api_key) { setError('请输入 API Key'); return }
+    const data = {
+      name: form.name,
+      url: form.url,
+      api_key: form.api_key,
+      weight: parseInt(form.weight) || 1,
+      priority: parseInt(form.priority) || 1,
+      max_in_flight: parseInt(form.max_in_flight) || 0,
+      enabled: form.enabled,
+      protocol: form.protocol,
+      models: form.models.split(',').map((m) => m.trim()).filter(Boolean),
+    }
+    setSaving(true)
+    setError('')
+    try {
+      if (editingId === null) await adminCreateBackend(data)
+      else await adminUpdateBackend(editingId, data)
+      setShowForm(false)
+      load()
+    } catch (e: unknown) {
+      setError(errorMessage(e, '保存失败'))
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  const handleToggle = async (b: Backend) => {
+    await adminUpdateBackend(b.id, { ...b, api_key: '', enabled: !b.enabled })
+    load()
+  }
+
+  const handleDelete = async (b: Backend) => {
+    if (!confirm(`删除后端 ${b.name}？进行中的请求会继续完成。`)) return
+    await adminDeleteBackend(b.id)
+    load()
+  }
+
+  return (
+    <div className="p-8">
+      <div className="flex items-center justify-between mb-7">
+        <div>
+          <h2 className="text-xl font-bold text-gray-900">后端管理</h2>
+          <p className="text-sm text-gray-400 mt-0.5">修改立即生效，无需重启；进行中的请求会在原后端上完成</p>
+        </div>
+        <button
+          onClick={openCreate}
+          className="px-4 py-2 bg-red-600 text-white text-sm font-medium rounded-xl hover:bg-red-700 shadow-sm hover:shadow-md transition-all"
+        >
+          + 新增后端
+        </button>
+      </div>
+
+      {showForm && (
+        <div className="mb-6 bg-white border border-gray-100 rounded-xl p-5 shadow-sm">
+          <h3 className="text-sm font-semibold text-gray-700 mb-4">{editingId === null ? '新增后端' : '编辑后端'}</h3>
+          <form onSubmit={handleSave} className="space-y-3">
+            <div className="grid grid-cols-3 gap-3">
+              <div>
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">名称</label>
+                <input value={form.name} onChange={(e) => setForm((s) => ({ ...s, name: e.target.value }))} className={inputClass} />
+              </div>
+              <div className="col-span-2">
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">地址</label>
+                <input
+                  value={form.url}
+                  onChange={(e) => setForm((s) => ({ ...s, url: e.target.value }))}
+                  placeholder="https://api.anthropic.com"
+                  className={inputClass}
+                />
+              </div>
+              <div className="col-span-2">
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">API Key</label>
+                <input
+                  type="password"
+                  value={form.api_key}
+                  onChange={(e) => setForm((s) => ({ ...s, api_key: e.target.value }))}
+                  placeholder={editingId === null ? '' : '留空保持不变'}
+                  autoComplete="new-password"
+                  className={inputClass}
+                />
+              </div>
+              <div>
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">协议</label>
+                <select
+                  value={form.protocol}
+                  onChange={(e) => setForm((s) => ({ ...s, protocol: e.target.value }))}
+                  className={inputClass}
+                >
+                  <option value="anthropic">anthropic</option>
+                  <option value="openai">openai</option>
+                </select>
+              </div>
+              <div className="col-span-2">
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">模型白名单（逗号分隔，支持通配符，留空为全部）</label>
+                <input
+                  value={form.models}
+                  onChange={(e) => setForm((s) => ({ ...s, models: e.target.value }))}
+                  placeholder="claude-sonnet-4*, claude-haiku-*"
+                  className={inputClass}
+                />
+              </div>
+              <div>
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">权重</label>
+                <input
+                  type="number"
+                  min={1}
+                  value={form.weight}
+                  onChange={(e) => setForm((s) => ({ ...s, weight: e.target.value }))}
+                  className={inputClass}
+                />
+              </div>
+              <div>
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">优先级（1 最先使用）</label>
+                <input
+                  type="number"
+                  min={1}
+                  value={form.priority}
+                  onChange={(e) => setForm((s) => ({ ...s, priority: e.target.value }))}
+                  className={inputClass}
+                />
+              </div>
+              <div>
+                <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">最大并发（0 为不限）</label>
+                <input
+                  type="number"
+                  min={0}
+                  value={form.max_in_flight}
+                  onChange={(e) => setForm((s) => ({ ...s, max_in_flight: e.target.value }))}
+                  className={inputClass}
+                />
+              </div>
+            </div>
+            <label className="flex items-center gap-2 text-sm text-gray-600">
+              <input
+                type="checkbox"
+                checked={form.enabled}
+                onChange={(e) => setForm((s) => ({ ...s, enabled: e.target.checked }))}
+              />
+              启用
+            </label>
+            {error && <p className="text-sm text-red-600">{error}</p>}
+            <div className="flex gap-2">
+              <button
+                type="submit"
+                disabled={saving}
+                className="px-4 py-2.5 bg-red-600 text-white text-sm font-medium rounded-xl hover:bg-red-700 disabled:opacity-50 transition-colors"
+              >
+                {saving ? '保存中...' : '确认'}
+              </button>
+              <button
+                type="button"
+                onClick={() => setShowForm(false)}
+                className="px-4 py-2.5 text-sm border border-gray-200 rounded-xl hover:bg-gray-50 transition-colors"
+              >
+                取消
+              </button>
+            </div>
+          </form>
+        </div>
+      )}
+
+      <div className="bg-white rounded-xl border border-gray-100 shadow-sm overflow-hidden">
+        <table className="w-full text-sm">
+          <thead className="bg-gray-50/80">
+            <tr>
+              {['名称', '地址', '协议', '权重', '模型', 'API Key', '状态', '健康', '操作'].map((h) => (
+                <th key={h} className="px-4 py-3 text-left text-xs font-semibold text-gray-400 uppercase tracking-wide">
+                  {h}
+                </th>
+              ))}
+            </tr>
+          </thead>
+          <tbody className="divide-y divide-gray-50">
+            {loading ? (
+              <tr>
+                <td colSpan={9} className="px-4 py-10 text-center text-sm text-gray-400">加载中...</td>
+              </tr>
+            ) : backends.length === 0 ? (
+              <tr>
+                <td colSpan={9} className="px-4 py-10 text-center text-sm text-gray-400">暂无后端</td>
+              </tr>
+            ) : (
+              backends.map((b) => (
+                <tr key={b.id} className="hover:bg-gray-50/50 transition-colors">
+                  <td className="px-4 py-3.5 font-mono text-xs font-semibold text-gray-700">{b.name}</td>
+                  <td className="px-4 py-3.5 text-xs text-gray-500 break-all">{b.url}</td>
+                  <td className="px-4 py-3.5 text-xs text-gray-500">{b.protocol}</td>
+                  <td className="px-4 py-3.5 text-gray-600">
+                    {b.weight}
+                    <span className="block text-xs text-gray-400">
+                      优先级 {b.priority}{b.max_in_flight > 0 ? ` · 并发 ≤ ${b.max_in_flight}` : ''}
+                    </span>
+                  </td>
+                  <td className="px-4 py-3.5 font-mono text-xs text-gray-500">
+                    {b.models && b.models.length > 0 ? (
+                      b.models.join(', ')
+                    ) : b.discovered_models ? (
+                      <span title={b.discovered_models.join('\n')}>自动发现 {b.discovered_models.length} 个</span>
+                    ) : (
+                      '全部'
+                    )}
+                  </td>
+                  <td className="px-4 py-3.5 text-xs text-gray-400">{b.has_api_key ? '已设置' : '未设置'}</td>
+                  <td className="px-4 py-3.5">
+                    <span
+                      className={`inline-flex items-center px-2 py-0.5 rounded-md text-xs font-medium ring-1 ${
+                        b.enabled
+                          ? 'bg-green-50 text-green-700 ring-green-100'
+                          : 'bg-gray-100 text-gray-500 ring-gray-200'
+                      }`}
+                    >
+                      {b.enabled ? '启用' : '禁用'}
+                    </span>
+                  </td>
+                  <td className="px-4 py-3.5">
+                    {b.health ? (
+                      <span
+                        title={[
+                          `自 ${new Date(b.health.since).toLocaleString()}`,
+                          `累计错误 ${b.health.errors} 次`,
+                          b.health.last_error ? `最近错误：${b.health.last_error}` : '',
+                        ].filter(Boolean).join('\n')}
+                        className={`inline-flex items-center px-2 py-0.5 rounded-md text-xs font-medium ring-1 ${
+                          HEALTH_LABELS[b.health.state]?.className
+                        }`}
+                      >
+                        {HEALTH_LABELS[b.health.state]?.label ?? b.health.state}
+                      </span>
+                    ) : (
+                      <span className="text-xs text-gray-300">—</span>
+                    )}
+                  </td>
+                  <td className="px-4 py-3.5">
+                    {b.from_config ? (
+                      <span className="text-xs text-gray-400" title="在配置文件中声明，请修改配置文件">配置文件</span>
+                    ) : (
+                      <div className="flex items-center gap-3">
+                        <button onClick={() => openEdit(b)} className="text-xs text-red-500 hover:text-red-700 font-medium transition-colors">
+                          编辑
+                        </button>
+                        <button
+                          onClick={() => handleToggle(b)}
+                          className={`text-xs transition-colors ${
+                            b.enabled ? 'text-amber-500 hover:text-amber-700' : 'text-green-600 hover:text-green-800'
+                          }`}
+                        >
+                          {b.enabled ? '禁用' : '启用'}
+                        </button>
+                        <button onClick={() => handleDelete(b)} className="text-xs text-gray-400 hover:text-red-600 transition-colors">
+                          删除
+                        </button>
+                      </div>
+                    )}
+                  </td>
+                </tr>
+              ))
+            )}
+          </tbody>
+        </table>
+      </div>
+    </div>
+  )
+}
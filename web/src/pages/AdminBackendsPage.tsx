@@ -10,6 +10,31 @@ interface BackendStat {
   error_count: number
 }
 
+interface BackendLoad {
+  name: string
+  state: 'closed' | 'open' | 'half_open'
+  in_flight: number
+  max_in_flight: number
+  latency_ewma_ms: number
+  weight: number
+  priority: number
+  score: number
+}
+
+const STRATEGY_LABELS: Record<string, string> = {
+  weighted_random: '加权随机',
+  least_in_flight: '最少并发',
+  peak_ewma: 'Peak EWMA 延迟',
+  p2c: '二选一（P2C）',
+  priority: '优先级分层',
+}
+
+const STATE_LABELS: Record<string, string> = {
+  closed: '正常',
+  half_open: '试探中',
+  open: '熔断',
+}
+
 function toDateStr(d: Date) {
   return d.toISOString().slice(0, 10)
 }
@@ -38,12 +63,18 @@ function SkeletonRow() {
 export default function AdminBackendsPage() {
   const [date, setDate] = useState(() => toDateStr(new Date()))
   const [stats, setStats] = useState<BackendStat[]>([])
+  const [live, setLive] = useState<BackendLoad[]>([])
+  const [strategy, setStrategy] = useState('')
   const [loading, setLoading] = useState(true)
 
   const load = (d: string) => {
     setLoading(true)
     adminGetBackendStats({ start_date: d, end_date: d })
-      .then((res) => setStats(res.data.stats || []))
+      .then((res) => {
+        setStats(res.data.stats || [])
+        setLive(res.data.live || [])
+        setStrategy(res.data.strategy || '')
+      })
       .finally(() => setLoading(false))
   }
 
@@ -114,6 +145,43 @@ export default function AdminBackendsPage() {
         ) : null}
       </div>
 
+      {live.length > 0 && (
+        <div className="bg-white rounded-xl border border-gray-100 shadow-sm overflow-hidden mb-6">
+          <div className="px-4 py-3 border-b border-gray-50 flex items-center gap-2">
+            <span className="text-sm font-semibold text-gray-700">实时负载</span>
+            <span className="inline-flex items-center px-2 py-0.5 rounded-md text-xs font-medium bg-gray-100 text-gray-600">
+              {STRATEGY_LABELS[strategy] ?? strategy}
+            </span>
+          </div>
+          <table className="w-full text-sm">
+            <thead className="bg-gray-50/80">
+              <tr>
+                {['Backend', '状态', '并发', '延迟 (EWMA)', '权重', '优先级', '得分'].map((h) => (
+                  <th key={h} className="px-4 py-3 text-left text-xs font-semibold text-gray-400 uppercase tracking-wide">
+                    {h}
+                  </th>
+                ))}
+              </tr>
+            </thead>
+            <tbody className="divide-y divide-gray-50">
+              {live.map((l) => (
+                <tr key={l.name} className="hover:bg-gray-50/50 transition-colors">
+                  <td className="px-4 py-3.5 font-mono text-xs font-semibold text-gray-700">{l.name}</td>
+                  <td className="px-4 py-3.5 text-xs text-gray-500">{STATE_LABELS[l.state] ?? l.state}</td>
+                  <td className="px-4 py-3.5 text-gray-700 tabular-nums">
+                    {l.in_flight}{l.max_in_flight > 0 ? ` / ${l.max_in_flight}` : ''}
+                  </td>
+                  <td className="px-4 py-3.5 text-gray-500 tabular-nums">{Math.round(l.latency_ewma_ms)} ms</td>
+                  <td className="px-4 py-3.5 text-gray-600">{l.weight}</td>
+                  <td className="px-4 py-3.5 text-gray-600">{l.priority}</td>
+                  <td className="px-4 py-3.5 text-gray-500 tabular-nums">{l.score.toFixed(3)}</td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        </div>
+      )}
+
       <div className="bg-white rounded-xl border border-gray-100 shadow-sm overflow-hidden">
         <table className="w-full text-sm">
           <thead className="bg-gray-50/80">
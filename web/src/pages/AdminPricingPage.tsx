@@ -0,0 +1,261 @@
+import { useEffect, useState } from 'react'
+import {
+  adminListPrices, adminCreatePrice, adminUpdatePrice, adminDeletePrice, adminRecomputeCosts,
+} from '../api'
+
+interface Price {
+  id: number
+  model: string
+  input_price: number
+  output_price: number
+  cache_write_price: number
+  cache_write_1h_price: number
+  cache_read_price: number
+  effective_from: string
+}
+
+interface FormState {
+  model: string
+  input_price: string
+  output_price: string
+  cache_write_price: string
+  cache_write_1h_price: string
+  cache_read_price: string
+  effective_from: string
+}
+
+const EMPTY_FORM: FormState = {
+  model: '', input_price: '', output_price: '', cache_write_price: '', cache_write_1h_price: '', cache_read_price: '', effective_from: '',
+}
+
+const FIELDS: { key: keyof FormState; label: string; type: string }[] = [
+  { key: 'model', label: '模型（支持通配符）', type: 'text' },
+  { key: 'input_price', label: '输入', type: 'number' },
+  { key: 'output_price', label: '输出', type: 'number' },
+  { key: 'cache_write_price', label: '缓存写入（5 分钟）', type: 'number' },
+  { key: 'cache_write_1h_price', label: '缓存写入（1 小时）', type: 'number' },
+  { key: 'cache_read_price', label: '缓存读取', type: 'number' },
+  { key: 'effective_from', label: '生效日期', type: 'date' },
+]
+
+const inputClass =
+  'w-full px-3.5 py-2.5 border border-gray-200 rounded-xl text-sm bg-gray-50 focus:bg-white focus:outline-none focus:ring-2 focus:ring-red-500/30 focus:border-red-400 transition-all'
+
+function errorMessage(e: unknown, fallback: string) {
+  const msg = (e as { response?: { data?: { error?: string } } })?.response?.data?.error
+  return msg || fallback
+}
+
+export default function AdminPricingPage() {
+  const [prices, setPrices] = useState<Price[]>([])
+  const [loading, setLoading] = useState(true)
+  const [editingId, setEditingId] = useState<number | null>(null)
+  const [showForm, setShowForm] = useState(false)
+  const [form, setForm] = useState<FormState>(EMPTY_FORM)
+  const [error, setError] = useState('')
+  const [saving, setSaving] = useState(false)
+  const [recompute, setRecompute] = useState({ start: '', end: '' })
+  const [recomputeMsg, setRecomputeMsg] = useState('')
+
+  const load = () => {
+    setLoading(true)
+    adminListPrices()
+      .then((res) => setPrices(res.data.prices || []))
+      .finally(() => setLoading(false))
+  }
+
+  useEffect(() => { load() }, [])
+
+  const openCreate = () => {
+    setEditingId(null)
+    setForm(EMPTY_FORM)
+    setError('')
+    setShowForm(true)
+  }
+
+  const openEdit = (p: Price) => {
+    setEditingId(p.id)
+    setForm({
+      model: p.model,
+      input_price: String(p.input_price),
+      output_price: String(p.output_price),
+      cache_write_price: String(p.cache_write_price),
+      cache_write_1h_price: String(p.cache_write_1h_price),
+      cache_read_price: String(p.cache_read_price),
+      effective_from: p.effective_from,
+    })
+    setError('')
+    setShowForm(true)
+  }
+
+  const handleSave = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!form.model) { setError('请输入模型'); return }
+    const data = {
+      model: form.model,
+      input_price: parseFloat(form.input_price) || 0,
+      output_price: parseFloat(form.output_price) || 0,
+      cache_write_price: parseFloat(form.cache_write_price) || 0,
+      cache_write_1h_price: parseFloat(form.cache_write_1h_price) || 0,
+      cache_read_price: parseFloat(form.cache_read_price) || 0,
+      effective_from: form.effective_from,
+    }
+    setSaving(true)
+    setError('')
+    try {
+      if (editingId === null) await adminCreatePrice(data)
+      else await adminUpdatePrice(editingId, data)
+      setShowForm(false)
+      load()
+    } catch (e: unknown) {
+      setError(errorMessage(e, '保存失败'))
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  const handleDelete = async (p: Price) => {
+    if (!confirm(`删除 ${p.model} 的价格？`)) return
+    await adminDeletePrice(p.id)
+    load()
+  }
+
+  const handleRecompute = async () => {
+    if (!recompute.start || !recompute.end) { setRecomputeMsg('请选择日期范围'); return }
+    setRecomputeMsg('重算中...')
+    try {
+      const res = await adminRecomputeCosts(recompute.start, recompute.end)
+      setRecomputeMsg(`已更新 ${res.data.updated} 条记录`)
+    } catch (e: unknown) {
+      setRecomputeMsg(errorMessage(e, '重算失败'))
+    }
+  }
+
+  return (
+    <div className="p-8">
+      <div className="flex items-center justify-between mb-7">
+        <div>
+          <h2 className="text-xl font-bold text-gray-900">价格管理</h2>
+          <p className="text-sm text-gray-400 mt-0.5">模型单价（美元 / 百万 Token），修改后立即对新请求生效</p>
+        </div>
+        <button
+          onClick={openCreate}
+          className="px-4 py-2 bg-red-600 text-white text-sm font-medium rounded-xl hover:bg-red-700 shadow-sm hover:shadow-md transition-all"
+        >
+          + 新增价格
+        </button>
+      </div>
+
+      {showForm && (
+        <div className="mb-6 bg-white border border-gray-100 rounded-xl p-5 shadow-sm">
+          <h3 className="text-sm font-semibold text-gray-700 mb-4">{editingId === null ? '新增价格' : '编辑价格'}</h3>
+          <form onSubmit={handleSave} className="space-y-3">
+            <div className="grid grid-cols-6 gap-3">
+              {FIELDS.map((f) => (
+                <div key={f.key}>
+                  <label className="block text-xs font-semibold text-gray-500 mb-1.5 uppercase tracking-wide">{f.label}</label>
+                  <input
+                    type={f.type}
+                    step="any"
+                    value={form[f.key]}
+                    onChange={(e) => setForm((s) => ({ ...s, [f.key]: e.target.value }))}
+                    className={inputClass}
+                  />
+                </div>
+              ))}
+            </div>
+            {error && <p className="text-sm text-red-600">{error}</p>}
+            <div className="flex gap-2">
+              <button
+                type="submit"
+                disabled={saving}
+                className="px-4 py-2.5 bg-red-600 text-white text-sm font-medium rounded-xl hover:bg-red-700 disabled:opacity-50 transition-colors"
+              >
+                {saving ? '保存中...' : '确认'}
+              </button>
+              <button
+                type="button"
+                onClick={() => setShowForm(false)}
+                className="px-4 py-2.5 text-sm border border-gray-200 rounded-xl hover:bg-gray-50 transition-colors"
+              >
+                取消
+              </button>
+            </div>
+          </form>
+        </div>
+      )}
+
+      <div className="bg-white rounded-xl border border-gray-100 shadow-sm overflow-hidden mb-6">
+        <table className="w-full text-sm">
+          <thead className="bg-gray-50/80">
+            <tr>
+              {['模型', '输入', '输出', '缓存写入 5m', '缓存写入 1h', '缓存读取', '生效日期', '操作'].map((h) => (
+                <th key={h} className="px-4 py-3 text-left text-xs font-semibold text-gray-400 uppercase tracking-wide">
+                  {h}
+                </th>
+              ))}
+            </tr>
+          </thead>
+          <tbody className="divide-y divide-gray-50">
+            {loading ? (
+              <tr>
+                <td colSpan={8} className="px-4 py-10 text-center text-sm text-gray-400">加载中...</td>
+              </tr>
+            ) : prices.length === 0 ? (
+              <tr>
+                <td colSpan={8} className="px-4 py-10 text-center text-sm text-gray-400">暂无数据</td>
+              </tr>
+            ) : (
+              prices.map((p) => (
+                <tr key={p.id} className="hover:bg-gray-50/50 transition-colors">
+                  <td className="px-4 py-3.5 font-mono text-xs text-gray-700">{p.model}</td>
+                  <td className="px-4 py-3.5 text-gray-600">${p.input_price}</td>
+                  <td className="px-4 py-3.5 text-gray-600">${p.output_price}</td>
+                  <td className="px-4 py-3.5 text-gray-600">${p.cache_write_price}</td>
+                  <td className="px-4 py-3.5 text-gray-600">${p.cache_write_1h_price}</td>
+                  <td className="px-4 py-3.5 text-gray-600">${p.cache_read_price}</td>
+                  <td className="px-4 py-3.5 text-gray-400 text-xs">{p.effective_from || '始终'}</td>
+                  <td className="px-4 py-3.5 space-x-3">
+                    <button onClick={() => openEdit(p)} className="text-xs text-red-500 hover:text-red-700 font-medium transition-colors">
+                      编辑
+                    </button>
+                    <button onClick={() => handleDelete(p)} className="text-xs text-gray-400 hover:text-red-600 font-medium transition-colors">
+                      删除
+                    </button>
+                  </td>
+                </tr>
+              ))
+            )}
+          </tbody>
+        </table>
+      </div>
+
+      <div className="bg-white border border-gray-100 rounded-xl p-5 shadow-sm">
+        <h3 className="text-sm font-semibold text-gray-700 mb-1">重算费用</h3>
+        <p className="text-xs text-gray-400 mb-4">修正价格后，按当前价格表重新计算指定日期范围内的请求费用，并刷新每日统计</p>
+        <div className="flex items-center gap-3">
+          <input
+            type="date"
+            value={recompute.start}
+            onChange={(e) => setRecompute((s) => ({ ...s, start: e.target.value }))}
+            className={`${inputClass} max-w-[180px]`}
+          />
+          <span className="text-gray-400 text-sm">至</span>
+          <input
+            type="date"
+            value={recompute.end}
+            onChange={(e) => setRecompute((s) => ({ ...s, end: e.target.value }))}
+            className={`${inputClass} max-w-[180px]`}
+          />
+          <button
+            onClick={handleRecompute}
+            className="px-4 py-2.5 bg-red-600 text-white text-sm font-medium rounded-xl hover:bg-red-700 transition-colors"
+          >
+            重算
+          </button>
+          {recomputeMsg && <span className="text-sm text-gray-500">{recomputeMsg}</span>}
+        </div>
+      </div>
+    </div>
+  )
+}
@@ -23,6 +23,9 @@ interface UsageLog {
   total_tokens: number
   cost_usd: number
   status_code: number
+  request_id: string
+  upstream_request_id: string
+  client_ip: string
   created_at: string
 }
 
@@ -48,6 +51,7 @@ export default function AdminUsagePage() {
   const [dailyStats, setDailyStats] = useState<DailyStat[]>([])
   const [total, setTotal] = useState(0)
   const [page, setPage] = useState(1)
+  const [requestId, setRequestId] = useState('')
   const [loading, setLoading] = useState(true)
   const pageSize = 20
 
@@ -60,13 +64,17 @@ export default function AdminUsagePage() {
 
   useEffect(() => {
     setLoading(true)
-    adminGetUsage({ page, page_size: pageSize, start_date: date, end_date: date })
+    // A request ID lookup searches all dates.
+    const params = requestId
+      ? { page, page_size: pageSize, request_id: requestId }
+      : { page, page_size: pageSize, start_date: date, end_date: date }
+    adminGetUsage(params)
       .then((res) => {
         setLogs(res.data.logs || [])
         setTotal(res.data.total || 0)
       })
       .finally(() => setLoading(false))
-  }, [date, page])
+  }, [date, page, requestId])
 
   const shiftDate = (days: number) => {
     setPage(1)
@@ -92,7 +100,15 @@ export default function AdminUsagePage() {
           <h2 className="text-xl font-bold text-gray-900">使用统计</h2>
           <p className="text-sm text-gray-400 mt-0.5">全局 API 调用记录</p>
         </div>
-        <div className="flex items-center gap-1.5 ml-auto bg-white border border-gray-200 rounded-xl px-2 py-1.5 shadow-sm">
+        <input
+          type="text"
+          placeholder="按 Request ID 查找"
+          onKeyDown={(e) => {
+            if (e.key === 'Enter') { setPage(1); setRequestId(e.currentTarget.value.trim()) }
+          }}
+          className="ml-auto w-64 px-3 py-2 text-sm bg-white border border-gray-200 rounded-xl shadow-sm focus:outline-none focus:ring-2 focus:ring-red-100"
+        />
+        <div className="flex items-center gap-1.5 bg-white border border-gray-200 rounded-xl px-2 py-1.5 shadow-sm">
           <button
             onClick={() => shiftDate(-1)}
             className="w-7 h-7 flex items-center justify-center rounded-lg text-gray-500 hover:bg-gray-100 transition-colors text-sm font-medium"
@@ -132,7 +148,9 @@ export default function AdminUsagePage() {
 
       <div className="bg-white rounded-xl border border-gray-100 shadow-sm overflow-hidden">
         <div className="px-6 py-4 border-b border-gray-100 flex items-center justify-between">
-          <h3 className="text-sm font-semibold text-gray-700">{date} 请求记录</h3>
+          <h3 className="text-sm font-semibold text-gray-700">
+            {requestId ? `Request ID ${requestId}` : `${date} 请求记录`}
+          </h3>
           <span className="text-xs text-gray-400">共 {total} 条</span>
         </div>
         <table className="w-full text-sm">
@@ -150,7 +168,9 @@ export default function AdminUsagePage() {
               Array.from({ length: 5 }).map((_, i) => <SkeletonRow key={i} />)
             ) : logs.length === 0 ? (
               <tr>
-                <td colSpan={7} className="px-4 py-10 text-center text-sm text-gray-400">当天暂无数据</td>
+                <td colSpan={7} className="px-4 py-10 text-center text-sm text-gray-400">
+                  {requestId ? '未找到该请求' : '当天暂无数据'}
+                </td>
               </tr>
             ) : (
               logs.map((log) => (
@@ -173,7 +193,10 @@ export default function AdminUsagePage() {
                       {log.status_code}
                     </span>
                   </td>
-                  <td className="px-4 py-3.5 text-gray-400 text-xs">
+                  <td
+                    className="px-4 py-3.5 text-gray-400 text-xs"
+                    title={[log.request_id, log.upstream_request_id, log.client_ip].filter(Boolean).join('\n')}
+                  >
                     {new Date(log.created_at).toLocaleString()}
                   </td>
                 </tr>
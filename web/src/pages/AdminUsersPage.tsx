@@ -14,6 +14,7 @@ interface User {
   last_used_at: string | null
   requests: number
   cost_usd: number
+  quota?: { used: number; remaining: number }
 }
 
 interface UsageLog {
@@ -305,7 +306,15 @@ export default function AdminUsersPage() {
                         {u.status === 'active' ? '正常' : '禁用'}
                       </span>
                     </td>
-                    <td className="px-4 py-3.5 text-gray-600">{u.quota_tokens?.toLocaleString() || '0'}</td>
+                    <td className="px-4 py-3.5 text-gray-600">
+                      {u.quota_tokens > 0 ? (
+                        <span title={`剩余 ${(u.quota?.remaining ?? 0).toLocaleString()}`}>
+                          {(u.quota?.used ?? 0).toLocaleString()} / {u.quota_tokens.toLocaleString()}
+                        </span>
+                      ) : (
+                        '不限'
+                      )}
+                    </td>
                     <td className="px-4 py-3.5 text-gray-600">{(u.requests || 0).toLocaleString()}</td>
                     <td className="px-4 py-3.5 font-medium text-gray-800">${(u.cost_usd || 0).toFixed(4)}</td>
                     <td className="px-4 py-3.5 text-gray-400 text-xs">
@@ -48,6 +48,7 @@ function StatCardSkeleton() {
 export default function DashboardPage() {
   const [logs, setLogs] = useState<UsageLog[]>([])
   const [total, setTotal] = useState(0)
+  const [quota, setQuota] = useState<{ limit: number; remaining: number } | null>(null)
   const [loading, setLoading] = useState(true)
   const [hourlyData, setHourlyData] = useState<{ hour: string; cost: number }[]>([])
   const [dailyData, setDailyData] = useState<{ date: string; cost: number }[]>([])
@@ -57,6 +58,7 @@ export default function DashboardPage() {
       .then((res) => {
         setLogs(res.data.logs || [])
         setTotal(res.data.total || 0)
+        setQuota(res.data.quota || null)
       })
       .finally(() => setLoading(false))
 
@@ -105,18 +107,24 @@ export default function DashboardPage() {
       </div>
 
       {/* Stat cards */}
-      <div className="grid grid-cols-3 gap-4 mb-7">
+      <div className="grid grid-cols-4 gap-4 mb-7">
         {loading ? (
           <>
             <StatCardSkeleton />
             <StatCardSkeleton />
             <StatCardSkeleton />
+            <StatCardSkeleton />
           </>
         ) : (
           <>
             <StatCard label="总请求数" value={total} accent="blue" />
             <StatCard label="Token 消耗（近5条）" value={totalTokens.toLocaleString()} accent="purple" />
             <StatCard label="费用（近5条）" value={`$${totalCost.toFixed(4)}`} accent="red" />
+            <StatCard
+              label="剩余配额"
+              value={quota && quota.limit > 0 ? quota.remaining.toLocaleString() : '不限'}
+              accent="blue"
+            />
           </>
         )}
       </div>
@@ -7,8 +7,11 @@ import {
 interface UsageLog {
   id: number
   model: string
+  requested_model?: string
   input_tokens: number
   output_tokens: number
+  cache_creation_tokens: number
+  cache_read_tokens: number
   total_tokens: number
   cost_usd: number
   status_code: number
@@ -18,7 +21,7 @@ interface UsageLog {
 function SkeletonRow() {
   return (
     <tr>
-      {[130, 70, 70, 80, 70, 60, 100].map((w, i) => (
+      {[130, 70, 70, 90, 80, 70, 60, 100].map((w, i) => (
         <td key={i} className="px-4 py-3.5">
           <div className="skeleton h-3.5 rounded" style={{ width: w }} />
         </td>
@@ -83,7 +86,7 @@ export default function UsagePage() {
         <table className="w-full text-sm">
           <thead className="bg-gray-50/80">
             <tr>
-              {['模型', '输入 Token', '输出 Token', '总 Token', '费用', '状态', '时间'].map((h) => (
+              {['模型', '输入 Token', '输出 Token', '缓存 读/写', '总 Token', '费用', '状态', '时间'].map((h) => (
                 <th key={h} className="px-4 py-3 text-left text-xs font-semibold text-gray-400 uppercase tracking-wide">
                   {h}
                 </th>
@@ -95,14 +98,22 @@ export default function UsagePage() {
               Array.from({ length: 5 }).map((_, i) => <SkeletonRow key={i} />)
             ) : logs.length === 0 ? (
               <tr>
-                <td colSpan={7} className="px-4 py-10 text-center text-sm text-gray-400">暂无数据</td>
+                <td colSpan={8} className="px-4 py-10 text-center text-sm text-gray-400">暂无数据</td>
               </tr>
             ) : (
               logs.map((log) => (
                 <tr key={log.id} className="hover:bg-gray-50/50 transition-colors">
-                  <td className="px-4 py-3.5 font-mono text-xs text-gray-600">{log.model}</td>
+                  <td className="px-4 py-3.5 font-mono text-xs text-gray-600">
+                    {log.model}
+                    {log.requested_model && log.requested_model !== log.model && (
+                      <span className="block text-gray-400">请求：{log.requested_model}</span>
+                    )}
+                  </td>
                   <td className="px-4 py-3.5 text-gray-600">{log.input_tokens.toLocaleString()}</td>
                   <td className="px-4 py-3.5 text-gray-600">{log.output_tokens.toLocaleString()}</td>
+                  <td className="px-4 py-3.5 text-gray-600">
+                    {(log.cache_read_tokens ?? 0).toLocaleString()} / {(log.cache_creation_tokens ?? 0).toLocaleString()}
+                  </td>
                   <td className="px-4 py-3.5 font-medium text-gray-800">{log.total_tokens.toLocaleString()}</td>
                   <td className="px-4 py-3.5 font-medium text-gray-800">${log.cost_usd.toFixed(4)}</td>
                   <td className="px-4 py-3.5">